@@ -0,0 +1,703 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tzmfreedom/go-soapforce"
+	"github.com/urfave/cli"
+)
+
+const (
+	// Bulk API 2.0 is available since API version 41.0.
+	bulk2MinApiVersion = 41.0
+	// bulk2MaxUploadSize keeps each job below the 150MB upload limit (100MB before base64 encoding).
+	bulk2MaxUploadSize = 100 * 1024 * 1024
+	bulk2NullValue     = "#N/A"
+)
+
+type bulk2Job struct {
+	Id                     string `json:"id,omitempty"`
+	Object                 string `json:"object,omitempty"`
+	Operation              string `json:"operation,omitempty"`
+	Query                  string `json:"query,omitempty"`
+	ExternalIdFieldName    string `json:"externalIdFieldName,omitempty"`
+	ContentType            string `json:"contentType,omitempty"`
+	LineEnding             string `json:"lineEnding,omitempty"`
+	State                  string `json:"state,omitempty"`
+	ErrorMessage           string `json:"errorMessage,omitempty"`
+	NumberRecordsProcessed int    `json:"numberRecordsProcessed,omitempty"`
+	NumberRecordsFailed    int    `json:"numberRecordsFailed,omitempty"`
+}
+
+type bulk2Error struct {
+	ErrorCode string `json:"errorCode"`
+	Message   string `json:"message"`
+}
+
+// bulk2Client is a minimal client of the Bulk API 2.0 ingest and query jobs.
+type bulk2Client struct {
+	httpClient   *http.Client
+	instanceUrl  string
+	sessionId    string
+	apiVersion   string
+	pollInterval time.Duration
+	// waitTimeout stops waiting for a job which is not processed in time, or
+	// waits until it is processed when it is 0.
+	waitTimeout time.Duration
+	// retry retries the polls of the jobs, and refreshes sessionId when it expires.
+	retry *retrier
+}
+
+func newBulk2Client(instanceUrl string, sessionId string, apiVersion string) *bulk2Client {
+	if v, err := strconv.ParseFloat(apiVersion, 64); err != nil || v < bulk2MinApiVersion {
+		apiVersion = fmt.Sprintf("%.1f", bulk2MinApiVersion)
+	}
+	return &bulk2Client{
+		httpClient:   http.DefaultClient,
+		instanceUrl:  strings.TrimSuffix(instanceUrl, "/"),
+		sessionId:    sessionId,
+		apiVersion:   apiVersion,
+		pollInterval: 5 * time.Second,
+	}
+}
+
+func (c *bulk2Client) CreateIngestJob(object string, operation string, externalIdFieldName string) (*bulk2Job, error) {
+	job := &bulk2Job{
+		Object:              object,
+		Operation:           operation,
+		ExternalIdFieldName: externalIdFieldName,
+		ContentType:         "CSV",
+		LineEnding:          "LF",
+	}
+	res := &bulk2Job{}
+	err := c.requestJson(http.MethodPost, "/jobs/ingest/", job, res)
+	return res, err
+}
+
+func (c *bulk2Client) UploadJobData(id string, data []byte) error {
+	_, err := c.request(http.MethodPut, "/jobs/ingest/"+id+"/batches", "text/csv", bytes.NewReader(data))
+	return err
+}
+
+func (c *bulk2Client) CloseJob(id string) error {
+	return c.requestJson(http.MethodPatch, "/jobs/ingest/"+id+"/", &bulk2Job{State: "UploadComplete"}, &bulk2Job{})
+}
+
+func (c *bulk2Client) AbortJob(id string) error {
+	return c.requestJson(http.MethodPatch, "/jobs/ingest/"+id+"/", &bulk2Job{State: "Aborted"}, &bulk2Job{})
+}
+
+// WaitIngestJob polls the job until it is processed.
+func (c *bulk2Client) WaitIngestJob(id string) (*bulk2Job, error) {
+	return c.waitJob(id, "/jobs/ingest/"+id+"/")
+}
+
+// GetIngestResults returns the successfulResults, failedResults or
+// unprocessedrecords of the job as CSV records including the header.
+func (c *bulk2Client) GetIngestResults(id string, kind string) ([][]string, error) {
+	body, err := c.request(http.MethodGet, "/jobs/ingest/"+id+"/"+kind+"/", "", nil)
+	if err != nil {
+		return nil, err
+	}
+	return readBulk2Csv(body)
+}
+
+func (c *bulk2Client) CreateQueryJob(query string, operation string) (*bulk2Job, error) {
+	job := &bulk2Job{
+		Operation:   operation,
+		Query:       query,
+		ContentType: "CSV",
+		LineEnding:  "LF",
+	}
+	res := &bulk2Job{}
+	err := c.requestJson(http.MethodPost, "/jobs/query", job, res)
+	return res, err
+}
+
+func (c *bulk2Client) WaitQueryJob(id string) (*bulk2Job, error) {
+	return c.waitJob(id, "/jobs/query/"+id)
+}
+
+// GetQueryResults returns a page of the query results and the locator of the
+// next page, which is empty on the last page.
+func (c *bulk2Client) GetQueryResults(id string, locator string) ([][]string, string, error) {
+	path := "/jobs/query/" + id + "/results"
+	if locator != "" {
+		path += "?locator=" + url.QueryEscape(locator)
+	}
+	req, err := c.newRequest(http.MethodGet, path, "", nil)
+	if err != nil {
+		return nil, "", err
+	}
+	res, err := c.do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	next := res.Header.Get("Sforce-Locator")
+	if next == "null" {
+		next = ""
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	records, err := readBulk2Csv(body)
+	return records, next, err
+}
+
+// waitJob polls the job until it is processed. The job keeps running when the
+// polls fail or time out, so the error has its Id to check it in Setup.
+func (c *bulk2Client) waitJob(id string, path string) (*bulk2Job, error) {
+	var deadline time.Time
+	if c.waitTimeout > 0 {
+		deadline = time.Now().Add(c.waitTimeout)
+	}
+	for {
+		job := &bulk2Job{}
+		err := c.retry.Do(func() error {
+			return c.requestJson(http.MethodGet, path, nil, job)
+		})
+		if err != nil {
+			return nil, fmt.Errorf("bulk job %s: %s", id, err)
+		}
+		switch job.State {
+		case "JobComplete":
+			return job, nil
+		case "Failed", "Aborted":
+			return job, fmt.Errorf("bulk job %s is %s: %s", id, job.State, job.ErrorMessage)
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return job, fmt.Errorf("bulk job %s is still %s after %s", id, job.State, c.waitTimeout)
+		}
+		time.Sleep(c.pollInterval)
+	}
+}
+
+func (c *bulk2Client) requestJson(method string, path string, body interface{}, result interface{}) error {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		r = bytes.NewReader(b)
+	}
+	b, err := c.request(method, path, "application/json", r)
+	if err != nil {
+		return err
+	}
+	if result == nil || len(b) == 0 {
+		return nil
+	}
+	return json.Unmarshal(b, result)
+}
+
+func (c *bulk2Client) request(method string, path string, contentType string, body io.Reader) ([]byte, error) {
+	req, err := c.newRequest(method, path, contentType, body)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	return ioutil.ReadAll(res.Body)
+}
+
+func (c *bulk2Client) newRequest(method string, path string, contentType string, body io.Reader) (*http.Request, error) {
+	endpoint := fmt.Sprintf("%s/services/data/v%s%s", c.instanceUrl, c.apiVersion, path)
+	req, err := http.NewRequest(method, endpoint, body)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.sessionId)
+	req.Header.Set("Accept", "application/json")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	return req, nil
+}
+
+func (c *bulk2Client) do(req *http.Request) (*http.Response, error) {
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode < 300 {
+		return res, nil
+	}
+	defer res.Body.Close()
+	b, _ := ioutil.ReadAll(res.Body)
+	errs := []*bulk2Error{}
+	if err := json.Unmarshal(b, &errs); err == nil && len(errs) > 0 {
+		return nil, fmt.Errorf("%s: %s", errs[0].ErrorCode, errs[0].Message)
+	}
+	return nil, fmt.Errorf("%s %s: %s", req.Method, req.URL.Path, res.Status)
+}
+
+func readBulk2Csv(b []byte) ([][]string, error) {
+	if len(bytes.TrimSpace(b)) == 0 {
+		return [][]string{}, nil
+	}
+	r := csv.NewReader(bytes.NewReader(b))
+	r.FieldsPerRecord = -1
+	return r.ReadAll()
+}
+
+// getInstanceUrl derives the REST instance URL from the SOAP server URL.
+func getInstanceUrl(serverUrl string) (string, error) {
+	u, err := url.Parse(serverUrl)
+	if err != nil {
+		return "", err
+	}
+	return u.Scheme + "://" + u.Host, nil
+}
+
+func newBulk2ClientFromLogin(client *soapforce.Client, c *cli.Context) (*bulk2Client, error) {
+	res, err := authenticate(client, c)
+	if err != nil {
+		return nil, err
+	}
+	instanceUrl, err := getInstanceUrl(res.ServerUrl)
+	if err != nil {
+		return nil, err
+	}
+	bulk := newBulk2Client(instanceUrl, res.SessionId, c.String("api-version"))
+	bulk.waitTimeout = c.Duration("wait-timeout")
+	bulk.retry = newLoginRetrier(c.Int("max-retries"), func() error {
+		res, err := authenticate(client, c)
+		if err != nil {
+			return err
+		}
+		bulk.sessionId = res.SessionId
+		return nil
+	})
+	return bulk, nil
+}
+
+// bulk2Dml loads the input file through Bulk API 2.0 ingest jobs. The CSV is
+// built from the same reader and mapping as the SOAP path and split into
+// several jobs when it exceeds the upload limit.
+func bulk2Dml(c *cli.Context, operation string) error {
+	client := newClient(c)
+	bulk, err := newBulk2ClientFromLogin(client, c)
+	if err != nil {
+		return err
+	}
+
+	in, err := openDmlInput(c, operation)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	loader := &bulk2Loader{
+		client:          bulk,
+		handler:         in.handler,
+		checkpoint:      in.checkpoint,
+		convert:         in.Convert,
+		resolve:         in.Resolve,
+		object:          c.String("type"),
+		operation:       operation,
+		externalIdField: c.String("upsert-key"),
+		insertNulls:     c.Bool("insert-nulls"),
+	}
+	headers := in.headers
+	if operation != "delete" && operation != "hardDelete" {
+		describe, err := describeSObject(client, c.String("type"))
+		if err != nil {
+			return err
+		}
+		in.converter, err = newValueConverterFromFlags(c, describe, in)
+		if err != nil {
+			return err
+		}
+		if err := validateRelationshipColumns(describe, in.headers); err != nil {
+			return err
+		}
+		headers = bulk2Headers(describe, in.headers)
+	}
+	in.resolver = newReferenceResolver(in.mapping, newSoapQuerier(client, newRetrier(c, client)))
+	if err := loader.Load(headers, in.reader); err != nil {
+		return err
+	}
+	return in.checkpoint.Remove()
+}
+
+// bulk2Headers returns the headers of the uploaded CSV.
+func bulk2Headers(describe *soapforce.DescribeSObjectResult, headers []string) []string {
+	references := newReferenceMap(describe)
+	converted := make([]string, len(headers))
+	for i, h := range headers {
+		converted[i] = bulk2RelationshipHeader(references, h)
+	}
+	return converted
+}
+
+type bulk2Loader struct {
+	client          *bulk2Client
+	handler         responseHandler
+	checkpoint      *checkpoint
+	convert         func(record []string) ([]string, []*soapforce.Error)
+	resolve         func(records [][]string) ([][]*soapforce.Error, error)
+	object          string
+	operation       string
+	externalIdField string
+	insertNulls     bool
+}
+
+func (l *bulk2Loader) Load(headers []string, reader Reader) error {
+	columns := l.columns(headers)
+	chunk := l.newChunk(headers, columns)
+	// converted rows are resolved in batches before they are added to the chunk
+	pending := &dmlBatch{}
+	flush := func() error {
+		if len(pending.records) == 0 {
+			return nil
+		}
+		var errors [][]*soapforce.Error
+		if l.resolve != nil {
+			var err error
+			if errors, err = l.resolve(pending.records); err != nil {
+				return err
+			}
+		}
+		for i, fields := range pending.records {
+			if i < len(errors) && len(errors[i]) > 0 {
+				if err := l.reject(pending.rows[i], pending.inputs[i], errors[i]); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := chunk.add(pending.rows[i], pending.inputs[i], fields); err != nil {
+				return err
+			}
+			if chunk.buf.Len() >= bulk2MaxUploadSize {
+				if err := l.run(chunk); err != nil {
+					return err
+				}
+				chunk = l.newChunk(headers, columns)
+			}
+		}
+		pending = &dmlBatch{}
+		return nil
+	}
+	row := 0
+	for {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		row++
+		if fields == nil || l.checkpoint.IsLoaded(row) {
+			continue
+		}
+		input := fields
+		if l.convert != nil {
+			converted, errors := l.convert(fields)
+			if len(errors) > 0 {
+				if err := l.reject(row, fields, errors); err != nil {
+					return err
+				}
+				continue
+			}
+			fields = converted
+		}
+		pending.rows = append(pending.rows, row)
+		pending.records = append(pending.records, fields)
+		pending.inputs = append(pending.inputs, input)
+		if len(pending.records) == dmlBatchSize {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+	if len(chunk.batch.records) == 0 {
+		return nil
+	}
+	return l.run(chunk)
+}
+
+// reject writes the errors of a row which is not uploaded.
+func (l *bulk2Loader) reject(row int, input []string, errors []*soapforce.Error) error {
+	batch := &dmlBatch{rows: []int{row}, records: [][]string{input}}
+	return l.handler.Handle(batch, []*soapforce.SaveResult{{Errors: errors}})
+}
+
+// columns returns the indexes of the input columns uploaded for the operation.
+func (l *bulk2Loader) columns(headers []string) []int {
+	columns := []int{}
+	for i, h := range headers {
+		switch {
+		case isResultColumn(h):
+		case l.operation == "delete" || l.operation == "hardDelete":
+			if strings.EqualFold(h, "Id") {
+				columns = append(columns, i)
+			}
+		case l.operation == "insert" && strings.EqualFold(h, "Id"):
+		default:
+			columns = append(columns, i)
+		}
+	}
+	return columns
+}
+
+// bulk2Chunk is the CSV data of one ingest job together with the source rows,
+// which are matched with the job results by the uploaded values.
+type bulk2Chunk struct {
+	columns     []int
+	insertNulls bool
+	buf         *bytes.Buffer
+	w           *csv.Writer
+	batch       *dmlBatch
+	keys        map[string][]int
+}
+
+func (l *bulk2Loader) newChunk(headers []string, columns []int) *bulk2Chunk {
+	buf := new(bytes.Buffer)
+	chunk := &bulk2Chunk{
+		columns:     columns,
+		insertNulls: l.insertNulls,
+		buf:         buf,
+		w:           csv.NewWriter(buf),
+		batch:       &dmlBatch{},
+		keys:        map[string][]int{},
+	}
+	header := make([]string, len(columns))
+	for i, column := range columns {
+		header[i] = headers[column]
+	}
+	chunk.w.Write(header)
+	chunk.w.Flush()
+	return chunk
+}
+
+func (c *bulk2Chunk) add(row int, input []string, fields []string) error {
+	values := make([]string, len(c.columns))
+	for i, column := range c.columns {
+		if column < len(fields) {
+			values[i] = fields[column]
+		}
+		if c.insertNulls && values[i] == "" {
+			values[i] = bulk2NullValue
+		}
+	}
+	if err := c.w.Write(values); err != nil {
+		return err
+	}
+	c.w.Flush()
+	key := strings.Join(values, "\x00")
+	c.keys[key] = append(c.keys[key], len(c.batch.records))
+	c.batch.rows = append(c.batch.rows, row)
+	c.batch.records = append(c.batch.records, fields)
+	c.batch.inputs = append(c.batch.inputs, input)
+	return nil
+}
+
+// index returns the position in the chunk of the row uploaded with the values.
+func (c *bulk2Chunk) index(values []string) int {
+	key := strings.Join(values, "\x00")
+	indexes := c.keys[key]
+	if len(indexes) == 0 {
+		return -1
+	}
+	c.keys[key] = indexes[1:]
+	return indexes[0]
+}
+
+func (l *bulk2Loader) run(chunk *bulk2Chunk) error {
+	job, err := l.client.CreateIngestJob(l.object, l.operation, l.externalIdField)
+	if err != nil {
+		return err
+	}
+	if err := l.client.UploadJobData(job.Id, chunk.buf.Bytes()); err != nil {
+		_ = l.client.AbortJob(job.Id)
+		return err
+	}
+	if err := l.client.CloseJob(job.Id); err != nil {
+		return err
+	}
+	if _, err := l.client.WaitIngestJob(job.Id); err != nil {
+		return err
+	}
+	results, err := l.results(job.Id, chunk)
+	if err != nil {
+		return err
+	}
+	if err := l.handler.Handle(chunk.batch, results); err != nil {
+		return err
+	}
+	l.checkpoint.Acknowledge(chunk.batch, true)
+	return l.checkpoint.Save(l.handler)
+}
+
+// results converts the job results into SaveResults in the order of the source
+// rows, so that they are written by the responseHandler in the same format as
+// the SOAP API results.
+func (l *bulk2Loader) results(id string, chunk *bulk2Chunk) ([]*soapforce.SaveResult, error) {
+	results := make([]*soapforce.SaveResult, len(chunk.batch.records))
+	set := func(values []string, result *soapforce.SaveResult) {
+		if i := chunk.index(values); i >= 0 {
+			results[i] = result
+		}
+	}
+	records, err := l.client.GetIngestResults(id, "successfulResults")
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range bulk2ResultRecords(records) {
+		set(record.values, &soapforce.SaveResult{Id: record.fields["sf__Id"], Success: true})
+	}
+	records, err = l.client.GetIngestResults(id, "failedResults")
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range bulk2ResultRecords(records) {
+		set(record.values, &soapforce.SaveResult{
+			Id:     record.fields["sf__Id"],
+			Errors: []*soapforce.Error{{Message: record.fields["sf__Error"]}},
+		})
+	}
+	records, err = l.client.GetIngestResults(id, "unprocessedrecords")
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range bulk2ResultRecords(records) {
+		set(record.values, &soapforce.SaveResult{
+			Errors: []*soapforce.Error{{Message: "record was not processed"}},
+		})
+	}
+	for i, result := range results {
+		if result == nil {
+			results[i] = &soapforce.SaveResult{
+				Errors: []*soapforce.Error{{Message: "result of the record is not found"}},
+			}
+		}
+	}
+	return results, nil
+}
+
+type bulk2ResultRecord struct {
+	// fields has every column of the result including sf__Id and sf__Error.
+	fields map[string]string
+	// values are the uploaded values, without the sf__ columns.
+	values []string
+}
+
+func bulk2ResultRecords(records [][]string) []*bulk2ResultRecord {
+	if len(records) == 0 {
+		return nil
+	}
+	header := records[0]
+	results := make([]*bulk2ResultRecord, len(records)-1)
+	for i, record := range records[1:] {
+		result := &bulk2ResultRecord{fields: map[string]string{}, values: []string{}}
+		for j, h := range header {
+			value := ""
+			if j < len(record) {
+				value = record[j]
+			}
+			result.fields[h] = value
+			if !strings.HasPrefix(h, "sf__") {
+				result.values = append(result.values, value)
+			}
+		}
+		results[i] = result
+	}
+	return results
+}
+
+// bulk2Query exports the query results through a Bulk API 2.0 query job.
+func bulk2Query(c *cli.Context, bulk *bulk2Client, q string, soql *soqlQuery) error {
+	for _, item := range soql.Fields {
+		if item.Subquery != nil {
+			return errors.New("subqueries are not supported by Bulk API 2.0")
+		}
+	}
+	job, err := bulk.CreateQueryJob(q, "query")
+	if err != nil {
+		return err
+	}
+	if _, err := bulk.WaitQueryJob(job.Id); err != nil {
+		return err
+	}
+	writer, err := getWriter(c)
+	if err != nil {
+		return err
+	}
+	defer writer.Close()
+
+	locator := ""
+	headerWritten := false
+	for {
+		records, next, err := bulk.GetQueryResults(job.Id, locator)
+		if err != nil {
+			return err
+		}
+		if len(records) > 0 {
+			headers := records[0]
+			if !headerWritten {
+				if err := writer.Header(headers); err != nil {
+					return err
+				}
+				headerWritten = true
+			}
+			for _, record := range records[1:] {
+				if err := writer.Write(headers, newSObjectFromCsv(soql.From, headers, record)); err != nil {
+					return err
+				}
+			}
+		}
+		if next == "" {
+			return nil
+		}
+		locator = next
+	}
+}
+
+// newSObjectFromCsv builds an SObject from a Bulk API CSV record, expanding
+// dotted relationship columns into nested SObjects.
+func newSObjectFromCsv(sObjectType string, headers []string, record []string) *soapforce.SObject {
+	sobject := &soapforce.SObject{Type: sObjectType, Fields: map[string]interface{}{}}
+	for i, h := range headers {
+		if i >= len(record) {
+			break
+		}
+		keys := strings.Split(h, ".")
+		current := sobject
+		for _, key := range keys[:len(keys)-1] {
+			child, ok := current.Fields[key].(*soapforce.SObject)
+			if !ok {
+				child = &soapforce.SObject{Fields: map[string]interface{}{}}
+				current.Fields[key] = child
+			}
+			current = child
+		}
+		last := keys[len(keys)-1]
+		if strings.EqualFold(last, "Id") {
+			current.Id = record[i]
+		} else {
+			current.Fields[last] = record[i]
+		}
+	}
+	return sobject
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tzmfreedom/go-soapforce"
+)
+
+type recordingResponseHandler struct {
+	NoopResponseWriteHandler
+	rows    []int
+	results []*soapforce.SaveResult
+}
+
+func (h *recordingResponseHandler) Handle(batch *dmlBatch, results []*soapforce.SaveResult) error {
+	h.rows = append(h.rows, batch.rows...)
+	h.results = append(h.results, results...)
+	return nil
+}
+
+// newBulk2StandIn serves the Bulk API 2.0 ingest endpoints for a single job.
+func newBulk2StandIn(t *testing.T, uploaded *string) *httptest.Server {
+	polled := 0
+	mux := http.NewServeMux()
+	mux.HandleFunc("/services/data/v41.0/jobs/ingest/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer SESSION" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`[{"errorCode":"INVALID_SESSION_ID","message":"Session expired or invalid"}]`))
+			return
+		}
+		path := strings.TrimPrefix(r.URL.Path, "/services/data/v41.0/jobs/ingest/")
+		switch {
+		case path == "" && r.Method == http.MethodPost:
+			job := &bulk2Job{}
+			json.NewDecoder(r.Body).Decode(job)
+			if job.Object != "Account" || job.Operation != "insert" {
+				t.Errorf("unexpected job: %v", job)
+			}
+			w.Write([]byte(`{"id":"750x","state":"Open"}`))
+		case path == "750x/batches" && r.Method == http.MethodPut:
+			b, _ := ioutil.ReadAll(r.Body)
+			*uploaded = string(b)
+			w.WriteHeader(http.StatusCreated)
+		case path == "750x/" && r.Method == http.MethodPatch:
+			w.Write([]byte(`{"id":"750x","state":"UploadComplete"}`))
+		case path == "750x/" && r.Method == http.MethodGet:
+			polled++
+			if polled < 2 {
+				w.Write([]byte(`{"id":"750x","state":"InProgress"}`))
+				return
+			}
+			w.Write([]byte(`{"id":"750x","state":"JobComplete"}`))
+		case path == "750x/successfulResults/":
+			w.Write([]byte("\"sf__Id\",\"sf__Created\",\"Name\",\"Description\"\n\"001a\",\"true\",\"a\",\"#N/A\"\n"))
+		case path == "750x/failedResults/":
+			w.Write([]byte("\"sf__Id\",\"sf__Error\",\"Name\",\"Description\"\n\"\",\"REQUIRED_FIELD_MISSING:Required fields are missing: [Name]:Name --\",\"#N/A\",\"desc\"\n"))
+		case path == "750x/unprocessedrecords/":
+			w.Write([]byte("\"Name\",\"Description\"\n"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	return httptest.NewServer(mux)
+}
+
+func TestBulk2Load(t *testing.T) {
+	uploaded := ""
+	server := newBulk2StandIn(t, &uploaded)
+	defer server.Close()
+
+	client := newBulk2Client(server.URL, "SESSION", "38.0")
+	client.pollInterval = time.Millisecond
+	handler := &recordingResponseHandler{}
+	loader := &bulk2Loader{
+		client:      client,
+		handler:     handler,
+		object:      "Account",
+		operation:   "insert",
+		insertNulls: true,
+	}
+	reader, err := newCsvReader("test/bulk2.csv", "utf8", "", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer reader.Close()
+	headers, err := reader.Read()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := loader.Load(headers, reader); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	expected := "Name,Description\na,#N/A\n#N/A,desc\n"
+	if uploaded != expected {
+		t.Fatalf("expected: '%s', but '%s'", expected, uploaded)
+	}
+	if len(handler.results) != 2 {
+		t.Fatalf("expected %d, but %d", 2, len(handler.results))
+	}
+	if handler.rows[0] != 1 || handler.rows[1] != 2 {
+		t.Fatalf("expected [1 2], but %v", handler.rows)
+	}
+	if !handler.results[0].Success || handler.results[0].Id != "001a" {
+		t.Fatalf("unexpected result: %v", handler.results[0])
+	}
+	if handler.results[1].Success || !strings.HasPrefix(handler.results[1].Errors[0].Message, "REQUIRED_FIELD_MISSING") {
+		t.Fatalf("unexpected result: %v", handler.results[1])
+	}
+}
+
+func TestBulk2Error(t *testing.T) {
+	uploaded := ""
+	server := newBulk2StandIn(t, &uploaded)
+	defer server.Close()
+
+	client := newBulk2Client(server.URL, "INVALID", "41.0")
+	_, err := client.CreateIngestJob("Account", "insert", "")
+	if err == nil {
+		t.Fatal("expected error, but nil")
+	}
+	expected := "INVALID_SESSION_ID: Session expired or invalid"
+	if err.Error() != expected {
+		t.Fatalf("expected: '%s', but '%s'", expected, err.Error())
+	}
+}
+
+func TestBulk2WaitJob(t *testing.T) {
+	uploaded := ""
+	server := newBulk2StandIn(t, &uploaded)
+	defer server.Close()
+
+	// the expired session is refreshed by the retrier
+	client := newBulk2Client(server.URL, "INVALID", "41.0")
+	client.pollInterval = time.Millisecond
+	client.retry = newLoginRetrier(1, func() error {
+		client.sessionId = "SESSION"
+		return nil
+	})
+	job, err := client.WaitIngestJob("750x")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if job.State != "JobComplete" {
+		t.Fatalf("expected: '%s', but '%s'", "JobComplete", job.State)
+	}
+
+	timeout := newBulk2StandIn(t, &uploaded)
+	defer timeout.Close()
+	client = newBulk2Client(timeout.URL, "SESSION", "41.0")
+	client.waitTimeout = time.Nanosecond
+	_, err = client.WaitIngestJob("750x")
+	if err == nil {
+		t.Fatal("expected error, but nil")
+	}
+	expected := "bulk job 750x is still InProgress after 1ns"
+	if err.Error() != expected {
+		t.Fatalf("expected: '%s', but '%s'", expected, err.Error())
+	}
+}
+
+func TestBulk2QueryResults(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("locator") == "" {
+			w.Header().Set("Sforce-Locator", "MTAw")
+			w.Write([]byte("\"Id\",\"Name\",\"Owner.Name\"\n\"001a\",\"a\",\"owner\"\n"))
+			return
+		}
+		w.Header().Set("Sforce-Locator", "null")
+		w.Write([]byte("\"Id\",\"Name\",\"Owner.Name\"\n\"001b\",\"b\",\"\"\n"))
+	}))
+	defer server.Close()
+
+	client := newBulk2Client(server.URL, "SESSION", "45.0")
+	records, locator, err := client.GetQueryResults("750q", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if locator != "MTAw" || len(records) != 2 {
+		t.Fatalf("unexpected results: %v, %s", records, locator)
+	}
+	sobject := newSObjectFromCsv("Account", records[0], records[1])
+	if sobject.Id != "001a" || sobject.Fields["Name"] != "a" {
+		t.Fatalf("unexpected sobject: %v", sobject)
+	}
+	if actual := getField(newCaseInsensitiveMap(sobject.Fields), "Owner.Name"); actual != "owner" {
+		t.Fatalf("expected: '%s', but '%s'", "owner", actual)
+	}
+
+	_, locator, err = client.GetQueryResults("750q", locator)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if locator != "" {
+		t.Fatalf("expected empty locator, but '%s'", locator)
+	}
+}
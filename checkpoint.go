@@ -0,0 +1,264 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/urfave/cli"
+)
+
+// checkpoint records the progress of a DML command, so that a failed load
+// can be resumed with --resume from the first row that was not acknowledged.
+type checkpoint struct {
+	Command  string `json:"command"`
+	Type     string `json:"type"`
+	File     string `json:"file"`
+	FileHash string `json:"fileHash"`
+	// FileSize and FileModTime tell that the file is unchanged without hashing it.
+	FileSize    int64     `json:"fileSize"`
+	FileModTime time.Time `json:"fileModTime"`
+	// LastRow is the last source row up to which all results are written to
+	// the success or error file.
+	LastRow int `json:"lastRow"`
+	// Rows are the rows after LastRow whose results are written, because their
+	// batches completed while an earlier batch failed.
+	Rows []int `json:"rows,omitempty"`
+	// Uncertain are the rows after LastRow sent by a create call which lost
+	// the connection, so their records may have been created. They are written
+	// to the error file on resume instead of being sent again.
+	Uncertain     []int `json:"uncertain,omitempty"`
+	SuccessOffset int64 `json:"successOffset"`
+	ErrorOffset   int64 `json:"errorOffset"`
+
+	path    string
+	resumed bool
+	// hashed is closed when the hash of the input file, which is computed in
+	// the background so that the load starts without reading the whole file,
+	// is set to hash.
+	hashed chan struct{}
+	hash   string
+	// skip are the Rows of the previous run. Rows up to LastRow are skipped by the reader.
+	skip map[int]bool
+	// uncertain are the Uncertain rows of the previous run.
+	uncertain map[int]bool
+}
+
+// resultOffsetter is implemented by response handlers that write to files, so
+// that the files can be truncated to the checkpoint on resume.
+type resultOffsetter interface {
+	Offsets() (int64, int64, error)
+}
+
+func getCheckpointPath(c *cli.Context) string {
+	if path := c.String("checkpoint"); path != "" {
+		return path
+	}
+	return c.String("file") + ".checkpoint"
+}
+
+// getCheckpoint returns the checkpoint of the command. With --resume, the
+// saved checkpoint is loaded and verified against the input file.
+func getCheckpoint(c *cli.Context, command string) (*checkpoint, error) {
+	file := c.String("file")
+	info, err := os.Stat(file)
+	if err != nil {
+		return nil, err
+	}
+	path := getCheckpointPath(c)
+	if !c.Bool("resume") {
+		cp := &checkpoint{
+			Command:     command,
+			Type:        c.String("type"),
+			File:        file,
+			FileSize:    info.Size(),
+			FileModTime: info.ModTime(),
+			path:        path,
+			hashed:      make(chan struct{}),
+		}
+		go func() {
+			// a file which cannot be read fails the load anyway
+			cp.hash, _ = hashFile(file)
+			close(cp.hashed)
+		}()
+		if start := c.Int("start-row"); start > 1 {
+			cp.LastRow = start - 1
+		}
+		return cp, nil
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("checkpoint is not found: %s", path)
+		}
+		return nil, err
+	}
+	cp := &checkpoint{}
+	if err := json.Unmarshal(b, cp); err != nil {
+		return nil, fmt.Errorf("checkpoint is invalid: %s: %s", path, err)
+	}
+	if cp.Command != command || cp.Type != c.String("type") {
+		return nil, fmt.Errorf("checkpoint is for %s %s, not %s %s", cp.Command, cp.Type, command, c.String("type"))
+	}
+	changed, err := cp.fileChanged(file, info)
+	if err != nil {
+		return nil, err
+	}
+	if changed {
+		return nil, fmt.Errorf("%s has changed since the checkpoint was saved", file)
+	}
+	cp.path = path
+	cp.resumed = true
+	cp.skip = map[int]bool{}
+	for _, row := range cp.Rows {
+		cp.skip[row] = true
+	}
+	cp.uncertain = map[int]bool{}
+	for _, row := range cp.Uncertain {
+		cp.uncertain[row] = true
+	}
+	return cp, nil
+}
+
+// fileChanged compares the size and the modification time of the file with
+// the checkpoint first, and hashes the file only when the size is the same
+// but the modification time is not, e.g. when the file is copied. Without the
+// hash, which was not computed before the checkpoint was saved, the file is
+// taken as changed.
+func (cp *checkpoint) fileChanged(file string, info os.FileInfo) (bool, error) {
+	if info.Size() != cp.FileSize {
+		return true, nil
+	}
+	if info.ModTime().Equal(cp.FileModTime) {
+		return false, nil
+	}
+	if cp.FileHash == "" {
+		return true, nil
+	}
+	hash, err := hashFile(file)
+	if err != nil {
+		return false, err
+	}
+	return hash != cp.FileHash, nil
+}
+
+// Acknowledge records the rows of a batch whose results are written. With
+// inOrder, all rows up to the batch are acknowledged.
+func (cp *checkpoint) Acknowledge(batch *dmlBatch, inOrder bool) {
+	if cp == nil || len(batch.rows) == 0 {
+		return
+	}
+	if !inOrder {
+		cp.Rows = append(cp.Rows, batch.rows...)
+		return
+	}
+	cp.LastRow = batch.rows[len(batch.rows)-1]
+	cp.Rows = rowsAfter(cp.Rows, cp.LastRow)
+	cp.Uncertain = rowsAfter(cp.Uncertain, cp.LastRow)
+}
+
+// MarkUncertain records the rows whose records may have been created by a
+// create call which lost the connection.
+func (cp *checkpoint) MarkUncertain(rows []int) {
+	if cp == nil {
+		return
+	}
+	cp.Uncertain = append(cp.Uncertain, rows...)
+}
+
+func rowsAfter(rows []int, lastRow int) []int {
+	after := []int{}
+	for _, row := range rows {
+		if row > lastRow {
+			after = append(after, row)
+		}
+	}
+	return after
+}
+
+// IsLoaded reports whether the row after LastRow was loaded by the previous run.
+func (cp *checkpoint) IsLoaded(row int) bool {
+	if cp == nil {
+		return false
+	}
+	return cp.skip[row]
+}
+
+// IsUncertain reports whether the row may have been created by the previous run.
+func (cp *checkpoint) IsUncertain(row int) bool {
+	if cp == nil {
+		return false
+	}
+	return cp.uncertain[row]
+}
+
+// Save persists the progress with the offsets of the result files.
+func (cp *checkpoint) Save(handler responseHandler) error {
+	if cp == nil {
+		return nil
+	}
+	if cp.FileHash == "" && cp.hashed != nil {
+		select {
+		case <-cp.hashed:
+			cp.FileHash = cp.hash
+		default:
+		}
+	}
+	if o, ok := handler.(resultOffsetter); ok {
+		successOffset, errorOffset, err := o.Offsets()
+		if err != nil {
+			return err
+		}
+		cp.SuccessOffset = successOffset
+		cp.ErrorOffset = errorOffset
+	}
+	b, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(cp.path), filepath.Base(cp.path))
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), cp.path)
+}
+
+// Remove deletes the checkpoint when the command completes.
+func (cp *checkpoint) Remove() error {
+	if cp == nil {
+		return nil
+	}
+	if err := os.Remove(cp.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tzmfreedom/go-soapforce"
+	"github.com/urfave/cli"
+)
+
+func newDmlContext(t *testing.T, dir string, resume bool) *cli.Context {
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	for _, f := range insertFlags {
+		f.Apply(set)
+	}
+	args := []string{
+		"--file", filepath.Join(dir, "input.csv"),
+		"--type", "Account",
+		"--success-file", filepath.Join(dir, "success.csv"),
+		"--error-file", filepath.Join(dir, "error.csv"),
+	}
+	if resume {
+		args = append(args, "--resume")
+	}
+	if err := set.Parse(args); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return cli.NewContext(cli.NewApp(), set, nil)
+}
+
+func runDmlWithCheckpoint(t *testing.T, c *cli.Context, failAt int, failErr error) ([]int, error) {
+	in, err := openDmlInput(c, "insert")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer in.Close()
+	rows := []int{}
+	runner := newDmlRunner(c, in, nil, func(batch *dmlBatch) (dmlResult, error) {
+		if batch.rows[0] == failAt {
+			return nil, failErr
+		}
+		rows = append(rows, batch.rows...)
+		res := make([]*soapforce.SaveResult, len(batch.records))
+		for i, record := range batch.records {
+			res[i] = &soapforce.SaveResult{Id: record[0], Success: true}
+		}
+		return func(h responseHandler) error { return h.Handle(batch, res) }, nil
+	})
+	return rows, runner.Run(in.reader)
+}
+
+func TestResumeDmlLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "yasd")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	input := "Name\n"
+	for i := 1; i <= 450; i++ {
+		input += fmt.Sprintf("%d\n", i)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "input.csv"), []byte(input), 0644); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	_, err = runDmlWithCheckpoint(t, newDmlContext(t, dir, false), 401, errors.New("connection reset by peer"))
+	if err == nil || err.Error() != "connection reset by peer" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "input.csv.checkpoint"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !strings.Contains(string(b), `"lastRow":400`) {
+		t.Fatalf("unexpected checkpoint: %s", string(b))
+	}
+	// results written after the checkpoint are discarded on resume
+	f, _ := os.OpenFile(filepath.Join(dir, "success.csv"), os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString("garbage\n")
+	f.Close()
+
+	rows, err := runDmlWithCheckpoint(t, newDmlContext(t, dir, true), 0, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(rows) != 50 || rows[0] != 401 {
+		t.Fatalf("expected rows from 401 to 450, but %v", rows)
+	}
+	b, _ = ioutil.ReadFile(filepath.Join(dir, "success.csv"))
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	if len(lines) != 451 {
+		t.Fatalf("expected %d, but %d", 451, len(lines))
+	}
+	for i, line := range lines[1:] {
+		expected := fmt.Sprintf("%d,%d,%d", i+1, i+1, i+1)
+		if line != expected {
+			t.Fatalf("expected: '%s', but '%s'", expected, line)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(dir, "input.csv.checkpoint")); !os.IsNotExist(err) {
+		t.Fatalf("expected checkpoint to be removed, but %v", err)
+	}
+}
+
+func TestResumeRejectsUncertainRows(t *testing.T) {
+	dir, err := ioutil.TempDir("", "yasd")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	input := "Name\n"
+	for i := 1; i <= 450; i++ {
+		input += fmt.Sprintf("%d\n", i)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "input.csv"), []byte(input), 0644); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	_, err = runDmlWithCheckpoint(t, newDmlContext(t, dir, false), 201, createOnce(errors.New("read: connection reset by peer")))
+	if _, ok := err.(*createError); !ok {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "input.csv.checkpoint"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !strings.Contains(string(b), `"lastRow":200`) || !strings.Contains(string(b), `"uncertain":[201,202,`) {
+		t.Fatalf("unexpected checkpoint: %s", string(b))
+	}
+
+	// the rows of the lost call are not sent again
+	rows, err := runDmlWithCheckpoint(t, newDmlContext(t, dir, true), 0, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, row := range rows {
+		if row <= 400 {
+			t.Fatalf("unexpected rows: %v", rows)
+		}
+	}
+	b, _ = ioutil.ReadFile(filepath.Join(dir, "error.csv"))
+	lines := strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	if len(lines) != 201 || !strings.HasPrefix(lines[1], "201,201,,UNCERTAIN_RESULT,\"the record may have been created") {
+		t.Fatalf("unexpected error file: %v", lines[:2])
+	}
+}
+
+func TestCheckpointAcknowledge(t *testing.T) {
+	cp := &checkpoint{LastRow: 200}
+	cp.Acknowledge(&dmlBatch{rows: []int{401, 402}}, false)
+	cp.Acknowledge(&dmlBatch{rows: []int{201, 400}}, true)
+	if cp.LastRow != 400 || len(cp.Rows) != 2 {
+		t.Fatalf("unexpected checkpoint: %v", cp)
+	}
+	cp.Acknowledge(&dmlBatch{rows: []int{401, 402}}, true)
+	if cp.LastRow != 402 || len(cp.Rows) != 0 {
+		t.Fatalf("unexpected checkpoint: %v", cp)
+	}
+}
+
+func TestResumeRejectsChangedFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "yasd")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "input.csv")
+	ioutil.WriteFile(file, []byte("Name\na\n"), 0644)
+
+	cp, err := getCheckpoint(newDmlContext(t, dir, false), "insert")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	<-cp.hashed
+	if err := cp.Save(&NoopResponseWriteHandler{}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if cp.FileHash == "" {
+		t.Fatalf("file hash is not saved")
+	}
+	// a copy of the file has the same hash
+	os.Chtimes(file, time.Now(), cp.FileModTime.Add(time.Second))
+	if _, err := getCheckpoint(newDmlContext(t, dir, true), "insert"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	ioutil.WriteFile(file, []byte("Name\nb\n"), 0644)
+	os.Chtimes(file, time.Now(), cp.FileModTime.Add(time.Second))
+	_, err = getCheckpoint(newDmlContext(t, dir, true), "insert")
+	expected := file + " has changed since the checkpoint was saved"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("expected: '%s', but '%v'", expected, err)
+	}
+}
@@ -2,13 +2,13 @@ package main
 
 import (
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"strings"
 
 	"github.com/tzmfreedom/go-soapforce"
 	"github.com/urfave/cli"
-	"gopkg.in/yaml.v2"
 )
 
 func newClient(c *cli.Context) *soapforce.Client {
@@ -20,18 +20,64 @@ func newClient(c *cli.Context) *soapforce.Client {
 }
 
 func login(client *soapforce.Client, ctx *cli.Context) error {
+	_, err := authenticate(client, ctx)
+	return err
+}
+
+// authenticate logs in and returns the session, which is needed by the REST based APIs.
+// A session given by the flags or cached by the login command is used without logging in.
+func authenticate(client *soapforce.Client, ctx *cli.Context) (*soapforce.LoginResult, error) {
+	if sessionId := ctx.String("session-id"); sessionId != "" {
+		return useSession(client, ctx.String("instance-url"), sessionId, ctx.String("api-version"))
+	}
+	session, err := findCachedSession(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if session != nil {
+		res, ok, err := useCachedSession(client, ctx, session)
+		if err != nil || ok {
+			return res, err
+		}
+		if ctx.String("username") == "" {
+			return nil, errors.New("the cached session is no longer valid, log in again with the login command")
+		}
+	}
+	return authenticateWithFlags(client, ctx)
+}
+
+// authenticateWithFlags logs in with the credentials of the flags.
+func authenticateWithFlags(client *soapforce.Client, ctx *cli.Context) (*soapforce.LoginResult, error) {
+	if ctx.String("auth") == "jwt" {
+		key, err := readRsaPrivateKey(ctx.String("private-key"))
+		if err != nil {
+			return nil, err
+		}
+		audience := ctx.String("audience")
+		if audience == "" {
+			audience = oauthBaseUrl(ctx.String("endpoint"))
+		}
+		config := &jwtConfig{
+			ClientId:   ctx.String("client-id"),
+			Username:   ctx.String("username"),
+			PrivateKey: key,
+			Audience:   audience,
+		}
+		return authenticateJwt(client, ctx.String("endpoint"), config, ctx.String("api-version"))
+	}
+	return authenticateWith(client, ctx.String("username"), ctx.String("password"), ctx.String("key"))
+}
+
+// authenticateWith logs in with the password, which is encrypted when keypath is set.
+func authenticateWith(client *soapforce.Client, username string, password string, keypath string) (*soapforce.LoginResult, error) {
 	var err error
-	username := ctx.String("username")
-	password := ctx.String("password")
-	keypath := ctx.String("key")
 	if keypath != "" {
 		password, err = decryptCredential(keypath, password)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
-	_, err = client.Login(username, password)
-	return err
+	return client.Login(username, password)
 }
 
 func generateEncryptionKey() error {
@@ -45,15 +91,20 @@ func generateEncryptionKey() error {
 }
 
 func decryptCredential(keypath string, password string) (string, error) {
-	b64key, err := ioutil.ReadFile(keypath)
+	key, err := readEncryptionKey(keypath)
 	if err != nil {
 		return "", err
 	}
-	key, err := base64.StdEncoding.DecodeString(string(b64key))
+	return decrypt(password, key)
+}
+
+// readEncryptionKey reads the key generated by generate-key.
+func readEncryptionKey(keypath string) ([]byte, error) {
+	b64key, err := ioutil.ReadFile(keypath)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
-	return decrypt(password, key)
+	return base64.StdEncoding.DecodeString(string(b64key))
 }
 
 func getId(headers []string, f []string) string {
@@ -73,19 +124,16 @@ func createSObject(client *soapforce.Client, sObjectType string, headers []strin
 	}
 	fieldsToNull := []string{}
 	for i, header := range headers {
+		if isResultColumn(header) {
+			continue
+		}
 		if header == "Id" {
 			sobject.Id = f[i]
 		} else if insertNulls && f[i] == "" {
 			fieldsToNull = append(fieldsToNull, header)
 		} else {
 			if strings.Contains(header, ".") {
-				values := strings.Split(header, ".")
-				referenceField := strings.Replace(values[0], "__R", "__r", -1)
-
-				obj := map[string]string{}
-				obj["type"] = globalReferenceMap[client.UserInfo.OrganizationId][referenceField]
-				obj[values[1]] = f[i]
-				fields[values[0]] = obj
+				setRelationshipField(client, sObjectType, fields, header, f[i])
 			} else {
 				fields[header] = f[i]
 			}
@@ -95,39 +143,50 @@ func createSObject(client *soapforce.Client, sObjectType string, headers []strin
 	return sobject
 }
 
-func mapping(headers []string, m string) ([]string, error) {
-	if m == "" {
-		return headers, nil
+func validateLoginFlag(c *cli.Context, command string) error {
+	if c.String("session-id") != "" {
+		if c.String("instance-url") == "" {
+			_ = cli.ShowCommandHelp(c, command)
+			return cli.NewExitError("instance-url is required with session-id", 1)
+		}
+		return nil
 	}
-	mapping := map[string]string{}
-	buf, err := ioutil.ReadFile(m)
+	session, err := findCachedSession(c)
 	if err != nil {
-		return nil, err
-	}
-	if err = yaml.Unmarshal(buf, mapping); err != nil {
-		return nil, err
+		return cli.NewExitError(err.Error(), 1)
 	}
-	returnHeaders := make([]string, len(headers))
-	for i, h := range headers {
-		if v, ok := mapping[h]; ok {
-			returnHeaders[i] = v
-		} else {
-			returnHeaders[i] = h
-		}
+	if session != nil {
+		return nil
 	}
-	return returnHeaders, nil
+	return validateCredentialFlag(c, command)
 }
 
-func validateLoginFlag(c *cli.Context, command string) error {
+// validateCredentialFlag validates the flags to log in with.
+func validateCredentialFlag(c *cli.Context, command string) error {
 	u := c.String("username")
 	if u == "" {
 		_ = cli.ShowCommandHelp(c, command)
 		return cli.NewExitError("username is required", 1)
 	}
-	p := c.String("password")
-	if p == "" {
+	switch c.String("auth") {
+	case "", "password":
+		p := c.String("password")
+		if p == "" {
+			_ = cli.ShowCommandHelp(c, command)
+			return cli.NewExitError("password is required", 1)
+		}
+	case "jwt":
+		if c.String("client-id") == "" {
+			_ = cli.ShowCommandHelp(c, command)
+			return cli.NewExitError("client-id is required", 1)
+		}
+		if c.String("private-key") == "" {
+			_ = cli.ShowCommandHelp(c, command)
+			return cli.NewExitError("private-key is required", 1)
+		}
+	default:
 		_ = cli.ShowCommandHelp(c, command)
-		return cli.NewExitError("password is required", 1)
+		return cli.NewExitError("auth should be password or jwt", 1)
 	}
 	e := c.String("endpoint")
 	if e == "" {
@@ -137,19 +196,60 @@ func validateLoginFlag(c *cli.Context, command string) error {
 	return nil
 }
 
-var globalReferenceMap = map[string]map[string]string{}
-
-func setReferenceMap(client *soapforce.Client, t string) error {
-	result, err := client.DescribeSObject(t)
-	if err != nil {
+// validateDmlFlags validates the options shared by the DML commands.
+func validateDmlFlags(c *cli.Context, command string) error {
+	if err := validateApiFlag(c, command); err != nil {
 		return err
 	}
-	referenceMap := map[string]string{}
-	for _, f := range result.Fields {
-		if *f.Type_ == "reference" {
-			referenceMap[f.RelationshipName] = f.ReferenceTo[0]
+	if c.String("journal") != "" {
+		if c.String("api") == "bulk2" {
+			_ = cli.ShowCommandHelp(c, command)
+			return cli.NewExitError("journal is not supported by Bulk API 2.0", 1)
+		}
+		if command == "empty-recycle-bin" {
+			_ = cli.ShowCommandHelp(c, command)
+			return cli.NewExitError("records removed from the recycle bin cannot be rolled back", 1)
 		}
 	}
-	globalReferenceMap[client.UserInfo.OrganizationId] = referenceMap
+	if n := c.Int("concurrency"); n < 1 || n > maxConcurrency {
+		_ = cli.ShowCommandHelp(c, command)
+		return cli.NewExitError(fmt.Sprintf("concurrency should be between 1 and %d", maxConcurrency), 1)
+	}
 	return nil
 }
+
+func validateApiFlag(c *cli.Context, command string) error {
+	switch c.String("api") {
+	case "", "soap":
+		return nil
+	case "bulk2":
+		if command == "undelete" {
+			_ = cli.ShowCommandHelp(c, command)
+			return cli.NewExitError("undelete is not supported by Bulk API 2.0", 1)
+		}
+		return nil
+	default:
+		_ = cli.ShowCommandHelp(c, command)
+		return cli.NewExitError("api should be soap or bulk2", 1)
+	}
+}
+
+// findChildRelationship returns the child relationship of the object by its name.
+func findChildRelationship(describe *soapforce.DescribeSObjectResult, name string) (*soapforce.ChildRelationship, error) {
+	for _, r := range describe.ChildRelationships {
+		if strings.EqualFold(r.RelationshipName, name) {
+			return r, nil
+		}
+	}
+	return nil, fmt.Errorf("%s has no child relationship %s", describe.Name, name)
+}
+
+// describeSObject describes the object and registers its relationships for createSObject.
+func describeSObject(client *soapforce.Client, t string) (*soapforce.DescribeSObjectResult, error) {
+	result, err := client.DescribeSObject(t)
+	if err != nil {
+		return nil, err
+	}
+	registerReferenceMap(client.UserInfo.OrganizationId, result)
+	return result, nil
+}
@@ -23,6 +23,21 @@ var exportFlags = append(
 		Name:  "sheet",
 		Value: "import",
 	},
+	cli.StringFlag{
+		Name:  "child-output",
+		Value: "rows",
+	},
+	cli.StringFlag{
+		Name:  "api",
+		Value: "soap",
+	},
+	cli.DurationFlag{
+		Name: "wait-timeout",
+	},
+	cli.IntFlag{
+		Name:  "max-retries",
+		Value: defaultMaxRetries,
+	},
 )
 
 var insertFlags = append(
@@ -37,6 +52,38 @@ var updateFlags = append(
 	cli.BoolFlag{
 		Name: "insert-nulls",
 	},
+	cli.StringFlag{
+		Name: "query, q",
+	},
+	cli.StringSliceFlag{
+		Name: "set",
+	},
+	cli.BoolFlag{
+		Name: "yes, y",
+	},
+)
+
+var deleteFlags = append(
+	defaultDmlFlags(),
+	cli.StringFlag{
+		Name: "query, q",
+	},
+	cli.BoolFlag{
+		Name: "yes, y",
+	},
+	cli.BoolFlag{
+		Name: "hard",
+	},
+)
+
+var emptyRecycleBinFlags = append(
+	defaultDmlFlags(),
+	cli.StringFlag{
+		Name: "query, q",
+	},
+	cli.BoolFlag{
+		Name: "yes, y",
+	},
 )
 
 var upsertFlags = append(
@@ -50,12 +97,164 @@ var upsertFlags = append(
 	},
 )
 
+var migrateFlags = append(
+	defaultFlags(),
+	cli.StringFlag{
+		Name:   "target-username",
+		EnvVar: "SALESFORCE_TARGET_USERNAME",
+	},
+	cli.StringFlag{
+		Name:   "target-password",
+		EnvVar: "SALESFORCE_TARGET_PASSWORD",
+	},
+	cli.StringFlag{
+		Name:   "target-endpoint",
+		Value:  "login.salesforce.com",
+		EnvVar: "SALESFORCE_TARGET_ENDPOINT",
+	},
+	cli.StringFlag{
+		Name: "target-key",
+	},
+	cli.StringFlag{
+		Name: "plan",
+	},
+	cli.StringFlag{
+		Name:  "id-map",
+		Value: "./idmap.csv",
+	},
+	cli.BoolFlag{
+		Name: "resume",
+	},
+	cli.StringFlag{
+		Name:  "error-file",
+		Value: "./error.csv",
+	},
+	cli.IntFlag{
+		Name:  "max-retries",
+		Value: defaultMaxRetries,
+	},
+)
+
+var exportTreeFlags = append(
+	defaultFlags(),
+	cli.StringSliceFlag{
+		Name: "query, q",
+	},
+	cli.IntFlag{
+		Name:  "batch-size",
+		Value: 500,
+	},
+	cli.StringFlag{
+		Name: "file",
+	},
+	cli.IntFlag{
+		Name:  "max-retries",
+		Value: defaultMaxRetries,
+	},
+)
+
+var importTreeFlags = append(
+	defaultFlags(),
+	cli.StringFlag{
+		Name: "file, f",
+	},
+	cli.StringFlag{
+		Name:  "success-file",
+		Value: "./success.csv",
+	},
+	cli.StringFlag{
+		Name:  "error-file",
+		Value: "./error.csv",
+	},
+	cli.IntFlag{
+		Name:  "max-retries",
+		Value: defaultMaxRetries,
+	},
+)
+
+var rollbackFlags = append(
+	defaultFlags(),
+	cli.StringFlag{
+		Name: "journal",
+	},
+	cli.StringFlag{
+		Name:  "success-file",
+		Value: "./success.csv",
+	},
+	cli.StringFlag{
+		Name:  "error-file",
+		Value: "./error.csv",
+	},
+	cli.IntFlag{
+		Name:  "max-retries",
+		Value: defaultMaxRetries,
+	},
+)
+
+var loginFlags = append(
+	defaultFlags(),
+	cli.DurationFlag{
+		Name:  "session-timeout",
+		Value: defaultSessionTimeout,
+	},
+	cli.BoolFlag{
+		Name: "web",
+	},
+	cli.IntFlag{
+		Name:  "callback-port",
+		Value: defaultCallbackPort,
+	},
+)
+
+var encryptFlags = append(
+	defaultFlags(),
+	cli.BoolFlag{
+		Name: "upgrade",
+	},
+)
+
+// profileFlags are the options saved by profile add, which have no defaults
+// so that only the given ones are saved.
+var profileFlags = []cli.Flag{
+	cli.StringFlag{
+		Name: "username, u",
+	},
+	cli.StringFlag{
+		Name: "password, p",
+	},
+	cli.StringFlag{
+		Name: "auth",
+	},
+	cli.StringFlag{
+		Name: "client-id",
+	},
+	cli.StringFlag{
+		Name: "private-key",
+	},
+	cli.StringFlag{
+		Name: "endpoint, e",
+	},
+	cli.StringFlag{
+		Name: "api-version",
+	},
+	cli.StringFlag{
+		Name: "encoding",
+	},
+	cli.StringFlag{
+		Name: "key",
+	},
+	cli.IntFlag{
+		Name: "batch-size",
+	},
+}
+
 var Commands = []cli.Command{
 	{
 		Name:    "export",
 		Aliases: []string{"e"},
 		Usage:   "Export SObject Record",
 		Flags:   exportFlags,
+		Before:  applyProfile,
 		Action: func(c *cli.Context) error {
 			return query(c)
 		},
@@ -65,6 +264,7 @@ var Commands = []cli.Command{
 		Aliases: []string{"i"},
 		Usage:   "Insert SObject Record",
 		Flags:   insertFlags,
+		Before:  applyProfile,
 		Action: func(c *cli.Context) error {
 			return insert(c)
 		},
@@ -74,14 +274,16 @@ var Commands = []cli.Command{
 		Aliases: []string{"u"},
 		Usage:   "Update SObject Record",
 		Flags:   updateFlags,
+		Before:  applyProfile,
 		Action: func(c *cli.Context) error {
 			return update(c)
 		},
 	},
 	{
-		Name:  "upsert",
-		Usage: "Upsert SObject Record",
-		Flags: upsertFlags,
+		Name:   "upsert",
+		Usage:  "Upsert SObject Record",
+		Flags:  upsertFlags,
+		Before: applyProfile,
 		Action: func(c *cli.Context) error {
 			return upsert(c)
 		},
@@ -90,31 +292,127 @@ var Commands = []cli.Command{
 		Name:    "delete",
 		Aliases: []string{"d"},
 		Usage:   "Delete SObject Record",
-		Flags:   defaultDmlFlags(),
+		Flags:   deleteFlags,
+		Before:  applyProfile,
 		Action: func(c *cli.Context) error {
 			return delete(c)
 		},
 	},
 	{
-		Name:  "undelete",
-		Usage: "Undelete SObject Record",
-		Flags: defaultDmlFlags(),
+		Name:   "undelete",
+		Usage:  "Undelete SObject Record",
+		Flags:  defaultDmlFlags(),
+		Before: applyProfile,
 		Action: func(c *cli.Context) error {
 			return undelete(c)
 		},
 	},
 	{
-		Name:  "generate-key",
-		Usage: "Generate AES Key",
-		Flags: defaultFlags(),
+		Name:   "empty-recycle-bin",
+		Usage:  "Remove SObject Records from the recycle bin",
+		Flags:  emptyRecycleBinFlags,
+		Before: applyProfile,
+		Action: func(c *cli.Context) error {
+			return emptyRecycleBin(c)
+		},
+	},
+	{
+		Name:   "rollback",
+		Usage:  "Roll back the changes of a journal",
+		Flags:  rollbackFlags,
+		Before: applyProfile,
+		Action: func(c *cli.Context) error {
+			return rollback(c)
+		},
+	},
+	{
+		Name:   "migrate",
+		Usage:  "Migrate SObject Records to another org",
+		Flags:  migrateFlags,
+		Before: applyProfile,
+		Action: func(c *cli.Context) error {
+			return migrate(c)
+		},
+	},
+	{
+		Name:   "export-tree",
+		Usage:  "Export SObject Records with their children as a tree",
+		Flags:  exportTreeFlags,
+		Before: applyProfile,
+		Action: func(c *cli.Context) error {
+			return exportTree(c)
+		},
+	},
+	{
+		Name:   "import-tree",
+		Usage:  "Import a tree of SObject Records",
+		Flags:  importTreeFlags,
+		Before: applyProfile,
+		Action: func(c *cli.Context) error {
+			return importTree(c)
+		},
+	},
+	{
+		Name:   "login",
+		Usage:  "Log in and cache the session for the following commands",
+		Flags:  loginFlags,
+		Before: applyProfile,
+		Action: func(c *cli.Context) error {
+			return loginCommand(c)
+		},
+	},
+	{
+		Name:  "profile",
+		Usage: "Manage connection profiles",
+		Subcommands: []cli.Command{
+			{
+				Name:      "add",
+				Usage:     "Add a profile",
+				ArgsUsage: "<name>",
+				Flags:     profileFlags,
+				Action: func(c *cli.Context) error {
+					return addProfile(c)
+				},
+			},
+			{
+				Name:  "list",
+				Usage: "List profiles",
+				Action: func(c *cli.Context) error {
+					return listProfiles(c)
+				},
+			},
+			{
+				Name:      "remove",
+				Usage:     "Remove a profile",
+				ArgsUsage: "<name>",
+				Action: func(c *cli.Context) error {
+					return removeProfile(c)
+				},
+			},
+			{
+				Name:      "show",
+				Usage:     "Show a profile",
+				ArgsUsage: "<name>",
+				Action: func(c *cli.Context) error {
+					return showProfile(c)
+				},
+			},
+		},
+	},
+	{
+		Name:   "generate-key",
+		Usage:  "Generate AES Key",
+		Flags:  defaultFlags(),
+		Before: applyProfile,
 		Action: func(c *cli.Context) error {
 			return generateEncryptionKey()
 		},
 	},
 	{
-		Name:  "encrypt",
-		Usage: "Encrypt password",
-		Flags: defaultFlags(),
+		Name:   "encrypt",
+		Usage:  "Encrypt password",
+		Flags:  encryptFlags,
+		Before: applyProfile,
 		Action: func(c *cli.Context) error {
 			return encryptCredential(c)
 		},
@@ -154,6 +452,38 @@ func defaultFlags() []cli.Flag {
 		cli.StringFlag{
 			Name: "key",
 		},
+		cli.StringFlag{
+			Name:   "auth",
+			Value:  "password",
+			EnvVar: "SALESFORCE_AUTH",
+		},
+		cli.StringFlag{
+			Name:   "client-id",
+			EnvVar: "SALESFORCE_CLIENT_ID",
+		},
+		cli.StringFlag{
+			Name:   "private-key",
+			EnvVar: "SALESFORCE_PRIVATE_KEY",
+		},
+		cli.StringFlag{
+			Name: "audience",
+		},
+		cli.StringFlag{
+			Name:   "client-secret",
+			EnvVar: "SALESFORCE_CLIENT_SECRET",
+		},
+		cli.StringFlag{
+			Name:   "profile",
+			EnvVar: "SALESFORCE_PROFILE",
+		},
+		cli.StringFlag{
+			Name:   "session-id",
+			EnvVar: "SALESFORCE_SESSION_ID",
+		},
+		cli.StringFlag{
+			Name:   "instance-url",
+			EnvVar: "SALESFORCE_INSTANCE_URL",
+		},
 	}
 }
 
@@ -178,5 +508,45 @@ func defaultDmlFlags() []cli.Flag {
 			Name:  "error-file",
 			Value: "./error.csv",
 		},
+		cli.IntFlag{
+			Name: "start-row",
+		},
+		cli.StringFlag{
+			Name:  "api",
+			Value: "soap",
+		},
+		cli.DurationFlag{
+			Name: "wait-timeout",
+		},
+		cli.IntFlag{
+			Name:  "concurrency",
+			Value: 1,
+		},
+		cli.StringFlag{
+			Name: "checkpoint",
+		},
+		cli.BoolFlag{
+			Name: "resume",
+		},
+		cli.IntFlag{
+			Name:  "max-retries",
+			Value: defaultMaxRetries,
+		},
+		cli.BoolFlag{
+			Name: "dry-run",
+		},
+		cli.StringFlag{
+			Name: "date-layout",
+		},
+		cli.StringFlag{
+			Name: "timezone",
+		},
+		cli.StringFlag{
+			Name:  "multipicklist-delimiter",
+			Value: ";,",
+		},
+		cli.StringFlag{
+			Name: "journal",
+		},
 	)
 }
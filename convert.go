@@ -0,0 +1,295 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/tzmfreedom/go-soapforce"
+	"github.com/urfave/cli"
+)
+
+// defaultDateLayouts are tried in order when a date or datetime has no layout.
+var defaultDateLayouts = []string{
+	"2006-01-02",
+	"2006/1/2",
+	"2006-1-2",
+	"2006.1.2",
+	"20060102",
+}
+
+var defaultDateTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02 15:04",
+	"2006/1/2 15:04:05",
+	"2006/1/2 15:04",
+}
+
+var defaultTrueValues = []string{"true", "yes", "y", "1", "on", "t"}
+var defaultFalseValues = []string{"false", "no", "n", "0", "off", "f"}
+
+// thousandsPattern matches a number with commas between groups of three digits.
+var thousandsPattern = regexp.MustCompile(`^\d{1,3}(,\d{3})+(\.\d*)?$`)
+
+// excelEpoch is the day 0 of the serial dates of Excel.
+var excelEpoch = time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+
+// conversion configures how the values of a column are converted. Empty
+// settings fall back to the command flags and the field type.
+type conversion struct {
+	// Type overrides the field type, or disables the conversion with "none".
+	Type        string   `yaml:"type"`
+	Layouts     []string `yaml:"layouts"`
+	Timezone    string   `yaml:"timezone"`
+	Delimiter   string   `yaml:"delimiter"`
+	TrueValues  []string `yaml:"trueValues"`
+	FalseValues []string `yaml:"falseValues"`
+}
+
+func (c *conversion) isEmpty() bool {
+	return c.Type == "" && len(c.Layouts) == 0 && c.Timezone == "" && c.Delimiter == "" &&
+		len(c.TrueValues) == 0 && len(c.FalseValues) == 0
+}
+
+// columnConverter normalizes the values of a column to the API format of the field type.
+type columnConverter struct {
+	field     string
+	kind      string
+	layouts   []string
+	location  *time.Location
+	delimiter string
+	// picklist are the values of the picklist field, which the values split
+	// by the delimiters other than ";" must be in.
+	picklist    map[string]bool
+	trueValues  []string
+	falseValues []string
+}
+
+// valueConverter converts the values of the input rows with the field types
+// of the object.
+type valueConverter struct {
+	columns []*columnConverter
+}
+
+// newValueConverter creates the converters of the columns. defaults are the
+// settings from the command flags, and conversions are the overrides of the
+// columns from the mapping file.
+func newValueConverter(describe *soapforce.DescribeSObjectResult, headers []string, conversions []*conversion, defaults *conversion) (*valueConverter, error) {
+	fields := map[string]*soapforce.Field{}
+	for _, f := range describe.Fields {
+		fields[strings.ToLower(f.Name)] = f
+	}
+	v := &valueConverter{columns: make([]*columnConverter, len(headers))}
+	for i, header := range headers {
+		if isResultColumn(header) || strings.Contains(header, ".") {
+			continue
+		}
+		override := &conversion{}
+		if i < len(conversions) && conversions[i] != nil {
+			override = conversions[i]
+		}
+		f := fields[strings.ToLower(header)]
+		kind := override.Type
+		if kind == "" {
+			if f == nil {
+				continue
+			}
+			kind = fieldType(f)
+		}
+		if kind == "none" {
+			continue
+		}
+		col := &columnConverter{
+			field:       header,
+			kind:        kind,
+			layouts:     firstStrings(override.Layouts, defaults.Layouts),
+			delimiter:   firstString(override.Delimiter, defaults.Delimiter, ";,"),
+			picklist:    map[string]bool{},
+			trueValues:  firstStrings(override.TrueValues, defaults.TrueValues, defaultTrueValues),
+			falseValues: firstStrings(override.FalseValues, defaults.FalseValues, defaultFalseValues),
+		}
+		location, err := time.LoadLocation(firstString(override.Timezone, defaults.Timezone, "Local"))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", header, err)
+		}
+		col.location = location
+		if f != nil {
+			for _, entry := range f.PicklistValues {
+				col.picklist[entry.Value] = true
+			}
+		}
+		v.columns[i] = col
+	}
+	return v, nil
+}
+
+// newValueConverterFromFlags creates the converter with the conversion flags of the command.
+func newValueConverterFromFlags(c *cli.Context, describe *soapforce.DescribeSObjectResult, in *dmlInput) (*valueConverter, error) {
+	defaults := &conversion{
+		Timezone:  c.String("timezone"),
+		Delimiter: c.String("multipicklist-delimiter"),
+	}
+	if layouts := c.String("date-layout"); layouts != "" {
+		defaults.Layouts = strings.Split(layouts, ",")
+	}
+	return newValueConverter(describe, in.headers, in.mapping.conversions, defaults)
+}
+
+// Convert returns the converted values of the row, or the errors of the
+// values which cannot be converted.
+func (v *valueConverter) Convert(record []string) ([]string, []*soapforce.Error) {
+	converted := make([]string, len(record))
+	copy(converted, record)
+	var errors []*soapforce.Error
+	for i, col := range v.columns {
+		if col == nil || i >= len(record) || record[i] == "" {
+			continue
+		}
+		value, err := col.convert(record[i])
+		if err != nil {
+			errors = append(errors, newApiError("INVALID_TYPE_ON_FIELD_IN_RECORD", fmt.Sprintf("%s: %s", col.field, err), col.field))
+			continue
+		}
+		converted[i] = value
+	}
+	return converted, errors
+}
+
+func (col *columnConverter) convert(value string) (string, error) {
+	switch col.kind {
+	case "boolean":
+		return col.convertBoolean(value)
+	case "int":
+		n, err := strconv.ParseInt(normalizeNumber(value, ""), 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("value not of required type: %s (int)", value)
+		}
+		return strconv.FormatInt(n, 10), nil
+	case "double", "currency", "percent":
+		n, err := strconv.ParseFloat(normalizeNumber(value, "%"), 64)
+		if err != nil {
+			return "", fmt.Errorf("value not of required type: %s (number)", value)
+		}
+		return strconv.FormatFloat(n, 'f', -1, 64), nil
+	case "date":
+		t, err := col.parseTime(value, defaultDateLayouts)
+		if err != nil {
+			return "", err
+		}
+		return t.Format("2006-01-02"), nil
+	case "datetime":
+		t, err := col.parseTime(value, append(defaultDateTimeLayouts, defaultDateLayouts...))
+		if err != nil {
+			return "", err
+		}
+		return t.UTC().Format("2006-01-02T15:04:05.000Z"), nil
+	case "multipicklist":
+		return strings.Join(col.splitPicklist(value), ";"), nil
+	}
+	return value, nil
+}
+
+func (col *columnConverter) convertBoolean(value string) (string, error) {
+	for _, v := range col.trueValues {
+		if strings.EqualFold(value, v) {
+			return "true", nil
+		}
+	}
+	for _, v := range col.falseValues {
+		if strings.EqualFold(value, v) {
+			return "false", nil
+		}
+	}
+	return "", fmt.Errorf("value not of required type: %s (boolean)", value)
+}
+
+// splitPicklist splits the values of a multi-select picklist by ";". The other
+// delimiters split the values only when every piece is a value of the
+// picklist, since the values may have them, e.g. "Smith, John".
+func (col *columnConverter) splitPicklist(value string) []string {
+	values := splitValues(value, ";")
+	others := strings.Replace(col.delimiter, ";", "", -1)
+	if others == "" {
+		return values
+	}
+	split := splitValues(value, ";"+others)
+	for _, v := range split {
+		if !col.picklist[v] {
+			return values
+		}
+	}
+	return split
+}
+
+func splitValues(value string, delimiter string) []string {
+	values := strings.FieldsFunc(value, func(r rune) bool {
+		return strings.ContainsRune(delimiter, r)
+	})
+	for i := range values {
+		values[i] = strings.TrimSpace(values[i])
+	}
+	return values
+}
+
+// parseTime parses the value with the layouts of the column, or the serial
+// date of Excel, in the location of the column.
+func (col *columnConverter) parseTime(value string, layouts []string) (time.Time, error) {
+	if len(col.layouts) > 0 {
+		layouts = col.layouts
+	}
+	for _, layout := range layouts {
+		if t, err := time.ParseInLocation(layout, value, col.location); err == nil {
+			return t, nil
+		}
+	}
+	if serial, err := strconv.ParseFloat(value, 64); err == nil && serial > 0 {
+		days, fraction := math.Modf(serial)
+		t := excelEpoch.AddDate(0, 0, int(days)).Add(time.Duration(fraction * float64(24*time.Hour)).Round(time.Second))
+		return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, col.location), nil
+	}
+	return time.Time{}, fmt.Errorf("value not of required type: %s (%s)", value, col.kind)
+}
+
+// normalizeNumber removes the thousands separators, spaces, currency symbols
+// and suffix. Commas which do not separate groups of three digits are kept, so
+// that "1,5" is not read as 15.
+func normalizeNumber(value string, suffix string) string {
+	value = strings.TrimSpace(value)
+	if suffix != "" {
+		value = strings.TrimSuffix(value, suffix)
+	}
+	sign := ""
+	if strings.HasPrefix(value, "-") {
+		sign = "-"
+		value = value[1:]
+	}
+	value = strings.TrimLeft(value, "$¥€£")
+	value = strings.NewReplacer(" ", "", "_", "").Replace(value)
+	if thousandsPattern.MatchString(value) {
+		value = strings.Replace(value, ",", "", -1)
+	}
+	return sign + value
+}
+
+func firstString(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func firstStrings(values ...[]string) []string {
+	for _, v := range values {
+		if len(v) > 0 {
+			return v
+		}
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/tzmfreedom/go-soapforce"
+)
+
+func newConversionDescribe() *soapforce.DescribeSObjectResult {
+	return &soapforce.DescribeSObjectResult{
+		Name: "Account",
+		Fields: []*soapforce.Field{
+			newDescribeField("Name", "string", soapforce.Field{}),
+			newDescribeField("NumberOfEmployees", "int", soapforce.Field{}),
+			newDescribeField("AnnualRevenue", "currency", soapforce.Field{}),
+			newDescribeField("IsActive__c", "boolean", soapforce.Field{}),
+			newDescribeField("Founded__c", "date", soapforce.Field{}),
+			newDescribeField("LastVisit__c", "datetime", soapforce.Field{}),
+			newDescribeField("Tags__c", "multipicklist", soapforce.Field{
+				PicklistValues: []*soapforce.PicklistEntry{
+					{Value: "x"}, {Value: "y"}, {Value: "z"}, {Value: "Smith, John"}, {Value: "Doe"},
+				},
+			}),
+			newDescribeField("Code__c", "int", soapforce.Field{}),
+		},
+	}
+}
+
+func TestValueConverter(t *testing.T) {
+	headers := []string{"Name", "NumberOfEmployees", "AnnualRevenue", "IsActive__c", "Founded__c", "LastVisit__c", "Tags__c"}
+	defaults := &conversion{Timezone: "Asia/Tokyo", Delimiter: ";,"}
+	v, err := newValueConverter(newConversionDescribe(), headers, nil, defaults)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	testCases := []struct {
+		record   []string
+		expected []string
+	}{
+		{
+			[]string{"a", "1,200", "$1,234.50", "YES", "2024/3/1", "2024/3/1 09:30", "x, y;z"},
+			[]string{"a", "1200", "1234.5", "true", "2024-03-01", "2024-03-01T00:30:00.000Z", "x;y;z"},
+		},
+		{
+			[]string{"b", "", "-10", "0", "45352", "2024-03-01T09:30:00Z", ""},
+			[]string{"b", "", "-10", "false", "2024-03-01", "2024-03-01T09:30:00.000Z", ""},
+		},
+	}
+	for _, testCase := range testCases {
+		actual, errors := v.Convert(testCase.record)
+		if len(errors) > 0 {
+			t.Fatalf("unexpected errors: %s", errorMessages(errors))
+		}
+		if !reflect.DeepEqual(actual, testCase.expected) {
+			t.Fatalf("expected: %v, but %v", testCase.expected, actual)
+		}
+	}
+
+	// commas split the values only when every piece is a picklist value
+	tags, err := newValueConverter(newConversionDescribe(), []string{"Tags__c"}, nil, &conversion{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for value, expected := range map[string]string{
+		"Smith, John; Doe": "Smith, John;Doe",
+		"Smith, John, Doe": "Smith, John, Doe",
+		"x,y; z":           "x;y;z",
+	} {
+		if actual, _ := tags.Convert([]string{value}); actual[0] != expected {
+			t.Fatalf("expected: '%s', but '%s'", expected, actual[0])
+		}
+	}
+
+	_, errors := v.Convert([]string{"c", "many", "1,5", "maybe", "", "", ""})
+	expected := "INVALID_TYPE_ON_FIELD_IN_RECORD: NumberOfEmployees: value not of required type: many (int)\n" +
+		"INVALID_TYPE_ON_FIELD_IN_RECORD: AnnualRevenue: value not of required type: 1,5 (number)\n" +
+		"INVALID_TYPE_ON_FIELD_IN_RECORD: IsActive__c: value not of required type: maybe (boolean)"
+	if actual := errorMessages(errors); actual != expected {
+		t.Fatalf("expected: '%s', but '%s'", expected, actual)
+	}
+	_, errors = v.Convert([]string{"d", "1,2,3", "", "", "", "", ""})
+	expected = "INVALID_TYPE_ON_FIELD_IN_RECORD: NumberOfEmployees: value not of required type: 1,2,3 (int)"
+	if actual := errorMessages(errors); actual != expected {
+		t.Fatalf("expected: '%s', but '%s'", expected, actual)
+	}
+}
+
+func TestValueConverterWithMapping(t *testing.T) {
+	m, err := newRecordMapping([]string{"Name", "Active", "Founded", "Code"}, "test/conversion_mapping.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expectedHeaders := []string{"Name", "IsActive__c", "Founded__c", "Code__c"}
+	if !reflect.DeepEqual(m.headers, expectedHeaders) {
+		t.Fatalf("expected: %v, but %v", expectedHeaders, m.headers)
+	}
+	v, err := newValueConverter(newConversionDescribe(), m.headers, m.conversions, &conversion{})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	actual, errors := v.Convert([]string{"a", "有効", "31.01.2019", "007"})
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %s", errorMessages(errors))
+	}
+	expected := []string{"a", "true", "2019-01-31", "007"}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected: %v, but %v", expected, actual)
+	}
+}
+
+func TestDmlRunnerRejectsUnconvertibleRecords(t *testing.T) {
+	handler := &recordingResponseHandler{}
+	executed := []int{}
+	runner := &dmlRunner{
+		concurrency: 1,
+		handler:     handler,
+		convert: func(record []string) ([]string, []*soapforce.Error) {
+			if record[0] == "2" {
+				return nil, []*soapforce.Error{newApiError("INVALID_TYPE_ON_FIELD_IN_RECORD", "invalid")}
+			}
+			return record, nil
+		},
+		execute: func(batch *dmlBatch) (dmlResult, error) {
+			executed = append(executed, batch.rows...)
+			res := make([]*soapforce.SaveResult, len(batch.records))
+			for i := range res {
+				res[i] = &soapforce.SaveResult{Success: true}
+			}
+			return func(h responseHandler) error { return h.Handle(batch, res) }, nil
+		},
+	}
+	if err := runner.Run(newSliceReader(3)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(executed, []int{1, 3}) {
+		t.Fatalf("expected [1 3], but %v", executed)
+	}
+	if !reflect.DeepEqual(handler.rows, []int{2, 1, 3}) || handler.results[0].Success {
+		t.Fatalf("unexpected results: %v, %v", handler.rows, handler.results)
+	}
+}
@@ -1,8 +1,6 @@
 package main
 
 import (
-	"io"
-
 	"github.com/tzmfreedom/go-soapforce"
 	"github.com/urfave/cli"
 )
@@ -11,62 +9,100 @@ func delete(c *cli.Context) error {
 	if err := validateDeleteCommand(c); err != nil {
 		return err
 	}
+	if c.Bool("dry-run") {
+		return dryRun(c, "delete")
+	}
+	if c.String("api") == "bulk2" {
+		if c.Bool("hard") {
+			return bulk2Dml(c, "hardDelete")
+		}
+		return bulk2Dml(c, "delete")
+	}
 	client := newClient(c)
 	if err := login(client, c); err != nil {
 		return err
 	}
 
-	reader, err := getReader(c)
-	if err != nil {
-		return err
+	retry := newRetrier(c, client)
+	var in *dmlInput
+	var err error
+	if c.String("query") != "" {
+		in, err = openQueryDmlInput(c, "delete", client, retry)
+	} else {
+		in, err = openDmlInput(c, "delete")
 	}
-	defer reader.Close()
-
-	sobjects := make([]*soapforce.SObject, 0)
-	headers, err := reader.Read()
 	if err != nil {
 		return err
 	}
-	headers, err = mapping(headers, c.String("mapping"))
+	defer in.Close()
+	headers := in.headers
+	hard := c.Bool("hard")
+
+	in.resolver = newReferenceResolver(in.mapping, newSoapQuerier(client, retry))
+	jnl, err := openJournal(c.String("journal"), c.Bool("resume"), newSoapQuerier(client, retry))
 	if err != nil {
 		return err
 	}
-	var ids []string
-	handler, err := getResponseHandler(c)
-	if err != nil {
-		return err
+	defer jnl.Close()
+	t := c.String("type")
+	// the deleted records are recreated from the journal when they cannot be undeleted
+	var journaled []string
+	if jnl != nil {
+		describe, err := describeSObject(client, t)
+		if err != nil {
+			return err
+		}
+		journaled = journalImageFields(describe)
 	}
-	for {
-		fields, err := reader.Read()
-		if err == io.EOF {
-			break
+	runner := newDmlRunner(c, in, retry, func(batch *dmlBatch) (dmlResult, error) {
+		ids := make([]string, len(batch.records))
+		for i, fields := range batch.records {
+			ids[i] = getId(headers, fields)
 		}
+		before, err := jnl.Before(t, "Id", journaled, ids)
 		if err != nil {
-			return err
+			return nil, err
+		}
+		jb, err := jnl.Batch()
+		if err != nil {
+			return nil, err
+		}
+		if err := jb.Begin("delete", t, ids, before); err != nil {
+			return nil, err
+		}
+		res, err := deleteIds(client, retry, ids)
+		if err != nil {
+			return nil, err
+		}
+		deleted := []string{}
+		for _, result := range res {
+			if result != nil && result.Success {
+				deleted = append(deleted, result.Id)
+			}
 		}
-		id := getId(headers, fields)
-		ids = append(ids, id)
-		if len(sobjects) == 200 {
-			res, err := client.Delete(ids)
-			if err != nil {
-				return err
+		if err := jb.Record("delete", t, deleted); err != nil {
+			return nil, err
+		}
+		if err := jb.Commit(); err != nil {
+			return nil, err
+		}
+		if hard {
+			purge := func(ids []string) ([]*soapforce.DeleteResult, error) {
+				return emptyRecycleBinIds(client, retry, ids)
 			}
-			err = handler.HandleDelete(res)
-			if err != nil {
-				return err
+			if err := purgeDeleted(retry, res, purge); err != nil {
+				return nil, err
 			}
-			ids = ids[:0]
 		}
-	}
-	res, err := client.Delete(ids)
-	if err != nil {
-		return err
-	}
-	err = handler.HandleDelete(res)
-	return err
+		return func(h responseHandler) error { return h.HandleDelete(batch, res) }, nil
+	})
+	return runner.Run(in.reader)
 }
 
 func validateDeleteCommand(c *cli.Context) error {
+	if c.String("query") != "" {
+		return validateQueryDmlFlags(c, "delete")
+	}
 	if err := validateLoginFlag(c, "insert"); err != nil {
 		return err
 	}
@@ -80,5 +116,46 @@ func validateDeleteCommand(c *cli.Context) error {
 		_ = cli.ShowCommandHelp(c, "insert")
 		return cli.NewExitError("file is required", 1)
 	}
+	if err := validateDmlFlags(c, "delete"); err != nil {
+		return err
+	}
+	return nil
+}
+
+func deleteIds(client *soapforce.Client, retry *retrier, ids []string) ([]*soapforce.DeleteResult, error) {
+	return deleteRecords(retry, len(ids), func(indexes []int) ([]*soapforce.DeleteResult, error) {
+		return client.Delete(selectIds(ids, indexes))
+	})
+}
+
+// purgeDeleted removes the deleted records from the recycle bin. The records
+// which are not removed are reported with the errors of emptyRecycleBin.
+func purgeDeleted(retry *retrier, res []*soapforce.DeleteResult, purge func(ids []string) ([]*soapforce.DeleteResult, error)) error {
+	indexes := []int{}
+	ids := []string{}
+	for i, result := range res {
+		if result != nil && result.Success {
+			indexes = append(indexes, i)
+			ids = append(ids, result.Id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	var purged []*soapforce.DeleteResult
+	err := retry.Do(func() error {
+		var err error
+		purged, err = purge(ids)
+		return err
+	})
+	if err != nil {
+		// the records are deleted, so the batch is not sent again
+		return &partialBatchError{err: err}
+	}
+	for i, result := range purged {
+		if result != nil && !result.Success {
+			res[indexes[i]] = result
+		}
+	}
 	return nil
 }
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/tzmfreedom/go-soapforce"
+)
+
+func TestPurgeDeleted(t *testing.T) {
+	res := []*soapforce.DeleteResult{
+		{Id: "001A", Success: true},
+		{Errors: []*soapforce.Error{{Message: "entity is deleted"}}},
+		{Id: "001C", Success: true},
+	}
+	purged := []string{}
+	err := purgeDeleted(nil, res, func(ids []string) ([]*soapforce.DeleteResult, error) {
+		purged = append(purged, ids...)
+		return []*soapforce.DeleteResult{
+			{Id: "001A", Success: true},
+			{Id: "001C", Errors: []*soapforce.Error{{Message: "insufficient access"}}},
+		}, nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(purged, []string{"001A", "001C"}) {
+		t.Fatalf("unexpected ids: %v", purged)
+	}
+	if !res[0].Success || res[1].Success || res[1].Errors[0].Message != "entity is deleted" {
+		t.Fatalf("unexpected results: %v %v", res[0], res[1])
+	}
+	if res[2].Success || res[2].Errors[0].Message != "insufficient access" {
+		t.Fatalf("unexpected result: %v", res[2])
+	}
+
+	err = purgeDeleted(nil, []*soapforce.DeleteResult{{Id: "001A", Success: true}}, func(ids []string) ([]*soapforce.DeleteResult, error) {
+		return nil, errors.New("connection reset by peer")
+	})
+	if _, ok := err.(*partialBatchError); !ok {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/tzmfreedom/go-soapforce"
+	"github.com/urfave/cli"
+)
+
+const (
+	// dmlBatchSize is the maximum number of records of a SOAP API DML call.
+	dmlBatchSize = 200
+	// maxConcurrency keeps the workers under the concurrent API request limit of an org.
+	maxConcurrency = 25
+)
+
+// dmlBatch is a chunk of input rows sent in one API call.
+type dmlBatch struct {
+	seq int
+	// rows are the 1-based source row numbers of records.
+	rows    []int
+	records [][]string
+	// inputs are the records as read, when records are mapped to the fields.
+	inputs [][]string
+}
+
+// dmlResult passes the results of an API call to the handler. It is called in
+// input order regardless of the order in which batches complete.
+type dmlResult func(handler responseHandler) error
+
+// dmlExecutor performs the API call for a batch.
+type dmlExecutor func(batch *dmlBatch) (dmlResult, error)
+
+type dmlBatchResult struct {
+	batch  *dmlBatch
+	result dmlResult
+	err    error
+}
+
+// dmlRunner reads input rows, groups them into batches and fans them out to a
+// pool of workers sharing one logged-in client.
+type dmlRunner struct {
+	concurrency int
+	execute     dmlExecutor
+	handler     responseHandler
+	checkpoint  *checkpoint
+	retry       *retrier
+	// convert normalizes the values of a record. Records which cannot be
+	// converted are written to the error file without the API call.
+	convert func(record []string) ([]string, []*soapforce.Error)
+	// resolve replaces the lookup values of the converted records of a batch.
+	resolve func(records [][]string) ([][]*soapforce.Error, error)
+}
+
+func newDmlRunner(c *cli.Context, in *dmlInput, retry *retrier, execute dmlExecutor) *dmlRunner {
+	concurrency := c.Int("concurrency")
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	return &dmlRunner{
+		concurrency: concurrency,
+		execute:     execute,
+		handler:     in.handler,
+		checkpoint:  in.checkpoint,
+		retry:       retry,
+		convert:     in.Convert,
+		resolve:     in.Resolve,
+	}
+}
+
+// dmlInput is the input file of a DML command, with the handler of the
+// results and the checkpoint of the progress.
+type dmlInput struct {
+	reader Reader
+	// headers are mapped to the field names.
+	headers []string
+	mapping *recordMapping
+	// converter converts the mapped values with the field types, if set.
+	converter *valueConverter
+	// resolver resolves the lookup fields of the mapping, if set.
+	resolver   *referenceResolver
+	handler    responseHandler
+	checkpoint *checkpoint
+}
+
+// openDmlInput opens the input file and the result files of the command. With
+// --resume, the reading starts after the last row of the checkpoint.
+func openDmlInput(c *cli.Context, command string) (*dmlInput, error) {
+	cp, err := getCheckpoint(c, command)
+	if err != nil {
+		return nil, err
+	}
+	start := c.Int("start-row")
+	if cp.resumed {
+		start = cp.LastRow + 1
+	}
+	reader, err := getReader(c, start)
+	if err != nil {
+		return nil, err
+	}
+	headers, err := reader.Read()
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+	handler, err := getResponseHandler(c, headers, cp)
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+	m, err := newRecordMapping(headers, c.String("mapping"))
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+	return &dmlInput{
+		reader:     reader,
+		headers:    m.headers,
+		mapping:    m,
+		handler:    handler,
+		checkpoint: cp,
+	}, nil
+}
+
+// Convert maps the input record to the fields and converts the values.
+func (in *dmlInput) Convert(record []string) ([]string, []*soapforce.Error) {
+	values, errors := in.mapping.Map(record)
+	if len(errors) > 0 || in.converter == nil {
+		return values, errors
+	}
+	return in.converter.Convert(values)
+}
+
+// Resolve replaces the lookup values of the records with the Ids of the parents.
+func (in *dmlInput) Resolve(records [][]string) ([][]*soapforce.Error, error) {
+	return in.resolver.Resolve(records)
+}
+
+func (in *dmlInput) Close() error {
+	return in.reader.Close()
+}
+
+func (r *dmlRunner) Run(reader Reader) error {
+	batches := make(chan *dmlBatch)
+	results := make(chan *dmlBatchResult)
+	done := make(chan struct{})
+
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(batches)
+		readErr <- readDmlBatches(reader, batches, done, r.checkpoint)
+	}()
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < r.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range batches {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				// results are sent even after an error, so that completed
+				// batches are recorded in the checkpoint
+				result, err := r.call(batch)
+				results <- &dmlBatchResult{batch: batch, result: result, err: err}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	var firstErr error
+	pending := map[int]*dmlBatchResult{}
+	next := 0
+	for res := range results {
+		pending[res.batch.seq] = res
+		if firstErr != nil {
+			continue
+		}
+		for {
+			res := pending[next]
+			if res == nil {
+				break
+			}
+			pending[next] = nil
+			next++
+			err := res.err
+			if err == nil {
+				err = res.result(r.handler)
+			}
+			if err == nil {
+				r.checkpoint.Acknowledge(res.batch, true)
+				err = r.checkpoint.Save(r.handler)
+			}
+			if err != nil {
+				if ce, ok := err.(*createError); ok {
+					r.checkpoint.MarkUncertain(ce.rows)
+				}
+				firstErr = err
+				close(done)
+				break
+			}
+		}
+	}
+	err := <-readErr
+	if firstErr != nil {
+		r.handleCompleted(pending, next)
+		return firstErr
+	}
+	if err != nil {
+		return err
+	}
+	return r.checkpoint.Remove()
+}
+
+// handleCompleted writes the results of the batches which completed after a
+// failed batch, so that they are not loaded again on resume, and records the
+// rows of the create calls which lost the connection.
+func (r *dmlRunner) handleCompleted(pending map[int]*dmlBatchResult, next int) {
+	seqs := []int{}
+	for seq, res := range pending {
+		if seq < next || res == nil {
+			continue
+		}
+		if ce, ok := res.err.(*createError); ok {
+			r.checkpoint.MarkUncertain(ce.rows)
+		}
+		if res.err == nil {
+			seqs = append(seqs, seq)
+		}
+	}
+	sort.Ints(seqs)
+	for _, seq := range seqs {
+		res := pending[seq]
+		if err := res.result(r.handler); err != nil {
+			break
+		}
+		r.checkpoint.Acknowledge(res.batch, false)
+	}
+	_ = r.checkpoint.Save(r.handler)
+}
+
+// call executes the batch, retrying it on transient faults.
+func (r *dmlRunner) call(batch *dmlBatch) (dmlResult, error) {
+	valid, rejected, errors, err := r.convertBatch(batch)
+	if err != nil {
+		return nil, err
+	}
+	var result dmlResult
+	if len(valid.records) > 0 {
+		err := r.retry.Do(func() error {
+			var err error
+			result, err = r.execute(valid)
+			return err
+		})
+		if ce, ok := err.(*createError); ok {
+			ce.rows = valid.rows
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(rejected.records) == 0 {
+		return result, nil
+	}
+	return func(h responseHandler) error {
+		results := make([]*soapforce.SaveResult, len(errors))
+		for i, errs := range errors {
+			results[i] = &soapforce.SaveResult{Errors: errs}
+		}
+		if err := h.Handle(rejected, results); err != nil {
+			return err
+		}
+		if result == nil {
+			return nil
+		}
+		return result(h)
+	}, nil
+}
+
+// rejectUncertain splits the batch into the records to send and the records
+// which may have been created by the previous run, which are rejected so that
+// they are not created twice.
+func (r *dmlRunner) rejectUncertain(batch *dmlBatch) (*dmlBatch, *dmlBatch, [][]*soapforce.Error) {
+	rest := &dmlBatch{seq: batch.seq}
+	rejected := &dmlBatch{seq: batch.seq}
+	errors := [][]*soapforce.Error{}
+	for i, record := range batch.records {
+		if r.checkpoint.IsUncertain(batch.rows[i]) {
+			rejected.rows = append(rejected.rows, batch.rows[i])
+			rejected.records = append(rejected.records, record)
+			errors = append(errors, []*soapforce.Error{newApiError("UNCERTAIN_RESULT", "the record may have been created before the connection was lost, check it before loading the row again")})
+			continue
+		}
+		rest.rows = append(rest.rows, batch.rows[i])
+		rest.records = append(rest.records, record)
+	}
+	return rest, rejected, errors
+}
+
+// convertBatch splits the batch into the converted records and the records
+// rejected with the conversion or lookup errors.
+func (r *dmlRunner) convertBatch(batch *dmlBatch) (*dmlBatch, *dmlBatch, [][]*soapforce.Error, error) {
+	batch, rejected, errors := r.rejectUncertain(batch)
+	if r.convert == nil {
+		return batch, rejected, errors, nil
+	}
+	valid := &dmlBatch{seq: batch.seq}
+	for i, record := range batch.records {
+		converted, errs := r.convert(record)
+		if len(errs) > 0 {
+			rejected.rows = append(rejected.rows, batch.rows[i])
+			rejected.records = append(rejected.records, record)
+			errors = append(errors, errs)
+			continue
+		}
+		valid.rows = append(valid.rows, batch.rows[i])
+		valid.records = append(valid.records, converted)
+		valid.inputs = append(valid.inputs, record)
+	}
+	if r.resolve == nil || len(valid.records) == 0 {
+		return valid, rejected, errors, nil
+	}
+	lookupErrors, err := r.resolve(valid.records)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	resolved := &dmlBatch{seq: batch.seq}
+	for i, errs := range lookupErrors {
+		if len(errs) > 0 {
+			rejected.rows = append(rejected.rows, valid.rows[i])
+			rejected.records = append(rejected.records, valid.inputs[i])
+			errors = append(errors, errs)
+			continue
+		}
+		resolved.rows = append(resolved.rows, valid.rows[i])
+		resolved.records = append(resolved.records, valid.records[i])
+		resolved.inputs = append(resolved.inputs, valid.inputs[i])
+	}
+	return resolved, rejected, errors, nil
+}
+
+func readDmlBatches(reader Reader, batches chan<- *dmlBatch, done <-chan struct{}, cp *checkpoint) error {
+	batch := &dmlBatch{}
+	row := 0
+	send := func() bool {
+		select {
+		case batches <- batch:
+			batch = &dmlBatch{seq: batch.seq + 1}
+			return true
+		case <-done:
+			return false
+		}
+	}
+	for {
+		fields, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		row++
+		if fields == nil || cp.IsLoaded(row) {
+			continue
+		}
+		batch.rows = append(batch.rows, row)
+		batch.records = append(batch.records, fields)
+		if len(batch.records) == dmlBatchSize && !send() {
+			return nil
+		}
+	}
+	if len(batch.records) > 0 {
+		send()
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tzmfreedom/go-soapforce"
+)
+
+type sliceReader struct {
+	records [][]string
+	counter int
+}
+
+func (r *sliceReader) Read() ([]string, error) {
+	if r.counter >= len(r.records) {
+		return nil, io.EOF
+	}
+	record := r.records[r.counter]
+	r.counter++
+	return record, nil
+}
+
+func (r *sliceReader) Close() error { return nil }
+
+func newSliceReader(n int) *sliceReader {
+	records := make([][]string, n)
+	for i := range records {
+		records[i] = []string{strconv.Itoa(i + 1)}
+	}
+	return &sliceReader{records: records}
+}
+
+func TestDmlRunnerKeepsInputOrder(t *testing.T) {
+	handler := &recordingResponseHandler{}
+	runner := &dmlRunner{
+		concurrency: 8,
+		handler:     handler,
+		execute: func(batch *dmlBatch) (dmlResult, error) {
+			// later batches complete first
+			time.Sleep(time.Duration(10-batch.seq) * time.Millisecond)
+			res := make([]*soapforce.SaveResult, len(batch.records))
+			for i, record := range batch.records {
+				if record[0] != strconv.Itoa(batch.rows[i]) {
+					t.Errorf("expected row %d, but '%s'", batch.rows[i], record[0])
+				}
+				res[i] = &soapforce.SaveResult{Id: record[0], Success: true}
+			}
+			return func(h responseHandler) error { return h.Handle(batch, res) }, nil
+		},
+	}
+	if err := runner.Run(newSliceReader(2000)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(handler.results) != 2000 {
+		t.Fatalf("expected %d, but %d", 2000, len(handler.results))
+	}
+	for i, result := range handler.results {
+		if result.Id != strconv.Itoa(i+1) {
+			t.Fatalf("expected '%d', but '%s'", i+1, result.Id)
+		}
+	}
+}
+
+func TestDmlRunnerSkipsNilRows(t *testing.T) {
+	reader := &sliceReader{records: [][]string{nil, {"2"}, nil, {"4"}}}
+	rows := []int{}
+	runner := &dmlRunner{
+		concurrency: 1,
+		handler:     &recordingResponseHandler{},
+		execute: func(batch *dmlBatch) (dmlResult, error) {
+			rows = append(rows, batch.rows...)
+			return func(h responseHandler) error { return nil }, nil
+		},
+	}
+	if err := runner.Run(reader); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(rows) != 2 || rows[0] != 2 || rows[1] != 4 {
+		t.Fatalf("expected [2 4], but %v", rows)
+	}
+}
+
+func TestDmlRunnerStopsOnError(t *testing.T) {
+	var calls int32
+	runner := &dmlRunner{
+		concurrency: 4,
+		handler:     &recordingResponseHandler{},
+		execute: func(batch *dmlBatch) (dmlResult, error) {
+			atomic.AddInt32(&calls, 1)
+			if batch.seq == 2 {
+				return nil, errors.New("INVALID_SESSION_ID: Invalid Session ID")
+			}
+			return func(h responseHandler) error { return nil }, nil
+		},
+	}
+	err := runner.Run(newSliceReader(100000))
+	if err == nil || err.Error() != "INVALID_SESSION_ID: Invalid Session ID" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n := atomic.LoadInt32(&calls); n >= 500 {
+		t.Fatalf("expected runner to stop, but %d batches are executed", n)
+	}
+}
+
+func TestDmlRunnerBacksOffOnConcurrencyLimit(t *testing.T) {
+	attempts := 0
+	runner := &dmlRunner{
+		concurrency: 1,
+		handler:     &recordingResponseHandler{},
+		retry:       &retrier{maxRetries: defaultMaxRetries, backoff: time.Millisecond},
+		execute: func(batch *dmlBatch) (dmlResult, error) {
+			attempts++
+			if attempts < 3 {
+				return nil, errors.New("REQUEST_LIMIT_EXCEEDED: ConcurrentRequests (Concurrent API Requests) Limit exceeded.")
+			}
+			return func(h responseHandler) error { return nil }, nil
+		},
+	}
+	if err := runner.Run(newSliceReader(1)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if attempts != 3 {
+		t.Fatalf("expected %d, but %d", 3, attempts)
+	}
+}
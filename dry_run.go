@@ -0,0 +1,315 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/tzmfreedom/go-soapforce"
+	"github.com/urfave/cli"
+)
+
+var idPattern = regexp.MustCompile(`^[a-zA-Z0-9]{15}([a-zA-Z0-9]{3})?$`)
+
+var dateTimeLayouts = []string{
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02T15:04:05.000Z07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04:05.000",
+}
+
+var timeLayouts = []string{
+	"15:04:05Z",
+	"15:04:05.000Z",
+	"15:04:05",
+	"15:04:05.000",
+}
+
+// dryRun validates every row of the input file against the describe of the
+// object and writes the errors to the error file without any DML call.
+func dryRun(c *cli.Context, operation string) error {
+	client := newClient(c)
+	if err := login(client, c); err != nil {
+		return err
+	}
+	describe, err := client.DescribeSObject(c.String("type"))
+	if err != nil {
+		return err
+	}
+	in, err := openDmlInput(c, operation)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	v := newRecordValidator(describe, operation, in.headers, c.String("upsert-key"))
+	invalid := 0
+	// validation makes no API call, so it runs in one worker without a checkpoint
+	runner := &dmlRunner{
+		concurrency: 1,
+		handler:     in.handler,
+		execute: func(batch *dmlBatch) (dmlResult, error) {
+			results := make([]*soapforce.SaveResult, len(batch.records))
+			for i, record := range batch.records {
+				errors := v.Validate(record)
+				results[i] = &soapforce.SaveResult{Errors: errors, Success: len(errors) == 0}
+				if len(errors) > 0 {
+					invalid++
+				}
+			}
+			return func(h responseHandler) error { return h.Handle(batch, results) }, nil
+		},
+	}
+	if operation != "delete" && operation != "undelete" {
+		in.converter, err = newValueConverterFromFlags(c, describe, in)
+		if err != nil {
+			return err
+		}
+	}
+	runner.convert = func(record []string) ([]string, []*soapforce.Error) {
+		converted, errors := in.Convert(record)
+		if len(errors) > 0 {
+			invalid++
+		}
+		return converted, errors
+	}
+	in.resolver = newReferenceResolver(in.mapping, newSoapQuerier(client, newRetrier(c, client)))
+	runner.resolve = func(records [][]string) ([][]*soapforce.Error, error) {
+		errors, err := in.Resolve(records)
+		for _, errs := range errors {
+			if len(errs) > 0 {
+				invalid++
+			}
+		}
+		return errors, err
+	}
+	if err := runner.Run(in.reader); err != nil {
+		return err
+	}
+	if invalid > 0 {
+		return cli.NewExitError(fmt.Sprintf("%d rows are invalid", invalid), 1)
+	}
+	return nil
+}
+
+// recordValidator checks the rows with the rules the org applies on save.
+type recordValidator struct {
+	operation string
+	// columns are the fields of the headers, nil for result columns.
+	columns []*soapforce.Field
+	// errors are found in the headers and apply to every row.
+	errors []*soapforce.Error
+}
+
+func newRecordValidator(describe *soapforce.DescribeSObjectResult, operation string, headers []string, upsertKey string) *recordValidator {
+	fields := map[string]*soapforce.Field{}
+	relationships := map[string]*soapforce.Field{}
+	for _, f := range describe.Fields {
+		fields[strings.ToLower(f.Name)] = f
+		if f.RelationshipName != "" {
+			relationships[strings.ToLower(f.RelationshipName)] = f
+		}
+	}
+
+	v := &recordValidator{
+		operation: operation,
+		columns:   make([]*soapforce.Field, len(headers)),
+	}
+	used := map[string]bool{}
+	for i, header := range headers {
+		if isResultColumn(header) {
+			continue
+		}
+		var f *soapforce.Field
+		if strings.Contains(header, ".") {
+			col, err := parseRelationshipColumn(header)
+			if err != nil {
+				v.errors = append(v.errors, newApiError("INVALID_FIELD", err.Error(), header))
+				continue
+			}
+			f = relationships[strings.ToLower(col.Relationship)]
+			if f != nil {
+				if _, err := col.targetType(f.ReferenceTo); err != nil {
+					v.errors = append(v.errors, newApiError("INVALID_FIELD", fmt.Sprintf("%s: %s", header, err), header))
+					continue
+				}
+			}
+		} else {
+			f = fields[strings.ToLower(header)]
+			v.columns[i] = f
+		}
+		if f == nil {
+			v.errors = append(v.errors, newApiError("INVALID_FIELD", fmt.Sprintf("No such column '%s' on entity '%s'", header, describe.Name), header))
+			continue
+		}
+		used[strings.ToLower(f.Name)] = true
+		if strings.EqualFold(f.Name, "Id") {
+			continue
+		}
+		if (operation == "delete" || operation == "undelete") || isWritable(f, operation) {
+			continue
+		}
+		v.errors = append(v.errors, newApiError("INVALID_FIELD_FOR_INSERT_UPDATE", fmt.Sprintf("Unable to %s field: %s", operation, f.Name), f.Name))
+	}
+
+	switch operation {
+	case "insert", "upsert":
+		for _, f := range describe.Fields {
+			if isRequired(f) && !used[strings.ToLower(f.Name)] {
+				v.errors = append(v.errors, newApiError("REQUIRED_FIELD_MISSING", fmt.Sprintf("Required fields are missing: [%s]", f.Name), f.Name))
+			}
+		}
+	}
+	key := ""
+	switch operation {
+	case "update", "delete", "undelete":
+		key = "Id"
+	case "upsert":
+		key = upsertKey
+	}
+	if key != "" && !used[strings.ToLower(key)] {
+		v.errors = append(v.errors, newApiError("MISSING_ARGUMENT", fmt.Sprintf("%s not specified", key), key))
+	}
+	return v
+}
+
+// Validate returns the errors of a row.
+func (v *recordValidator) Validate(record []string) []*soapforce.Error {
+	errors := append([]*soapforce.Error{}, v.errors...)
+	for i, f := range v.columns {
+		if f == nil || i >= len(record) {
+			continue
+		}
+		if v.operation == "delete" || v.operation == "undelete" {
+			if strings.EqualFold(f.Name, "Id") && !idPattern.MatchString(record[i]) {
+				errors = append(errors, newApiError("MALFORMED_ID", fmt.Sprintf("Id: invalid id: %s", record[i]), f.Name))
+			}
+			continue
+		}
+		if err := validateValue(f, record[i], v.operation); err != nil {
+			errors = append(errors, err)
+		}
+	}
+	return errors
+}
+
+// validateValue checks that the value can be converted to the type of the field.
+func validateValue(f *soapforce.Field, value string, operation string) *soapforce.Error {
+	if value == "" {
+		if operation == "insert" && isRequired(f) {
+			return newApiError("REQUIRED_FIELD_MISSING", fmt.Sprintf("Required fields are missing: [%s]", f.Name), f.Name)
+		}
+		return nil
+	}
+	invalid := func(kind string) *soapforce.Error {
+		return newApiError("INVALID_TYPE_ON_FIELD_IN_RECORD", fmt.Sprintf("%s: value not of required type: %s (%s)", f.Name, value, kind), f.Name)
+	}
+	switch fieldType(f) {
+	case "boolean":
+		switch strings.ToLower(value) {
+		case "true", "false", "1", "0":
+		default:
+			return invalid("boolean")
+		}
+	case "int":
+		if _, err := strconv.ParseInt(value, 10, 32); err != nil {
+			return invalid("int")
+		}
+	case "double", "currency", "percent":
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return invalid("number")
+		}
+		if f.Precision > 0 && integerDigits(value) > int(f.Precision-f.Scale) {
+			return newApiError("NUMBER_OUTSIDE_VALID_RANGE", fmt.Sprintf("%s: number is outside the valid range: %s", f.Name, value), f.Name)
+		}
+	case "date":
+		if _, err := time.Parse("2006-01-02", value); err != nil {
+			return invalid("date")
+		}
+	case "datetime":
+		if !parsable(dateTimeLayouts, value) {
+			return invalid("datetime")
+		}
+	case "time":
+		if !parsable(timeLayouts, value) {
+			return invalid("time")
+		}
+	case "id", "reference":
+		if !idPattern.MatchString(value) {
+			return newApiError("MALFORMED_ID", fmt.Sprintf("%s: id value of incorrect type: %s", f.Name, value), f.Name)
+		}
+	case "picklist", "multipicklist":
+		if f.RestrictedPicklist {
+			values := []string{value}
+			if fieldType(f) == "multipicklist" {
+				values = strings.Split(value, ";")
+			}
+			for _, value := range values {
+				if !isPicklistValue(f, value) {
+					return newApiError("INVALID_OR_NULL_FOR_RESTRICTED_PICKLIST", fmt.Sprintf("%s: bad value for restricted picklist field: %s", f.Name, value), f.Name)
+				}
+			}
+		}
+	}
+	if f.Length > 0 && utf8.RuneCountInString(value) > int(f.Length) {
+		return newApiError("STRING_TOO_LONG", fmt.Sprintf("%s: data value too large: %s (max length=%d)", f.Name, value, f.Length), f.Name)
+	}
+	return nil
+}
+
+func fieldType(f *soapforce.Field) string {
+	if f.Type_ == nil {
+		return ""
+	}
+	return string(*f.Type_)
+}
+
+func isWritable(f *soapforce.Field, operation string) bool {
+	switch operation {
+	case "insert":
+		return f.Createable
+	case "update":
+		return f.Updateable
+	default:
+		return f.Createable || f.Updateable
+	}
+}
+
+func isRequired(f *soapforce.Field) bool {
+	return f.Createable && !f.Nillable && !f.DefaultedOnCreate
+}
+
+func isPicklistValue(f *soapforce.Field, value string) bool {
+	for _, entry := range f.PicklistValues {
+		if entry.Active && entry.Value == value {
+			return true
+		}
+	}
+	return false
+}
+
+func integerDigits(value string) int {
+	value = strings.TrimLeft(value, "+-")
+	if i := strings.Index(value, "."); i >= 0 {
+		value = value[:i]
+	}
+	return len(strings.TrimLeft(value, "0"))
+}
+
+func parsable(layouts []string, value string) bool {
+	for _, layout := range layouts {
+		if _, err := time.Parse(layout, value); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// newApiError creates an error in the same form as the API.
+func newApiError(code string, message string, fields ...string) *soapforce.Error {
+	statusCode := soapforce.StatusCode(code)
+	return &soapforce.Error{StatusCode: &statusCode, Message: message, Fields: fields}
+}
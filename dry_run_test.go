@@ -0,0 +1,93 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/tzmfreedom/go-soapforce"
+)
+
+func newDescribeField(name string, fieldType string, f soapforce.Field) *soapforce.Field {
+	t := soapforce.FieldType(fieldType)
+	f.Name = name
+	f.Type_ = &t
+	return &f
+}
+
+func newAccountDescribe() *soapforce.DescribeSObjectResult {
+	return &soapforce.DescribeSObjectResult{
+		Name: "Account",
+		Fields: []*soapforce.Field{
+			newDescribeField("Id", "id", soapforce.Field{Length: 18}),
+			newDescribeField("Name", "string", soapforce.Field{Createable: true, Updateable: true, Length: 5}),
+			newDescribeField("NumberOfEmployees", "int", soapforce.Field{Createable: true, Updateable: true, Nillable: true}),
+			newDescribeField("AnnualRevenue", "currency", soapforce.Field{Createable: true, Updateable: true, Nillable: true, Precision: 5, Scale: 2}),
+			newDescribeField("IsActive__c", "boolean", soapforce.Field{Createable: true, Updateable: true, DefaultedOnCreate: true}),
+			newDescribeField("Founded__c", "date", soapforce.Field{Createable: true, Updateable: true, Nillable: true}),
+			newDescribeField("Rating", "picklist", soapforce.Field{Createable: true, Updateable: true, Nillable: true, RestrictedPicklist: true,
+				PicklistValues: []*soapforce.PicklistEntry{{Value: "Hot", Active: true}, {Value: "Cold", Active: false}}}),
+			newDescribeField("OwnerId", "reference", soapforce.Field{Createable: true, Updateable: true, DefaultedOnCreate: true, RelationshipName: "Owner"}),
+			newDescribeField("CreatedDate", "datetime", soapforce.Field{}),
+		},
+	}
+}
+
+func errorMessages(errors []*soapforce.Error) string {
+	messages := []string{}
+	for _, e := range errors {
+		messages = append(messages, stringifyStatusCode(e.StatusCode)+": "+e.Message)
+	}
+	return strings.Join(messages, "\n")
+}
+
+func TestRecordValidatorHeaders(t *testing.T) {
+	v := newRecordValidator(newAccountDescribe(), "insert", []string{"yasd__Row", "Nmae", "CreatedDate", "Owner.Email"}, "Id")
+	expected := strings.Join([]string{
+		"INVALID_FIELD: No such column 'Nmae' on entity 'Account'",
+		"INVALID_FIELD_FOR_INSERT_UPDATE: Unable to insert field: CreatedDate",
+		"REQUIRED_FIELD_MISSING: Required fields are missing: [Name]",
+	}, "\n")
+	if actual := errorMessages(v.Validate([]string{"1", "a", "", "a@example.com"})); actual != expected {
+		t.Fatalf("expected: '%s', but '%s'", expected, actual)
+	}
+
+	v = newRecordValidator(newAccountDescribe(), "update", []string{"Name"}, "Id")
+	expected = "MISSING_ARGUMENT: Id not specified"
+	if actual := errorMessages(v.Validate([]string{"a"})); actual != expected {
+		t.Fatalf("expected: '%s', but '%s'", expected, actual)
+	}
+}
+
+func TestRecordValidatorValues(t *testing.T) {
+	headers := []string{"Name", "NumberOfEmployees", "AnnualRevenue", "IsActive__c", "Founded__c", "Rating"}
+	v := newRecordValidator(newAccountDescribe(), "insert", headers, "Id")
+	testCases := []struct {
+		record   []string
+		expected string
+	}{
+		{[]string{"acme", "10", "999.99", "true", "2019-01-31", "Hot"}, ""},
+		{[]string{"", "", "", "", "", ""}, "REQUIRED_FIELD_MISSING: Required fields are missing: [Name]"},
+		{[]string{"acme corp", "", "", "", "", ""}, "STRING_TOO_LONG: Name: data value too large: acme corp (max length=5)"},
+		{[]string{"acme", "ten", "", "", "", ""}, "INVALID_TYPE_ON_FIELD_IN_RECORD: NumberOfEmployees: value not of required type: ten (int)"},
+		{[]string{"acme", "", "1000", "", "", ""}, "NUMBER_OUTSIDE_VALID_RANGE: AnnualRevenue: number is outside the valid range: 1000"},
+		{[]string{"acme", "", "", "yes", "", ""}, "INVALID_TYPE_ON_FIELD_IN_RECORD: IsActive__c: value not of required type: yes (boolean)"},
+		{[]string{"acme", "", "", "", "2019/01/31", ""}, "INVALID_TYPE_ON_FIELD_IN_RECORD: Founded__c: value not of required type: 2019/01/31 (date)"},
+		{[]string{"acme", "", "", "", "", "Cold"}, "INVALID_OR_NULL_FOR_RESTRICTED_PICKLIST: Rating: bad value for restricted picklist field: Cold"},
+	}
+	for _, testCase := range testCases {
+		if actual := errorMessages(v.Validate(testCase.record)); actual != testCase.expected {
+			t.Fatalf("expected: '%s', but '%s'", testCase.expected, actual)
+		}
+	}
+}
+
+func TestRecordValidatorIds(t *testing.T) {
+	v := newRecordValidator(newAccountDescribe(), "delete", []string{"Id"}, "Id")
+	if actual := errorMessages(v.Validate([]string{"001000000000001AAA"})); actual != "" {
+		t.Fatalf("unexpected errors: %s", actual)
+	}
+	expected := "MALFORMED_ID: Id: invalid id: 001"
+	if actual := errorMessages(v.Validate([]string{"001"})); actual != expected {
+		t.Fatalf("expected: '%s', but '%s'", expected, actual)
+	}
+}
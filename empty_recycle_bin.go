@@ -0,0 +1,90 @@
+package main
+
+import (
+	"github.com/tzmfreedom/go-soapforce"
+	"github.com/urfave/cli"
+)
+
+func emptyRecycleBin(c *cli.Context) error {
+	if err := validateEmptyRecycleBinCommand(c); err != nil {
+		return err
+	}
+	client := newClient(c)
+	if err := login(client, c); err != nil {
+		return err
+	}
+
+	retry := newRetrier(c, client)
+	var in *dmlInput
+	var err error
+	if c.String("query") != "" {
+		in, err = openQueryDmlInput(c, "empty-recycle-bin", client, retry)
+	} else {
+		in, err = openDmlInput(c, "empty-recycle-bin")
+	}
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	headers := in.headers
+
+	in.resolver = newReferenceResolver(in.mapping, newSoapQuerier(client, retry))
+	runner := newDmlRunner(c, in, retry, func(batch *dmlBatch) (dmlResult, error) {
+		ids := make([]string, len(batch.records))
+		for i, fields := range batch.records {
+			ids[i] = getId(headers, fields)
+		}
+		res, err := emptyRecycleBinIds(client, retry, ids)
+		if err != nil {
+			return nil, err
+		}
+		return func(h responseHandler) error { return h.HandleDelete(batch, res) }, nil
+	})
+	return runner.Run(in.reader)
+}
+
+// emptyRecycleBinIds removes the records from the recycle bin. The results
+// are returned as delete results, so that they are written in the same format.
+func emptyRecycleBinIds(client *soapforce.Client, retry *retrier, ids []string) ([]*soapforce.DeleteResult, error) {
+	return deleteRecords(retry, len(ids), func(indexes []int) ([]*soapforce.DeleteResult, error) {
+		results, err := client.EmptyRecycleBin(selectIds(ids, indexes))
+		if err != nil {
+			return nil, err
+		}
+		res := make([]*soapforce.DeleteResult, len(results))
+		for i, result := range results {
+			res[i] = &soapforce.DeleteResult{
+				Id:      result.Id,
+				Success: result.Success,
+				Errors:  result.Errors,
+			}
+		}
+		return res, nil
+	})
+}
+
+func validateEmptyRecycleBinCommand(c *cli.Context) error {
+	if c.String("query") != "" {
+		return validateQueryDmlFlags(c, "empty-recycle-bin")
+	}
+	if err := validateLoginFlag(c, "empty-recycle-bin"); err != nil {
+		return err
+	}
+	f := c.String("file")
+	if f == "" {
+		_ = cli.ShowCommandHelp(c, "empty-recycle-bin")
+		return cli.NewExitError("file or query is required", 1)
+	}
+	if c.String("api") == "bulk2" {
+		_ = cli.ShowCommandHelp(c, "empty-recycle-bin")
+		return cli.NewExitError("empty-recycle-bin is not supported by Bulk API 2.0", 1)
+	}
+	if c.Bool("dry-run") {
+		_ = cli.ShowCommandHelp(c, "empty-recycle-bin")
+		return cli.NewExitError("dry-run is not supported by empty-recycle-bin", 1)
+	}
+	if err := validateDmlFlags(c, "empty-recycle-bin"); err != nil {
+		return err
+	}
+	return nil
+}
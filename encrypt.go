@@ -6,30 +6,74 @@ import (
 	"crypto/cipher"
 	"crypto/rand"
 	"encoding/base64"
+	"errors"
+	"fmt"
 	"io"
+	"strings"
 )
 
+// credentialV2Prefix marks the credentials encrypted with AES-GCM. The
+// credentials without a prefix are encrypted with AES-CBC by older versions.
+const credentialV2Prefix = "v2:"
+
+// errCredentialMismatch is returned when the credential is not encrypted with
+// the key, or is modified after encrypted.
+var errCredentialMismatch = errors.New("failed to decrypt the credential: the key does not match or the credential is tampered")
+
 func encrypt(plain []byte, key []byte) (string, error) {
-	block, err := aes.NewCipher(key)
+	gcm, err := newGCM(key)
 	if err != nil {
 		return "", err
 	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	cipherText := gcm.Seal(nonce, nonce, plain, nil)
+	return credentialV2Prefix + base64.StdEncoding.EncodeToString(cipherText), nil
+}
 
-	paddedPlain := padPKCS7(plain)
-	cipherText := make([]byte, aes.BlockSize+len(paddedPlain))
-	iv := cipherText[:aes.BlockSize]
-	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+// decrypt decrypts the credential of both the v2 and the legacy format.
+func decrypt(b64EncodedCipherText string, key []byte) (string, error) {
+	b64EncodedCipherText = strings.TrimSpace(b64EncodedCipherText)
+	if !strings.HasPrefix(b64EncodedCipherText, credentialV2Prefix) {
+		return decryptCBC(b64EncodedCipherText, key)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
 		return "", err
 	}
+	cipherText, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(b64EncodedCipherText, credentialV2Prefix))
+	if err != nil {
+		return "", fmt.Errorf("credential is not base64 encoded: %s", err)
+	}
+	if len(cipherText) < gcm.NonceSize()+gcm.Overhead() {
+		return "", errors.New("credential is too short")
+	}
+	nonce := cipherText[:gcm.NonceSize()]
+	plain, err := gcm.Open(nil, nonce, cipherText[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", errCredentialMismatch
+	}
+	return string(plain), nil
+}
 
-	encrypter := cipher.NewCBCEncrypter(block, iv)
-	encrypter.CryptBlocks(cipherText[aes.BlockSize:], paddedPlain)
+// isLegacyCredential reports whether the credential is encrypted with AES-CBC.
+func isLegacyCredential(credential string) bool {
+	return !strings.HasPrefix(strings.TrimSpace(credential), credentialV2Prefix)
+}
 
-	sEnc := base64.StdEncoding.EncodeToString(cipherText)
-	return sEnc, nil
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
 }
 
-func decrypt(b64EncodedCipherText string, key []byte) (string, error) {
+// decryptCBC decrypts the legacy format, which has no integrity check, so a
+// wrong key is detected by the padding only.
+func decryptCBC(b64EncodedCipherText string, key []byte) (string, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
 		return "", err
@@ -37,15 +81,20 @@ func decrypt(b64EncodedCipherText string, key []byte) (string, error) {
 
 	cipherText, err := base64.StdEncoding.DecodeString(b64EncodedCipherText)
 	if err != nil {
-		return "", err
+		return "", fmt.Errorf("credential is not base64 encoded: %s", err)
+	}
+	if len(cipherText) < 2*aes.BlockSize || len(cipherText)%aes.BlockSize != 0 {
+		return "", errors.New("credential is not a multiple of the block size")
 	}
 
 	plain := make([]byte, len(cipherText[aes.BlockSize:]))
 	decrypter := cipher.NewCBCDecrypter(block, cipherText[:aes.BlockSize])
 	decrypter.CryptBlocks(plain, cipherText[aes.BlockSize:])
-	padSize := int(plain[len(plain)-1])
-
-	return string(plain[:len(plain)-padSize]), nil
+	unpadded, err := unpadPKCS7(plain)
+	if err != nil {
+		return "", errCredentialMismatch
+	}
+	return string(unpadded), nil
 }
 
 func generateKey() ([]byte, error) {
@@ -56,8 +105,16 @@ func generateKey() ([]byte, error) {
 	return key, nil
 }
 
-func padPKCS7(data []byte) []byte {
-	padSize := aes.BlockSize - len(data)%aes.BlockSize
-	appendChars := bytes.Repeat([]byte{byte(padSize)}, padSize)
-	return append(data, appendChars...)
+func unpadPKCS7(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, errors.New("invalid padding")
+	}
+	padSize := int(data[len(data)-1])
+	if padSize == 0 || padSize > aes.BlockSize || padSize > len(data) {
+		return nil, errors.New("invalid padding")
+	}
+	if !bytes.Equal(data[len(data)-padSize:], bytes.Repeat([]byte{byte(padSize)}, padSize)) {
+		return nil, errors.New("invalid padding")
+	}
+	return data[:len(data)-padSize], nil
 }
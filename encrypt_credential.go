@@ -1,10 +1,12 @@
 package main
 
 import (
+	"bufio"
 	"encoding/base64"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"os"
+	"strings"
 	"syscall"
 
 	"github.com/urfave/cli"
@@ -15,6 +17,9 @@ func encryptCredential(c *cli.Context) error {
 	if err := validateEncryptCredential(c); err != nil {
 		return err
 	}
+	if c.Bool("upgrade") {
+		return upgradeCredential(c)
+	}
 	fmt.Print("Password: ")
 	b, err := terminal.ReadPassword(int(syscall.Stdin))
 	if err != nil {
@@ -25,15 +30,10 @@ func encryptCredential(c *cli.Context) error {
 	if password == "" {
 		return cli.NewExitError("password is not blank", 1)
 	}
-	keypath := c.String("key")
-	b64key, err := ioutil.ReadFile(keypath)
+	key, err := readCredentialKey(c)
 	if err != nil {
 		return err
 	}
-	key, err := base64.StdEncoding.DecodeString(string(b64key))
-	if err != nil {
-		return cli.NewExitError("key content is invalid. it should be base64 encoded string", 1)
-	}
 	encryptedPassword, err := encrypt([]byte(password), key)
 	if err != nil {
 		return err
@@ -42,6 +42,82 @@ func encryptCredential(c *cli.Context) error {
 	return nil
 }
 
+func readCredentialKey(c *cli.Context) ([]byte, error) {
+	key, err := readEncryptionKey(c.String("key"))
+	if _, ok := err.(base64.CorruptInputError); ok {
+		return nil, cli.NewExitError("key content is invalid. it should be base64 encoded string", 1)
+	}
+	return key, err
+}
+
+// upgradeCredential re-encrypts a password of the legacy format, which is
+// given by the argument, --password (or the profile) or the standard input.
+// The password of the profile is replaced with the upgraded one.
+func upgradeCredential(c *cli.Context) error {
+	key, err := readCredentialKey(c)
+	if err != nil {
+		return err
+	}
+	credential := c.Args().First()
+	if credential == "" {
+		credential = c.String("password")
+	}
+	if credential == "" {
+		fmt.Fprint(os.Stderr, "Encrypted password: ")
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		credential = strings.TrimSpace(line)
+	}
+	if credential == "" {
+		return cli.NewExitError("encrypted password is not blank", 1)
+	}
+	upgraded, err := upgradeCredentialValue(credential, key)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	name := c.String("profile")
+	if name == "" {
+		fmt.Println(upgraded)
+		return nil
+	}
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	cfg, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+	p, ok := cfg.Profiles[name]
+	if !ok || p.Password != credential {
+		fmt.Println(upgraded)
+		return nil
+	}
+	p.Password = upgraded
+	if err := cfg.Save(path); err != nil {
+		return err
+	}
+	fmt.Printf("The password of profile %s is upgraded\n", name)
+	return nil
+}
+
+// upgradeCredentialValue re-encrypts the credential with AES-GCM, unless it is already.
+func upgradeCredentialValue(credential string, key []byte) (string, error) {
+	if !isLegacyCredential(credential) {
+		if _, err := decrypt(credential, key); err != nil {
+			return "", err
+		}
+		return strings.TrimSpace(credential), nil
+	}
+	plain, err := decrypt(credential, key)
+	if err != nil {
+		return "", err
+	}
+	return encrypt([]byte(plain), key)
+}
+
 func validateEncryptCredential(c *cli.Context) error {
 	key := c.String("key")
 	if key == "" {
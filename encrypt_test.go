@@ -2,9 +2,29 @@ package main
 
 import (
 	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/base64"
+	"strings"
 	"testing"
 )
 
+// encryptCBC encrypts in the legacy format of older versions.
+func encryptCBC(plain []byte, key []byte, iv []byte) string {
+	block, _ := aes.NewCipher(key)
+	padded := padPKCS7(plain)
+	cipherText := make([]byte, aes.BlockSize+len(padded))
+	copy(cipherText, iv)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(cipherText[aes.BlockSize:], padded)
+	return base64.StdEncoding.EncodeToString(cipherText)
+}
+
+func padPKCS7(data []byte) []byte {
+	padSize := aes.BlockSize - len(data)%aes.BlockSize
+	appendChars := bytes.Repeat([]byte{byte(padSize)}, padSize)
+	return append(data, appendChars...)
+}
+
 func TestEncryptDecrypt(t *testing.T) {
 	plain := "test"
 	key, err := generateKey()
@@ -15,8 +35,8 @@ func TestEncryptDecrypt(t *testing.T) {
 	if err != nil {
 		t.Fatalf("unexpected error: %s", err)
 	}
-	if len(encrypted) != 44 {
-		t.Fatalf("encrypted string length is invalid: %d", len(encrypted))
+	if !strings.HasPrefix(encrypted, "v2:") || len(encrypted) != 47 {
+		t.Fatalf("encrypted string is invalid: %s", encrypted)
 	}
 
 	decrypted, err := decrypt(encrypted, key)
@@ -28,6 +48,74 @@ func TestEncryptDecrypt(t *testing.T) {
 	}
 }
 
+func TestDecryptLegacy(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	legacy := encryptCBC([]byte("password"), key, bytes.Repeat([]byte{3}, aes.BlockSize))
+	decrypted, err := decrypt(legacy, key)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if decrypted != "password" {
+		t.Fatalf("expected: '%s', but '%s'", "password", decrypted)
+	}
+
+	upgraded, err := upgradeCredentialValue(legacy, key)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if isLegacyCredential(upgraded) {
+		t.Fatalf("credential is not upgraded: %s", upgraded)
+	}
+	decrypted, err = decrypt(upgraded, key)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if decrypted != "password" {
+		t.Fatalf("expected: '%s', but '%s'", "password", decrypted)
+	}
+	again, err := upgradeCredentialValue(upgraded, key)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if again != upgraded {
+		t.Fatalf("expected: '%s', but '%s'", upgraded, again)
+	}
+}
+
+func TestDecryptError(t *testing.T) {
+	key := bytes.Repeat([]byte{1}, 32)
+	other := bytes.Repeat([]byte{2}, 32)
+	encrypted, err := encrypt([]byte("password"), key)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	b, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(encrypted, "v2:"))
+	b[len(b)-1] ^= 1
+	tampered := "v2:" + base64.StdEncoding.EncodeToString(b)
+	legacy := encryptCBC([]byte("password"), key, bytes.Repeat([]byte{3}, aes.BlockSize))
+
+	testCases := []struct {
+		credential string
+		key        []byte
+		expected   string
+	}{
+		{encrypted, other, errCredentialMismatch.Error()},
+		{tampered, key, errCredentialMismatch.Error()},
+		{legacy, other, errCredentialMismatch.Error()},
+		{"v2:AAAA", key, "credential is too short"},
+		{"", key, "credential is not a multiple of the block size"},
+		{"AAAA", key, "credential is not a multiple of the block size"},
+		{"v2:not base64", key, "credential is not base64 encoded"},
+		{encrypted, []byte("short"), "crypto/aes: invalid key size 5"},
+	}
+	for _, testCase := range testCases {
+		_, err := decrypt(testCase.credential, testCase.key)
+		if err == nil || !strings.HasPrefix(err.Error(), testCase.expected) {
+			t.Fatalf("expected: '%s', but '%v'", testCase.expected, err)
+		}
+	}
+}
+
 func TestGenerateKey(t *testing.T) {
 	first, err := generateKey()
 	if err != nil {
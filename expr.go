@@ -0,0 +1,822 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// The expression language computes the value of a field from the columns of
+// a row. It has no access to anything but the row, the lookup tables of the
+// mapping file and the built-in functions.
+//
+//   LastName + " " + FirstName
+//   if(Amount > 1000, "Big", "Small")
+//   formatDate(`Close Date`, "01/02/2006", "2006-01-02")
+
+// maxExprDepth limits the nesting of an expression.
+const maxExprDepth = 64
+
+type exprTokenType int
+
+const (
+	exprEOF exprTokenType = iota
+	exprIdent
+	exprString
+	exprNumber
+	exprOperator
+	exprLParen
+	exprRParen
+	exprComma
+)
+
+type exprToken struct {
+	typ  exprTokenType
+	text string
+	pos  int
+}
+
+func (t *exprToken) String() string {
+	if t.typ == exprEOF {
+		return "end of expression"
+	}
+	return fmt.Sprintf("'%s'", t.text)
+}
+
+// exprSyntaxError points at the offending token of an expression.
+type exprSyntaxError struct {
+	pos     int
+	near    string
+	message string
+}
+
+func (e *exprSyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at column %d near %s: %s", e.pos+1, e.near, e.message)
+}
+
+var exprOperators = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "+", "-", "*", "/", "%", "!"}
+
+func lexExpr(src string) ([]*exprToken, error) {
+	tokens := []*exprToken{}
+	i := 0
+	for i < len(src) {
+		r, size := utf8.DecodeRuneInString(src[i:])
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+		case r == '(':
+			tokens = append(tokens, &exprToken{typ: exprLParen, text: "(", pos: i})
+			i++
+		case r == ')':
+			tokens = append(tokens, &exprToken{typ: exprRParen, text: ")", pos: i})
+			i++
+		case r == ',':
+			tokens = append(tokens, &exprToken{typ: exprComma, text: ",", pos: i})
+			i++
+		case r == '"' || r == '\'':
+			s, n, err := lexExprString(src, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, &exprToken{typ: exprString, text: s, pos: i})
+			i += n
+		case r == '`':
+			// quoted column name, e.g. `Close Date`
+			j := strings.IndexByte(src[i+1:], '`')
+			if j < 0 {
+				return nil, &exprSyntaxError{pos: i, near: "'`'", message: "unterminated column name"}
+			}
+			tokens = append(tokens, &exprToken{typ: exprIdent, text: src[i+1 : i+1+j], pos: i})
+			i += j + 2
+		case isAsciiDigit(src[i]):
+			j := i
+			for j < len(src) && (isAsciiDigit(src[j]) || src[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, &exprToken{typ: exprNumber, text: src[i:j], pos: i})
+			i = j
+		case unicode.IsLetter(r) || r == '_':
+			j := i
+			for j < len(src) {
+				r, size := utf8.DecodeRuneInString(src[j:])
+				if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '.' {
+					break
+				}
+				j += size
+			}
+			tokens = append(tokens, &exprToken{typ: exprIdent, text: src[i:j], pos: i})
+			i = j
+		default:
+			op := ""
+			for _, o := range exprOperators {
+				if strings.HasPrefix(src[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, &exprSyntaxError{pos: i, near: fmt.Sprintf("'%c'", r), message: "unexpected character"}
+			}
+			tokens = append(tokens, &exprToken{typ: exprOperator, text: op, pos: i})
+			i += len(op)
+		}
+	}
+	return append(tokens, &exprToken{typ: exprEOF, pos: len(src)}), nil
+}
+
+// lexExprString reads a string quoted with " or ', with backslash escapes.
+func lexExprString(src string, start int) (string, int, error) {
+	quote := src[start]
+	buf := &strings.Builder{}
+	for i := start + 1; i < len(src); i++ {
+		switch src[i] {
+		case quote:
+			return buf.String(), i - start + 1, nil
+		case '\\':
+			i++
+			if i >= len(src) {
+				break
+			}
+			switch src[i] {
+			case 'n':
+				buf.WriteByte('\n')
+			case 't':
+				buf.WriteByte('\t')
+			default:
+				buf.WriteByte(src[i])
+			}
+		default:
+			buf.WriteByte(src[i])
+		}
+	}
+	return "", 0, &exprSyntaxError{pos: start, near: fmt.Sprintf("'%c'", quote), message: "unterminated string"}
+}
+
+// expression is a parsed expression of a field.
+type expression struct {
+	src  string
+	root exprNode
+}
+
+type exprNode interface {
+	eval(env *exprEnv) (interface{}, error)
+}
+
+// exprEnv is the row an expression is evaluated with.
+type exprEnv struct {
+	record []string
+	tables map[string]map[string]string
+}
+
+type exprLiteral struct {
+	value interface{}
+}
+
+type exprColumn struct {
+	name  string
+	index int
+}
+
+type exprUnary struct {
+	op string
+	x  exprNode
+}
+
+type exprBinary struct {
+	op   string
+	x, y exprNode
+}
+
+type exprCall struct {
+	name string
+	fn   *exprFunc
+	args []exprNode
+}
+
+type exprParser struct {
+	src    string
+	tokens []*exprToken
+	pos    int
+	depth  int
+}
+
+// parseExpr parses the expression. Columns are resolved later with bind.
+func parseExpr(src string) (*expression, error) {
+	tokens, err := lexExpr(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{src: src, tokens: tokens}
+	root, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.typ != exprEOF {
+		return nil, p.errorf(t, "unexpected token")
+	}
+	return &expression{src: src, root: root}, nil
+}
+
+var exprPrecedences = map[string]int{
+	"||": 1,
+	"&&": 2,
+	"==": 3, "!=": 3,
+	"<": 4, "<=": 4, ">": 4, ">=": 4,
+	"+": 5, "-": 5,
+	"*": 6, "/": 6, "%": 6,
+}
+
+func (p *exprParser) peek() *exprToken {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() *exprToken {
+	t := p.tokens[p.pos]
+	if t.typ != exprEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *exprParser) errorf(t *exprToken, format string, args ...interface{}) error {
+	return &exprSyntaxError{pos: t.pos, near: t.String(), message: fmt.Sprintf(format, args...)}
+}
+
+func (p *exprParser) parseBinary(minPrecedence int) (exprNode, error) {
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		precedence, ok := exprPrecedences[t.text]
+		if t.typ != exprOperator || !ok || precedence <= minPrecedence {
+			return x, nil
+		}
+		p.next()
+		y, err := p.parseBinary(precedence)
+		if err != nil {
+			return nil, err
+		}
+		x = &exprBinary{op: t.text, x: x, y: y}
+	}
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	p.depth++
+	defer func() { p.depth-- }()
+	t := p.peek()
+	if p.depth > maxExprDepth {
+		return nil, p.errorf(t, "expression is too deeply nested")
+	}
+	if t.typ == exprOperator && (t.text == "-" || t.text == "!") {
+		p.next()
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &exprUnary{op: t.text, x: x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	t := p.next()
+	switch t.typ {
+	case exprString:
+		return &exprLiteral{value: t.text}, nil
+	case exprNumber:
+		n, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, p.errorf(t, "invalid number")
+		}
+		return &exprLiteral{value: n}, nil
+	case exprLParen:
+		x, err := p.parseBinary(0)
+		if err != nil {
+			return nil, err
+		}
+		if r := p.next(); r.typ != exprRParen {
+			return nil, p.errorf(r, "expected ')'")
+		}
+		return x, nil
+	case exprIdent:
+		if p.src[t.pos] == '`' {
+			return &exprColumn{name: t.text, index: -1}, nil
+		}
+		if p.peek().typ == exprLParen {
+			return p.parseCall(t)
+		}
+		switch strings.ToLower(t.text) {
+		case "true":
+			return &exprLiteral{value: true}, nil
+		case "false":
+			return &exprLiteral{value: false}, nil
+		case "null":
+			return &exprLiteral{value: nil}, nil
+		}
+		return &exprColumn{name: t.text, index: -1}, nil
+	}
+	return nil, p.errorf(t, "expected value")
+}
+
+func (p *exprParser) parseCall(name *exprToken) (exprNode, error) {
+	fn, ok := exprFuncs[strings.ToLower(name.text)]
+	if !ok {
+		return nil, p.errorf(name, "unknown function")
+	}
+	p.next()
+	args := []exprNode{}
+	if p.peek().typ == exprRParen {
+		p.next()
+	} else {
+		for {
+			arg, err := p.parseBinary(0)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, arg)
+			t := p.next()
+			if t.typ == exprRParen {
+				break
+			}
+			if t.typ != exprComma {
+				return nil, p.errorf(t, "expected ',' or ')'")
+			}
+		}
+	}
+	if len(args) < fn.min || (fn.max >= 0 && len(args) > fn.max) {
+		return nil, p.errorf(name, "wrong number of arguments")
+	}
+	return &exprCall{name: strings.ToLower(name.text), fn: fn, args: args}, nil
+}
+
+// bind resolves the columns and the lookup tables of the expression.
+func (e *expression) bind(indexes map[string]int, tables map[string]map[string]string) error {
+	return walkExpr(e.root, func(n exprNode) error {
+		switch n := n.(type) {
+		case *exprColumn:
+			i, ok := indexes[n.name]
+			if !ok {
+				return fmt.Errorf("unknown column '%s'", n.name)
+			}
+			n.index = i
+		case *exprCall:
+			if n.name != "lookup" {
+				return nil
+			}
+			if table, ok := n.args[0].(*exprLiteral); ok {
+				if _, ok := tables[toExprString(table.value)]; !ok {
+					return fmt.Errorf("unknown table '%s'", toExprString(table.value))
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// columns returns the indexes of the columns referenced by the expression.
+func (e *expression) columns() []int {
+	columns := []int{}
+	walkExpr(e.root, func(n exprNode) error {
+		if c, ok := n.(*exprColumn); ok && c.index >= 0 {
+			columns = append(columns, c.index)
+		}
+		return nil
+	})
+	return columns
+}
+
+func walkExpr(n exprNode, fn func(exprNode) error) error {
+	if err := fn(n); err != nil {
+		return err
+	}
+	switch n := n.(type) {
+	case *exprUnary:
+		return walkExpr(n.x, fn)
+	case *exprBinary:
+		if err := walkExpr(n.x, fn); err != nil {
+			return err
+		}
+		return walkExpr(n.y, fn)
+	case *exprCall:
+		for _, arg := range n.args {
+			if err := walkExpr(arg, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Eval evaluates the expression with the row and returns the value as the field value.
+func (e *expression) Eval(record []string, tables map[string]map[string]string) (string, error) {
+	v, err := e.root.eval(&exprEnv{record: record, tables: tables})
+	if err != nil {
+		return "", err
+	}
+	return toExprString(v), nil
+}
+
+func (n *exprLiteral) eval(env *exprEnv) (interface{}, error) {
+	return n.value, nil
+}
+
+func (n *exprColumn) eval(env *exprEnv) (interface{}, error) {
+	if n.index < 0 || n.index >= len(env.record) {
+		return nil, nil
+	}
+	return env.record[n.index], nil
+}
+
+func (n *exprUnary) eval(env *exprEnv) (interface{}, error) {
+	x, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	if n.op == "!" {
+		return !isTruthy(x), nil
+	}
+	f, err := toExprNumber(x)
+	if err != nil {
+		return nil, err
+	}
+	return -f, nil
+}
+
+func (n *exprBinary) eval(env *exprEnv) (interface{}, error) {
+	x, err := n.x.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "&&":
+		if !isTruthy(x) {
+			return false, nil
+		}
+		y, err := n.y.eval(env)
+		return isTruthy(y), err
+	case "||":
+		if isTruthy(x) {
+			return true, nil
+		}
+		y, err := n.y.eval(env)
+		return isTruthy(y), err
+	}
+	y, err := n.y.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "==", "!=", "<", "<=", ">", ">=":
+		return compareExpr(n.op, x, y)
+	case "+":
+		if xf, yf, ok := exprNumbers(x, y); ok {
+			return xf + yf, nil
+		}
+		return toExprString(x) + toExprString(y), nil
+	}
+	xf, err := toExprNumber(x)
+	if err != nil {
+		return nil, err
+	}
+	yf, err := toExprNumber(y)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "-":
+		return xf - yf, nil
+	case "*":
+		return xf * yf, nil
+	case "/":
+		if yf == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return xf / yf, nil
+	default:
+		if yf == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		return math.Mod(xf, yf), nil
+	}
+}
+
+func (n *exprCall) eval(env *exprEnv) (interface{}, error) {
+	if n.name == "if" {
+		cond, err := n.args[0].eval(env)
+		if err != nil {
+			return nil, err
+		}
+		if isTruthy(cond) {
+			return n.args[1].eval(env)
+		}
+		if len(n.args) < 3 {
+			return nil, nil
+		}
+		return n.args[2].eval(env)
+	}
+	args := make([]interface{}, len(n.args))
+	for i, arg := range n.args {
+		v, err := arg.eval(env)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	v, err := n.fn.call(args, env)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", n.name, err)
+	}
+	return v, nil
+}
+
+// exprNumbers returns the operands as numbers, when one is a number and the
+// other is a number or a numeric column value. Two strings are not numbers,
+// even when they look like numbers, e.g. "007" and "7"; number() converts them.
+func exprNumbers(x, y interface{}) (float64, float64, bool) {
+	_, xNumber := x.(float64)
+	_, yNumber := y.(float64)
+	if !xNumber && !yNumber {
+		return 0, 0, false
+	}
+	xf, err := toExprNumber(x)
+	if err != nil {
+		return 0, 0, false
+	}
+	yf, err := toExprNumber(y)
+	if err != nil {
+		return 0, 0, false
+	}
+	return xf, yf, true
+}
+
+// compareExpr compares the operands as numbers when one is a number, or as strings.
+func compareExpr(op string, x, y interface{}) (bool, error) {
+	var c int
+	_, xNumber := x.(float64)
+	_, yNumber := y.(float64)
+	if xNumber || yNumber {
+		xf, err := toExprNumber(x)
+		if err != nil {
+			return false, err
+		}
+		yf, err := toExprNumber(y)
+		if err != nil {
+			return false, err
+		}
+		switch {
+		case xf < yf:
+			c = -1
+		case xf > yf:
+			c = 1
+		}
+	} else {
+		c = strings.Compare(toExprString(x), toExprString(y))
+	}
+	switch op {
+	case "==":
+		return c == 0, nil
+	case "!=":
+		return c != 0, nil
+	case "<":
+		return c < 0, nil
+	case "<=":
+		return c <= 0, nil
+	case ">":
+		return c > 0, nil
+	default:
+		return c >= 0, nil
+	}
+}
+
+func isTruthy(v interface{}) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0
+	case string:
+		return v != "" && !strings.EqualFold(v, "false") && v != "0"
+	}
+	return true
+}
+
+func toExprString(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return fmt.Sprint(v)
+}
+
+func toExprNumber(v interface{}) (float64, error) {
+	switch v := v.(type) {
+	case nil:
+		return 0, nil
+	case float64:
+		return v, nil
+	case bool:
+		if v {
+			return 1, nil
+		}
+		return 0, nil
+	}
+	s := strings.TrimSpace(toExprString(v))
+	if s == "" {
+		return 0, nil
+	}
+	f, err := strconv.ParseFloat(normalizeNumber(s, ""), 64)
+	// NaN and Inf are not numbers of the input
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, fmt.Errorf("not a number: %s", s)
+	}
+	return f, nil
+}
+
+// exprFunc is a built-in function. max is -1 for variadic functions.
+type exprFunc struct {
+	min  int
+	max  int
+	call func(args []interface{}, env *exprEnv) (interface{}, error)
+}
+
+func stringFunc(fn func(s string) string) *exprFunc {
+	return &exprFunc{min: 1, max: 1, call: func(args []interface{}, env *exprEnv) (interface{}, error) {
+		return fn(toExprString(args[0])), nil
+	}}
+}
+
+func numberFunc(fn func(f float64) float64) *exprFunc {
+	return &exprFunc{min: 1, max: 1, call: func(args []interface{}, env *exprEnv) (interface{}, error) {
+		f, err := toExprNumber(args[0])
+		if err != nil {
+			return nil, err
+		}
+		return fn(f), nil
+	}}
+}
+
+func parseExprDate(value string, layout string) (time.Time, error) {
+	t, err := time.Parse(layout, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("value does not match date layout %s: %s", layout, value)
+	}
+	return t, nil
+}
+
+var exprFuncs map[string]*exprFunc
+
+func init() {
+	exprFuncs = map[string]*exprFunc{
+		// if is evaluated lazily by exprCall
+		"if": {min: 2, max: 3},
+		"concat": {min: 0, max: -1, call: func(args []interface{}, env *exprEnv) (interface{}, error) {
+			buf := &strings.Builder{}
+			for _, arg := range args {
+				buf.WriteString(toExprString(arg))
+			}
+			return buf.String(), nil
+		}},
+		"coalesce": {min: 1, max: -1, call: func(args []interface{}, env *exprEnv) (interface{}, error) {
+			for _, arg := range args {
+				if toExprString(arg) != "" {
+					return arg, nil
+				}
+			}
+			return nil, nil
+		}},
+		"upper": stringFunc(strings.ToUpper),
+		"lower": stringFunc(strings.ToLower),
+		"trim":  stringFunc(strings.TrimSpace),
+		"len": {min: 1, max: 1, call: func(args []interface{}, env *exprEnv) (interface{}, error) {
+			return float64(utf8.RuneCountInString(toExprString(args[0]))), nil
+		}},
+		"substr": {min: 2, max: 3, call: func(args []interface{}, env *exprEnv) (interface{}, error) {
+			runes := []rune(toExprString(args[0]))
+			start, err := toExprNumber(args[1])
+			if err != nil {
+				return nil, err
+			}
+			end := float64(len(runes))
+			if len(args) == 3 {
+				length, err := toExprNumber(args[2])
+				if err != nil {
+					return nil, err
+				}
+				end = start + length
+			}
+			s := int(math.Max(0, math.Min(start, float64(len(runes)))))
+			e := int(math.Max(float64(s), math.Min(end, float64(len(runes)))))
+			return string(runes[s:e]), nil
+		}},
+		"replace": {min: 3, max: 3, call: func(args []interface{}, env *exprEnv) (interface{}, error) {
+			return strings.Replace(toExprString(args[0]), toExprString(args[1]), toExprString(args[2]), -1), nil
+		}},
+		"contains": {min: 2, max: 2, call: func(args []interface{}, env *exprEnv) (interface{}, error) {
+			return strings.Contains(toExprString(args[0]), toExprString(args[1])), nil
+		}},
+		"startswith": {min: 2, max: 2, call: func(args []interface{}, env *exprEnv) (interface{}, error) {
+			return strings.HasPrefix(toExprString(args[0]), toExprString(args[1])), nil
+		}},
+		"endswith": {min: 2, max: 2, call: func(args []interface{}, env *exprEnv) (interface{}, error) {
+			return strings.HasSuffix(toExprString(args[0]), toExprString(args[1])), nil
+		}},
+		"number": numberFunc(func(f float64) float64 { return f }),
+		"text": {min: 1, max: 1, call: func(args []interface{}, env *exprEnv) (interface{}, error) {
+			return toExprString(args[0]), nil
+		}},
+		"abs":   numberFunc(math.Abs),
+		"floor": numberFunc(math.Floor),
+		"ceil":  numberFunc(math.Ceil),
+		"round": {min: 1, max: 2, call: func(args []interface{}, env *exprEnv) (interface{}, error) {
+			f, err := toExprNumber(args[0])
+			if err != nil {
+				return nil, err
+			}
+			digits := 0.0
+			if len(args) == 2 {
+				if digits, err = toExprNumber(args[1]); err != nil {
+					return nil, err
+				}
+			}
+			p := math.Pow(10, digits)
+			return math.Round(f*p) / p, nil
+		}},
+		"min": {min: 1, max: -1, call: func(args []interface{}, env *exprEnv) (interface{}, error) {
+			return foldNumbers(args, math.Min)
+		}},
+		"max": {min: 1, max: -1, call: func(args []interface{}, env *exprEnv) (interface{}, error) {
+			return foldNumbers(args, math.Max)
+		}},
+		"today": {min: 0, max: 0, call: func(args []interface{}, env *exprEnv) (interface{}, error) {
+			return time.Now().Format("2006-01-02"), nil
+		}},
+		"now": {min: 0, max: 0, call: func(args []interface{}, env *exprEnv) (interface{}, error) {
+			return time.Now().UTC().Format("2006-01-02T15:04:05.000Z"), nil
+		}},
+		"formatdate": {min: 3, max: 3, call: func(args []interface{}, env *exprEnv) (interface{}, error) {
+			value := toExprString(args[0])
+			if value == "" {
+				return nil, nil
+			}
+			t, err := parseExprDate(value, toExprString(args[1]))
+			if err != nil {
+				return nil, err
+			}
+			return t.Format(toExprString(args[2])), nil
+		}},
+		"adddays": {min: 2, max: 2, call: func(args []interface{}, env *exprEnv) (interface{}, error) {
+			t, err := parseExprDate(toExprString(args[0]), "2006-01-02")
+			if err != nil {
+				return nil, err
+			}
+			days, err := toExprNumber(args[1])
+			if err != nil {
+				return nil, err
+			}
+			return t.AddDate(0, 0, int(days)).Format("2006-01-02"), nil
+		}},
+		"lookup": {min: 2, max: 3, call: func(args []interface{}, env *exprEnv) (interface{}, error) {
+			name := toExprString(args[0])
+			table, ok := env.tables[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown table '%s'", name)
+			}
+			if v, ok := table[toExprString(args[1])]; ok {
+				return v, nil
+			}
+			if len(args) == 3 {
+				return args[2], nil
+			}
+			return nil, fmt.Errorf("'%s' is not in table '%s'", toExprString(args[1]), name)
+		}},
+	}
+}
+
+func foldNumbers(args []interface{}, fn func(x, y float64) float64) (interface{}, error) {
+	result, err := toExprNumber(args[0])
+	if err != nil {
+		return nil, err
+	}
+	for _, arg := range args[1:] {
+		f, err := toExprNumber(arg)
+		if err != nil {
+			return nil, err
+		}
+		result = fn(result, f)
+	}
+	return result, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestEvalExpr(t *testing.T) {
+	headers := map[string]int{"Name": 0, "Amount": 1, "Empty": 2, "Close Date": 3, "Status": 4, "Price": 5, "Tax": 6}
+	record := []string{" Acme ", "1,200.5", "", "12/31/2019", "A", "900", "1000"}
+	tables := map[string]map[string]string{"status": {"A": "Active"}}
+	testCases := []struct {
+		expr     string
+		expected string
+	}{
+		{`"a" + 'b'`, "ab"},
+		{`1 + 2 * 3`, "7"},
+		{`(1 + 2) * 3`, "9"},
+		{`-Amount + 1`, "-1199.5"},
+		{`7 % 3 / 2`, "0.5"},
+		{`Amount + 1`, "1201.5"},
+		{`Name + 1`, " Acme 1"},
+		{`Price + Tax`, "9001000"},
+		{`number(Price) + number(Tax)`, "1900"},
+		{`number(Price) > number(Tax)`, "false"},
+		{`Price > Tax`, "true"},
+		{`Price + 0 < Tax + 0`, "true"},
+		{`"1" + "2"`, "12"},
+		{`"007" == "7"`, "false"},
+		{`Name + Status`, " Acme A"},
+		{`Empty + Empty`, ""},
+		{`trim(Name) + "!"`, "Acme!"},
+		{`upper(trim(Name))`, "ACME"},
+		{`lower("ABC")`, "abc"},
+		{`len(trim(Name))`, "4"},
+		{`substr("abcdef", 2, 3)`, "cde"},
+		{`substr("abc", 1)`, "bc"},
+		{`replace("a-b-c", "-", "")`, "abc"},
+		{`concat("a", 1, true, null)`, "a1true"},
+		{`contains(Name, "cm") && startsWith("abc", "a") && endsWith("abc", "c")`, "true"},
+		{`coalesce(Empty, "", "x")`, "x"},
+		{`if(Amount > 1000, "Big", "Small")`, "Big"},
+		{`if(Empty, "a", "b")`, "b"},
+		{`if(Empty, "a")`, ""},
+		{`if(true, "a", 1 / 0)`, "a"},
+		{`!Empty || 1 / 0`, "true"},
+		{`Status == "A" && Amount >= 1200.5 && 2 != 3 && "b" > "a" && 1 <= 1`, "true"},
+		{`round(2.345, 2) + floor(1.5) + ceil(1.2) + abs(-1)`, "6.35"},
+		{`round(Amount)`, "1201"},
+		{`min(3, 1, 2) + max(3, 1, 2)`, "4"},
+		{`number("$1,000") + 1`, "1001"},
+		{`text(1.50)`, "1.5"},
+		{`formatDate(` + "`Close Date`" + `, "01/02/2006", "2006-01-02")`, "2019-12-31"},
+		{`formatDate(Empty, "01/02/2006", "2006-01-02")`, ""},
+		{`addDays("2019-12-31", 1)`, "2020-01-01"},
+		{`lookup("status", Status)`, "Active"},
+		{`lookup("status", "X", "Unknown")`, "Unknown"},
+		{`"tab\tquote\""`, "tab\tquote\""},
+	}
+	for _, testCase := range testCases {
+		e, err := parseExpr(testCase.expr)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err := e.bind(headers, tables); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		actual, err := e.Eval(record, tables)
+		if err != nil {
+			t.Fatalf("unexpected error: %s: %s", testCase.expr, err)
+		}
+		if actual != testCase.expected {
+			t.Fatalf("%s: expected: '%s', but '%s'", testCase.expr, testCase.expected, actual)
+		}
+	}
+}
+
+func TestEvalExprError(t *testing.T) {
+	headers := map[string]int{"Name": 0}
+	testCases := []struct {
+		expr     string
+		expected string
+	}{
+		{`1 / (len(Name) - 4)`, "division by zero"},
+		{`Name * 2`, "not a number: Acme"},
+		{`Name > 1`, "not a number: Acme"},
+		{`number("NaN")`, "number: not a number: NaN"},
+		{`formatDate(Name, "2006-01-02", "01/02/2006")`, "formatdate: value does not match date layout 2006-01-02: Acme"},
+		{`lookup("status", Name)`, "lookup: 'Acme' is not in table 'status'"},
+	}
+	tables := map[string]map[string]string{"status": {}}
+	for _, testCase := range testCases {
+		e, err := parseExpr(testCase.expr)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err := e.bind(headers, tables); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		_, err = e.Eval([]string{"Acme"}, tables)
+		if err == nil || err.Error() != testCase.expected {
+			t.Fatalf("expected: '%s', but '%v'", testCase.expected, err)
+		}
+	}
+}
+
+func TestParseExprError(t *testing.T) {
+	testCases := []struct {
+		expr     string
+		expected string
+	}{
+		{`1 +`, "syntax error at column 4 near end of expression: expected value"},
+		{`(1`, "syntax error at column 3 near end of expression: expected ')'"},
+		{`1 2`, "syntax error at column 3 near '2': unexpected token"},
+		{`"abc`, `syntax error at column 1 near '"': unterminated string`},
+		{"`abc", "syntax error at column 1 near '`': unterminated column name"},
+		{`a = 1`, "syntax error at column 3 near '=': unexpected character"},
+		{`exec("rm")`, "syntax error at column 1 near 'exec': unknown function"},
+		{`upper()`, "syntax error at column 1 near 'upper': wrong number of arguments"},
+		{`if(1)`, "syntax error at column 1 near 'if': wrong number of arguments"},
+		{`1.2.3`, "syntax error at column 1 near '1.2.3': invalid number"},
+	}
+	for _, testCase := range testCases {
+		_, err := parseExpr(testCase.expr)
+		if err == nil || err.Error() != testCase.expected {
+			t.Fatalf("expected: '%s', but '%v'", testCase.expected, err)
+		}
+	}
+	deep := ""
+	for i := 0; i < maxExprDepth+1; i++ {
+		deep += "-"
+	}
+	if _, err := parseExpr(deep + "1"); err == nil {
+		t.Fatalf("expected an error for a deeply nested expression")
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+// idMap maps the Ids of the source records to the Ids of the records created
+// from them. Every entry is appended to a CSV file as soon as it is added, so
+// an interrupted migration resumes without creating the records again.
+type idMap struct {
+	mu   sync.Mutex
+	ids  map[string]string
+	file *os.File
+	w    *csv.Writer
+}
+
+var idMapHeader = []string{"Type", "SourceId", "TargetId"}
+
+// openIdMap opens the Id map file. An existing file is loaded only when
+// resuming, so that a new migration does not skip records by mistake.
+func openIdMap(path string, resume bool) (*idMap, error) {
+	m := &idMap{ids: map[string]string{}}
+	b, err := ioutil.ReadFile(path)
+	exists := err == nil
+	if exists && !resume {
+		return nil, fmt.Errorf("%s exists, use --resume to continue the migration or remove the file", path)
+	}
+	// the incomplete line of a killed migration is removed before appending
+	size := strings.LastIndex(string(b), "\n") + 1
+	if exists {
+		if err := m.load(path, b[:size]); err != nil {
+			return nil, err
+		}
+	}
+	m.file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		if err := m.file.Truncate(int64(size)); err != nil {
+			m.file.Close()
+			return nil, err
+		}
+	}
+	m.w = csv.NewWriter(m.file)
+	if size == 0 {
+		m.w.Write(idMapHeader)
+		m.w.Flush()
+		if err := m.w.Error(); err != nil {
+			m.file.Close()
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// load reads the complete lines of the file. A line with an invalid target Id
+// is an error, since skipping it would create the record again.
+func (m *idMap) load(path string, b []byte) error {
+	r := csv.NewReader(strings.NewReader(string(b)))
+	r.FieldsPerRecord = -1
+	for line := 1; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+		if line == 1 && len(record) == len(idMapHeader) && record[1] == idMapHeader[1] {
+			continue
+		}
+		if len(record) != len(idMapHeader) || !idPattern.MatchString(record[2]) {
+			return fmt.Errorf("%s:%d: invalid id map entry: %s", path, line, strings.Join(record, ","))
+		}
+		m.ids[record[1]] = record[2]
+	}
+}
+
+// Get returns the Id of the record created from the source record.
+func (m *idMap) Get(sourceId string) (string, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id, ok := m.ids[sourceId]
+	return id, ok
+}
+
+// Add records the Id of the record created from the source record.
+func (m *idMap) Add(object string, sourceId string, targetId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.ids[sourceId] = targetId
+	if m.w == nil {
+		return nil
+	}
+	m.w.Write([]string{object, sourceId, targetId})
+	m.w.Flush()
+	return m.w.Error()
+}
+
+func (m *idMap) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.ids)
+}
+
+func (m *idMap) Close() error {
+	if m.file == nil {
+		return nil
+	}
+	return m.file.Close()
+}
@@ -1,7 +1,6 @@
 package main
 
 import (
-	"io"
 	"strings"
 
 	"github.com/tzmfreedom/go-soapforce"
@@ -12,62 +11,72 @@ func insert(c *cli.Context) error {
 	if err := validateInsertCommand(c); err != nil {
 		return err
 	}
+	if c.Bool("dry-run") {
+		return dryRun(c, "insert")
+	}
+	if c.String("api") == "bulk2" {
+		return bulk2Dml(c, "insert")
+	}
 	client := newClient(c)
 	if err := login(client, c); err != nil {
 		return err
 	}
 
-	reader, err := getReader(c)
+	in, err := openDmlInput(c, "insert")
 	if err != nil {
 		return err
 	}
-	defer reader.Close()
-
-	sobjects := []*soapforce.SObject{}
-	headers, err := reader.Read()
+	defer in.Close()
+	headers := in.headers
+	t := c.String("type")
+	insertNulls := c.Bool("insert-nulls")
+	describe, err := describeSObject(client, t)
 	if err != nil {
 		return err
 	}
-	headers, err = mapping(headers, c.String("mapping"))
+	in.converter, err = newValueConverterFromFlags(c, describe, in)
 	if err != nil {
 		return err
 	}
-	handler, err := getResponseHandler(c)
-	if err != nil {
+	if err := validateRelationshipColumns(describe, in.headers); err != nil {
 		return err
 	}
-	t := c.String("type")
-	insertNulls := c.Bool("insert-nulls")
-	setReferenceMap(client, t)
 
-	for {
-		fields, err := reader.Read()
-		if err == io.EOF {
-			break
+	retry := newRetrier(c, client)
+	in.resolver = newReferenceResolver(in.mapping, newSoapQuerier(client, retry))
+	jnl, err := openJournal(c.String("journal"), c.Bool("resume"), newSoapQuerier(client, retry))
+	if err != nil {
+		return err
+	}
+	defer jnl.Close()
+	runner := newDmlRunner(c, in, retry, func(batch *dmlBatch) (dmlResult, error) {
+		sobjects := make([]*soapforce.SObject, len(batch.records))
+		for i, fields := range batch.records {
+			sobjects[i] = createInsertSObject(client, t, headers, fields, insertNulls)
 		}
+		jb, err := jnl.Batch()
 		if err != nil {
-			return err
+			return nil, err
 		}
-		sobject := createInsertSObject(client, t, headers, fields, insertNulls)
-		sobjects = append(sobjects, sobject)
-		if len(sobjects) == 200 {
-			res, err := client.Create(sobjects)
-			if err != nil {
-				return err
-			}
-			err = handler.Handle(res)
-			if err != nil {
-				return err
-			}
-			sobjects = sobjects[:0]
+		if err := jb.BeginInsert(t, len(sobjects)); err != nil {
+			return nil, err
 		}
-	}
-	res, err := client.Create(sobjects)
-	if err != nil {
-		return err
-	}
-	err = handler.Handle(res)
-	return err
+		res, err := saveRecords(retry, len(sobjects), func(indexes []int) ([]*soapforce.SaveResult, error) {
+			results, err := client.Create(selectSObjects(sobjects, indexes))
+			return results, createOnce(err)
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := jb.Record("insert", t, savedIds(res)); err != nil {
+			return nil, err
+		}
+		if err := jb.Commit(); err != nil {
+			return nil, err
+		}
+		return func(h responseHandler) error { return h.Handle(batch, res) }, nil
+	})
+	return runner.Run(in.reader)
 }
 
 func validateInsertCommand(c *cli.Context) error {
@@ -84,6 +93,9 @@ func validateInsertCommand(c *cli.Context) error {
 		_ = cli.ShowCommandHelp(c, "insert")
 		return cli.NewExitError("file is required", 1)
 	}
+	if err := validateDmlFlags(c, "insert"); err != nil {
+		return err
+	}
 	return nil
 }
 
@@ -95,15 +107,12 @@ func createInsertSObject(client *soapforce.Client, sObjectType string, headers [
 	}
 	fieldsToNull := []string{}
 	for i, header := range headers {
+		if isResultColumn(header) {
+			continue
+		}
 		if header != "Id" {
 			if strings.Contains(header, ".") {
-				values := strings.Split(header, ".")
-				referenceField := strings.Replace(values[0], "__R", "__r", -1)
-
-				obj := map[string]string{}
-				obj["type"] = globalReferenceMap[client.UserInfo.OrganizationId][referenceField]
-				obj[values[1]] = f[i]
-				fields[values[0]] = obj
+				setRelationshipField(client, sObjectType, fields, header, f[i])
 			} else {
 				if insertNulls && f[i] == "" {
 					fieldsToNull = append(fieldsToNull, header)
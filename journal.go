@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/tzmfreedom/go-soapforce"
+)
+
+// journalEntry is a change of a record made by a DML command. The changes of
+// an API call are written as pending with the values before the change before
+// the call, then the changes made and a commit entry after the call.
+type journalEntry struct {
+	Operation string            `json:"operation"`
+	Type      string            `json:"type,omitempty"`
+	Id        string            `json:"id,omitempty"`
+	Before    map[string]string `json:"before,omitempty"`
+	Batch     string            `json:"batch,omitempty"`
+	Status    string            `json:"status,omitempty"`
+	// Count is the number of the records of a pending insert, whose Ids are not known.
+	Count int `json:"count,omitempty"`
+
+	// line is the line number of the entry in the journal.
+	line int
+	// uncertain is set to the pending changes whose batch is not committed,
+	// which may or may not have been made.
+	uncertain bool
+}
+
+const (
+	journalPending = "pending"
+	journalCommit  = "commit"
+)
+
+// journal appends the changes of a DML command to a JSON lines file, so that
+// the command can be rolled back with the rollback command.
+type journal struct {
+	mu    sync.Mutex
+	file  *os.File
+	query querier
+}
+
+// journalBatch writes the entries of an API call.
+type journalBatch struct {
+	j  *journal
+	id string
+}
+
+// openJournal returns nil when path is empty. An existing journal is
+// appended to only when resuming the command which wrote it.
+func openJournal(path string, resume bool, query querier) (*journal, error) {
+	if path == "" {
+		return nil, nil
+	}
+	b, err := ioutil.ReadFile(path)
+	exists := err == nil
+	if exists && !resume {
+		return nil, fmt.Errorf("%s exists, use --resume to continue the command or remove the file", path)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, err
+	}
+	// the incomplete line of a killed command is removed before appending
+	if exists {
+		if err := f.Truncate(int64(strings.LastIndex(string(b), "\n") + 1)); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return &journal{file: f, query: query}, nil
+}
+
+// Before queries the values of the fields of the records whose key field has
+// one of the values. The values are returned by the Ids of the records.
+func (j *journal) Before(object string, key string, fields []string, values []string) (map[string]map[string]string, error) {
+	if j == nil || len(fields) == 0 {
+		return nil, nil
+	}
+	quoted := []string{}
+	seen := map[string]bool{}
+	for _, v := range values {
+		if v == "" || seen[strings.ToLower(v)] {
+			continue
+		}
+		seen[strings.ToLower(v)] = true
+		quoted = append(quoted, quoteSoqlString(v))
+	}
+	if len(quoted) == 0 {
+		return nil, nil
+	}
+	selected := []string{"Id"}
+	for _, f := range fields {
+		if !strings.EqualFold(f, "Id") {
+			selected = append(selected, f)
+		}
+	}
+	soql := fmt.Sprintf("SELECT %s FROM %s WHERE %s IN (%s)", strings.Join(selected, ", "), object, key, strings.Join(quoted, ", "))
+	records, err := j.query(soql)
+	if err != nil {
+		return nil, err
+	}
+	before := map[string]map[string]string{}
+	for _, record := range records {
+		values := newCaseInsensitiveMap(record.Fields)
+		image := map[string]string{}
+		for _, f := range selected[1:] {
+			image[f] = stringifyValue(values.Get(f))
+		}
+		before[record.Id] = image
+	}
+	return before, nil
+}
+
+// Batch returns the writer of the entries of an API call, which is nil without the journal.
+func (j *journal) Batch() (*journalBatch, error) {
+	if j == nil {
+		return nil, nil
+	}
+	id, err := randomString(9)
+	if err != nil {
+		return nil, err
+	}
+	return &journalBatch{j: j, id: id}, nil
+}
+
+func (j *journal) write(entries []*journalEntry) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	w := bufio.NewWriter(j.file)
+	e := json.NewEncoder(w)
+	for _, entry := range entries {
+		if err := e.Encode(entry); err != nil {
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	// the entries written before the API call must survive a crash during the call
+	return j.file.Sync()
+}
+
+// Begin writes the changes to be made to the records by the API call, with
+// the values before the change, if any.
+func (b *journalBatch) Begin(operation string, object string, ids []string, before map[string]map[string]string) error {
+	if b == nil {
+		return nil
+	}
+	entries := []*journalEntry{}
+	for _, id := range ids {
+		if id != "" {
+			entries = append(entries, &journalEntry{Operation: operation, Type: object, Id: id, Before: before[id], Batch: b.id, Status: journalPending})
+		}
+	}
+	return b.j.write(entries)
+}
+
+// BeginInsert writes the records to be inserted by the API call, whose Ids are not known yet.
+func (b *journalBatch) BeginInsert(object string, count int) error {
+	if b == nil || count == 0 {
+		return nil
+	}
+	return b.j.write([]*journalEntry{{Operation: "insert", Type: object, Batch: b.id, Status: journalPending, Count: count}})
+}
+
+// Record writes the changes made to the records by the API call.
+func (b *journalBatch) Record(operation string, object string, ids []string) error {
+	if b == nil {
+		return nil
+	}
+	entries := []*journalEntry{}
+	for _, id := range ids {
+		if id != "" {
+			entries = append(entries, &journalEntry{Operation: operation, Type: object, Id: id, Batch: b.id})
+		}
+	}
+	return b.j.write(entries)
+}
+
+// Commit writes that the changes of the API call are all recorded, so that
+// the pending changes which are not recorded are known to have failed.
+func (b *journalBatch) Commit() error {
+	if b == nil {
+		return nil
+	}
+	return b.j.write([]*journalEntry{{Operation: journalCommit, Batch: b.id}})
+}
+
+func (j *journal) Close() error {
+	if j == nil {
+		return nil
+	}
+	return j.file.Close()
+}
+
+// journalFields returns the fields changed by the columns of the input.
+func journalFields(describe *soapforce.DescribeSObjectResult, headers []string) []string {
+	fields := []string{}
+	for _, h := range headers {
+		if isResultColumn(h) || strings.EqualFold(h, "Id") {
+			continue
+		}
+		col, err := parseRelationshipColumn(h)
+		if err != nil {
+			continue
+		}
+		if col == nil {
+			fields = append(fields, h)
+			continue
+		}
+		for _, f := range describe.Fields {
+			if strings.EqualFold(f.RelationshipName, col.Relationship) {
+				fields = append(fields, f.Name)
+				break
+			}
+		}
+	}
+	return fields
+}
+
+// journalImageFields returns the fields to recreate a deleted record with,
+// when it cannot be undeleted. Base64 fields are left out, since a query
+// returns them for one record at a time.
+func journalImageFields(describe *soapforce.DescribeSObjectResult) []string {
+	fields := []string{}
+	for _, f := range describe.Fields {
+		if f.Createable && fieldType(f) != "base64" {
+			fields = append(fields, f.Name)
+		}
+	}
+	return fields
+}
+
+// savedIds returns the Ids of the records saved successfully.
+func savedIds(results []*soapforce.SaveResult) []string {
+	ids := []string{}
+	for _, result := range results {
+		if result != nil && result.Success {
+			ids = append(ids, result.Id)
+		}
+	}
+	return ids
+}
+
+// readJournal reads the entries of a journal. The last line is ignored when
+// it is incomplete, since the command may have been killed while writing it.
+func readJournal(path string) ([]*journalEntry, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lines := strings.Split(string(b), "\n")
+	// every entry ends with a newline, so the last element is empty or incomplete
+	lines = lines[:len(lines)-1]
+	entries := []*journalEntry{}
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		entry := &journalEntry{}
+		if err := json.Unmarshal([]byte(line), entry); err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", path, i+1, err)
+		}
+		switch entry.Operation {
+		case "insert", "update", "delete", "undelete":
+			// the Ids of a pending insert are not known
+			pendingInsert := entry.Operation == "insert" && entry.Status == journalPending
+			if entry.Type == "" || (entry.Id == "" && !pendingInsert) {
+				return nil, fmt.Errorf("%s:%d: type and id are required", path, i+1)
+			}
+		case journalCommit:
+			if entry.Batch == "" {
+				return nil, fmt.Errorf("%s:%d: batch is required", path, i+1)
+			}
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown operation '%s'", path, i+1, entry.Operation)
+		}
+		entry.line = i + 1
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// resolveJournal returns the changes of the entries in the order they were
+// made, with the values before the change written before the API call. The
+// pending changes of a batch which is not committed are returned as
+// uncertain, except the inserts, whose Ids are not known and are returned
+// separately.
+func resolveJournal(entries []*journalEntry) ([]*journalEntry, []*journalEntry) {
+	key := func(e *journalEntry) string {
+		return e.Batch + "\x00" + e.Operation + "\x00" + e.Type + "\x00" + e.Id
+	}
+	committed := map[string]bool{}
+	pending := map[string]*journalEntry{}
+	recorded := map[string]bool{}
+	for _, e := range entries {
+		switch {
+		case e.Operation == journalCommit:
+			committed[e.Batch] = true
+		case e.Status == journalPending:
+			pending[key(e)] = e
+		case e.Batch != "":
+			recorded[key(e)] = true
+		}
+	}
+	changes := []*journalEntry{}
+	unknown := []*journalEntry{}
+	for _, e := range entries {
+		switch {
+		case e.Operation == journalCommit:
+		case e.Status == journalPending:
+			if recorded[key(e)] || committed[e.Batch] {
+				// recorded at the change, or failed
+				continue
+			}
+			if e.Id == "" {
+				unknown = append(unknown, e)
+				continue
+			}
+			change := *e
+			change.uncertain = true
+			changes = append(changes, &change)
+		default:
+			change := *e
+			if p, ok := pending[key(e)]; ok {
+				change.Before = p.Before
+			}
+			changes = append(changes, &change)
+		}
+	}
+	return changes, unknown
+}
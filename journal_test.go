@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tzmfreedom/go-soapforce"
+)
+
+func TestJournal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "journal")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "journal.jsonl")
+
+	queries := []string{}
+	query := func(soql string) ([]*soapforce.SObject, error) {
+		queries = append(queries, soql)
+		return []*soapforce.SObject{
+			{Id: "001000000000001AAA", Fields: map[string]interface{}{"Name": "Acme", "Phone": nil}},
+		}, nil
+	}
+	j, err := openJournal(path, false, query)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	before, err := j.Before("Account", "Id", []string{"Name", "Phone"}, []string{"001000000000001AAA", "", "001000000000001AAA", "it's"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := "SELECT Id, Name, Phone FROM Account WHERE Id IN ('001000000000001AAA', 'it\\'s')"
+	if len(queries) != 1 || queries[0] != expected {
+		t.Fatalf("expected: '%s', but '%v'", expected, queries)
+	}
+	jb, err := j.Batch()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := jb.Begin("update", "Account", []string{"001000000000001AAA"}, before); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := jb.BeginInsert("Contact", 2); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := jb.Record("insert", "Contact", []string{"003000000000001AAA", ""}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	j.Close()
+
+	if _, err := openJournal(path, false, query); err == nil || !strings.Contains(err.Error(), "use --resume") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// a line written partially by a killed command
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	f.WriteString(`{"operation":"insert","ty`)
+	f.Close()
+	entries, err := readJournal(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(entries) != 3 {
+		t.Fatalf("unexpected entries: %v", entries)
+	}
+	if e := entries[0]; e.Operation != "update" || e.Id != "001000000000001AAA" || !reflect.DeepEqual(e.Before, map[string]string{"Name": "Acme", "Phone": ""}) || e.Status != journalPending || e.Batch != jb.id || e.line != 1 {
+		t.Fatalf("unexpected entry: %v", e)
+	}
+	if e := entries[1]; e.Operation != "insert" || e.Type != "Contact" || e.Id != "" || e.Count != 2 || e.Status != journalPending || e.line != 2 {
+		t.Fatalf("unexpected entry: %v", e)
+	}
+	if e := entries[2]; e.Operation != "insert" || e.Id != "003000000000001AAA" || e.Before != nil || e.Status != "" || e.line != 3 {
+		t.Fatalf("unexpected entry: %v", e)
+	}
+
+	j, err = openJournal(path, true, query)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	jb, err = j.Batch()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := jb.Record("delete", "Contact", []string{"003000000000002AAA"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := jb.Commit(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	j.Close()
+	entries, err = readJournal(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(entries) != 5 || entries[3].Operation != "delete" || entries[4].Operation != journalCommit || entries[4].Batch != jb.id {
+		t.Fatalf("unexpected entries: %v", entries)
+	}
+}
+
+func TestResolveJournal(t *testing.T) {
+	before := map[string]string{"Name": "Acme"}
+	entries := []*journalEntry{
+		// committed: A1 is updated, A2 failed and the insert has an Id
+		{Operation: "update", Type: "Account", Id: "A1", Before: before, Batch: "b1", Status: journalPending},
+		{Operation: "update", Type: "Account", Id: "A2", Before: before, Batch: "b1", Status: journalPending},
+		{Operation: "insert", Type: "Account", Batch: "b1", Status: journalPending, Count: 1},
+		{Operation: "insert", Type: "Account", Id: "A3", Batch: "b1"},
+		{Operation: "update", Type: "Account", Id: "A1", Batch: "b1"},
+		{Operation: "commit", Batch: "b1"},
+		// killed during the API call
+		{Operation: "delete", Type: "Account", Id: "A4", Before: before, Batch: "b2", Status: journalPending},
+		{Operation: "insert", Type: "Account", Batch: "b2", Status: journalPending, Count: 2},
+	}
+	changes, unknown := resolveJournal(entries)
+	actual := []string{}
+	for _, e := range changes {
+		actual = append(actual, fmt.Sprintf("%s %s %v %v", e.Operation, e.Id, e.Before, e.uncertain))
+	}
+	expected := []string{
+		"insert A3 map[] false",
+		"update A1 map[Name:Acme] false",
+		"delete A4 map[Name:Acme] true",
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected: '%v', but '%v'", expected, actual)
+	}
+	if len(unknown) != 1 || unknown[0].Count != 2 {
+		t.Fatalf("unexpected unknown inserts: %v", unknown)
+	}
+}
+
+func TestJournalFields(t *testing.T) {
+	describe := newTaskDescribe()
+	fields := journalFields(describe, []string{"Id", "Subject", "What:Opportunity.Ext_Id__c", "yasd__Id"})
+	expected := []string{"Subject", "WhatId"}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Fatalf("expected: '%v', but '%v'", expected, fields)
+	}
+}
+
+type rollbackCalls struct {
+	calls []string
+}
+
+func (r *rollbackCalls) add(call string, ids []string) {
+	r.calls = append(r.calls, call+" "+strings.Join(ids, ","))
+}
+
+func TestRollback(t *testing.T) {
+	entries := []*journalEntry{
+		{Operation: "insert", Type: "Account", Id: "A1", line: 1},
+		{Operation: "insert", Type: "Account", Id: "A2", line: 2},
+		{Operation: "update", Type: "Account", Id: "A3", Before: map[string]string{"Name": "First", "Phone": ""}, line: 3},
+		{Operation: "update", Type: "Account", Id: "A3", Before: map[string]string{"Name": "Second", "Phone": "123"}, line: 4},
+		{Operation: "delete", Type: "Contact", Id: "C1", line: 5},
+		{Operation: "undelete", Type: "Contact", Id: "C2", line: 6},
+		// deleted with --hard, and recreated
+		{Operation: "delete", Type: "Contact", Id: "C3", Before: map[string]string{"LastName": "Smith", "Email": ""}, line: 7},
+		{Operation: "insert", Type: "Contact", Batch: "b1", Status: journalPending, Count: 2, line: 8},
+	}
+	calls := &rollbackCalls{}
+	updated := []*soapforce.SObject{}
+	created := []*soapforce.SObject{}
+	out := &bytes.Buffer{}
+	r := &rollbacker{
+		deleteIds: func(ids []string) ([]*soapforce.DeleteResult, error) {
+			calls.add("delete", ids)
+			res := make([]*soapforce.DeleteResult, len(ids))
+			for i, id := range ids {
+				res[i] = &soapforce.DeleteResult{Id: id, Success: id != "A1"}
+			}
+			return res, nil
+		},
+		undeleteIds: func(ids []string) ([]*soapforce.UndeleteResult, error) {
+			calls.add("undelete", ids)
+			res := make([]*soapforce.UndeleteResult, len(ids))
+			for i, id := range ids {
+				res[i] = &soapforce.UndeleteResult{Id: id, Success: id != "C3"}
+			}
+			return res, nil
+		},
+		createSObjects: func(sobjects []*soapforce.SObject) ([]*soapforce.SaveResult, error) {
+			created = append(created, sobjects...)
+			return []*soapforce.SaveResult{{Id: "C4", Success: true}}, nil
+		},
+		updateSObjects: func(sobjects []*soapforce.SObject) ([]*soapforce.SaveResult, error) {
+			ids := []string{}
+			res := make([]*soapforce.SaveResult, len(sobjects))
+			for i, s := range sobjects {
+				ids = append(ids, s.Id)
+				res[i] = &soapforce.SaveResult{Id: s.Id, Success: true}
+			}
+			calls.add("update", ids)
+			updated = append(updated, sobjects...)
+			return res, nil
+		},
+		handler: &NoopResponseWriteHandler{},
+		out:     out,
+	}
+	err := r.Run(entries)
+	if err == nil || err.Error() != "3 changes failed to roll back" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{"undelete C3", "delete C2", "undelete C1", "update A3", "update A3", "delete A2,A1"}
+	if !reflect.DeepEqual(calls.calls, expected) {
+		t.Fatalf("expected: '%v', but '%v'", expected, calls.calls)
+	}
+	if updated[0].Fields["Name"] != "Second" || updated[1].Fields["Name"] != "First" || !reflect.DeepEqual(updated[1].FieldsToNull, []string{"Phone"}) {
+		t.Fatalf("unexpected updates: %v %v", updated[0], updated[1])
+	}
+	if len(created) != 1 || created[0].Id != "" || !reflect.DeepEqual(created[0].Fields, map[string]interface{}{"LastName": "Smith"}) || created[0].FieldsToNull != nil {
+		t.Fatalf("unexpected creates: %v", created)
+	}
+	expectedOut := "2 records of Contact may have been inserted, but cannot be rolled back since their Ids are not known (line 8)\n6 changes rolled back, 3 failed\n"
+	if actual := out.String(); actual != expectedOut {
+		t.Fatalf("expected: '%s', but '%s'", expectedOut, actual)
+	}
+}
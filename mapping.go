@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/tzmfreedom/go-soapforce"
+	"gopkg.in/yaml.v2"
+)
+
+// mappingVersion is the version of the mapping file with field rules. A file
+// without version is the flat format of "column: field".
+const mappingVersion = 2
+
+// mappingColumn maps an input column to a field in the flat format. It is
+// written as the field name, or as a map of the field and the conversion of
+// its values.
+type mappingColumn struct {
+	Field      string     `yaml:"field"`
+	Conversion conversion `yaml:",inline"`
+}
+
+func (m *mappingColumn) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&m.Field); err == nil {
+		return nil
+	}
+	type plain mappingColumn
+	return unmarshal((*plain)(m))
+}
+
+// mappingFile is the versioned mapping file. skip drops the input columns,
+// fields are the rules of the fields in order, and tables are the lookup
+// tables of the expressions.
+type mappingFile struct {
+	Version int                          `yaml:"version"`
+	Skip    []string                     `yaml:"skip"`
+	Tables  map[string]map[string]string `yaml:"tables"`
+	Fields  []*fieldMapping              `yaml:"fields"`
+}
+
+// fieldMapping is the rule of a field. The value is taken from column,
+// columns, value or expr, and then trimmed, replaced, translated with values,
+// changed to case, reformatted as date, and defaulted when empty, in order.
+// lookup replaces the result with the Id of the parent it matches.
+type fieldMapping struct {
+	Field     string            `yaml:"field"`
+	Column    string            `yaml:"column"`
+	Columns   []string          `yaml:"columns"`
+	Separator string            `yaml:"separator"`
+	Value     *string           `yaml:"value"`
+	Expr      string            `yaml:"expr"`
+	Default   string            `yaml:"default"`
+	Trim      bool              `yaml:"trim"`
+	Case      string            `yaml:"case"`
+	Values    map[string]string `yaml:"values"`
+	Replace   []*replaceRule    `yaml:"replace"`
+	Date      *dateRule         `yaml:"date"`
+	Lookup    *lookupRule       `yaml:"lookup"`
+	// Conversion overrides the conversion by the field type.
+	Conversion conversion `yaml:",inline"`
+
+	patterns []*regexp.Regexp
+	expr     *expression
+}
+
+type replaceRule struct {
+	Pattern string `yaml:"pattern"`
+	With    string `yaml:"with"`
+}
+
+// dateRule reformats a date from a layout to another, in Go layout format.
+type dateRule struct {
+	From string `yaml:"from"`
+	To   string `yaml:"to"`
+}
+
+// recordMapping maps the input records to the fields.
+type recordMapping struct {
+	// headers are the fields of the mapped records.
+	headers []string
+	// conversions are the overrides of the conversions of the fields.
+	conversions []*conversion
+	fields      []*compiledField
+	tables      map[string]map[string]string
+	lookups     []*fieldLookup
+}
+
+type compiledField struct {
+	rule *fieldMapping
+	// columns are the indexes of the source columns.
+	columns []int
+}
+
+// newRecordMapping loads the mapping file for the input headers. Without the
+// file, the records are not changed.
+func newRecordMapping(headers []string, path string) (*recordMapping, error) {
+	if path == "" {
+		return identityMapping(headers), nil
+	}
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	file, err := parseMappingFile(buf)
+	if err != nil {
+		return nil, fmt.Errorf("mapping %s: %s", path, err)
+	}
+	m, err := file.compile(headers)
+	if err != nil {
+		return nil, fmt.Errorf("mapping %s: %s", path, err)
+	}
+	return m, nil
+}
+
+func identityMapping(headers []string) *recordMapping {
+	m := &recordMapping{}
+	for i, h := range headers {
+		m.add(&fieldMapping{Field: h}, []int{i})
+	}
+	return m
+}
+
+// parseMappingFile parses the versioned format, or converts the flat format to it.
+func parseMappingFile(buf []byte) (*mappingFile, error) {
+	version := struct {
+		Version interface{} `yaml:"version"`
+	}{}
+	if err := yaml.Unmarshal(buf, &version); err != nil {
+		return nil, err
+	}
+	if _, ok := version.Version.(int); !ok {
+		return parseFlatMapping(buf)
+	}
+	file := &mappingFile{}
+	if err := yaml.UnmarshalStrict(buf, file); err != nil {
+		return nil, err
+	}
+	if file.Version != mappingVersion {
+		return nil, fmt.Errorf("unsupported version %d", file.Version)
+	}
+	for i, f := range file.Fields {
+		if err := f.validate(); err != nil {
+			return nil, fmt.Errorf("fields[%d] (%s): %s", i, f.Field, err)
+		}
+	}
+	return file, nil
+}
+
+func parseFlatMapping(buf []byte) (*mappingFile, error) {
+	columns := yaml.MapSlice{}
+	if err := yaml.Unmarshal(buf, &columns); err != nil {
+		return nil, err
+	}
+	file := &mappingFile{}
+	for _, item := range columns {
+		column := fmt.Sprint(item.Key)
+		b, err := yaml.Marshal(item.Value)
+		if err != nil {
+			return nil, err
+		}
+		m := &mappingColumn{}
+		if err := yaml.Unmarshal(b, m); err != nil {
+			return nil, fmt.Errorf("%s: %s", column, err)
+		}
+		field := m.Field
+		if field == "" {
+			field = column
+		}
+		file.Fields = append(file.Fields, &fieldMapping{Field: field, Column: column, Conversion: m.Conversion})
+	}
+	return file, nil
+}
+
+func (f *fieldMapping) validate() error {
+	if f.Field == "" {
+		return fmt.Errorf("field is required")
+	}
+	sources := 0
+	if f.Column != "" {
+		sources++
+	}
+	if len(f.Columns) > 0 {
+		sources++
+	}
+	if f.Value != nil {
+		sources++
+	}
+	if f.Expr != "" {
+		sources++
+	}
+	if sources != 1 {
+		return fmt.Errorf("one of column, columns, value and expr is required")
+	}
+	if f.Expr != "" {
+		e, err := parseExpr(f.Expr)
+		if err != nil {
+			return fmt.Errorf("expr: %s", err)
+		}
+		f.expr = e
+	}
+	switch strings.ToLower(f.Case) {
+	case "", "upper", "lower":
+	default:
+		return fmt.Errorf("case must be upper or lower: %s", f.Case)
+	}
+	for _, r := range f.Replace {
+		p, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return fmt.Errorf("replace: %s", err)
+		}
+		f.patterns = append(f.patterns, p)
+	}
+	if f.Date != nil {
+		if f.Date.From == "" {
+			return fmt.Errorf("date.from is required")
+		}
+		if f.Date.To == "" {
+			f.Date.To = "2006-01-02"
+		}
+	}
+	if f.Lookup != nil {
+		return f.Lookup.validate()
+	}
+	return nil
+}
+
+// compile resolves the columns of the rules in the input headers. Columns
+// which are not used by any rule nor skipped are mapped to the same field.
+func (file *mappingFile) compile(headers []string) (*recordMapping, error) {
+	indexes := map[string]int{}
+	for i, h := range headers {
+		if _, ok := indexes[h]; !ok {
+			indexes[h] = i
+		}
+	}
+	used := map[string]bool{}
+	for _, s := range file.Skip {
+		used[s] = true
+	}
+	m := &recordMapping{tables: file.Tables}
+	for _, f := range file.Fields {
+		if f.expr != nil {
+			if err := f.expr.bind(indexes, file.Tables); err != nil {
+				return nil, fmt.Errorf("expr of %s: %s", f.Field, err)
+			}
+			columns := f.expr.columns()
+			for _, i := range columns {
+				used[headers[i]] = true
+			}
+			m.add(f, columns)
+			continue
+		}
+		columns := []int{}
+		names := f.Columns
+		if f.Column != "" {
+			names = []string{f.Column}
+		}
+		for _, name := range names {
+			i, ok := indexes[name]
+			if !ok {
+				if file.Version == 0 {
+					// flat mapping ignores columns which are not in the file
+					continue
+				}
+				return nil, fmt.Errorf("column '%s' of %s is not in the input file", name, f.Field)
+			}
+			columns = append(columns, i)
+			used[name] = true
+		}
+		if file.Version == 0 && len(columns) == 0 {
+			continue
+		}
+		m.add(f, columns)
+	}
+	mapped := map[int]bool{}
+	for _, f := range m.fields {
+		for _, i := range f.columns {
+			mapped[i] = true
+		}
+	}
+	for i, h := range headers {
+		if !used[h] && !mapped[i] {
+			m.add(&fieldMapping{Field: h}, []int{i})
+		}
+	}
+	if file.Version == 0 {
+		m.sortByColumn()
+	}
+	for i, f := range m.fields {
+		if f.rule.Lookup != nil {
+			m.lookups = append(m.lookups, &fieldLookup{index: i, field: f.rule.Field, rule: f.rule.Lookup})
+		}
+	}
+	return m, nil
+}
+
+func (m *recordMapping) add(f *fieldMapping, columns []int) {
+	m.fields = append(m.fields, &compiledField{rule: f, columns: columns})
+	m.headers = append(m.headers, f.Field)
+	if f.Conversion.isEmpty() {
+		m.conversions = append(m.conversions, nil)
+	} else {
+		m.conversions = append(m.conversions, &f.Conversion)
+	}
+}
+
+// sortByColumn keeps the order of the input columns for the flat format.
+func (m *recordMapping) sortByColumn() {
+	for i := 1; i < len(m.fields); i++ {
+		for j := i; j > 0 && m.fields[j].columns[0] < m.fields[j-1].columns[0]; j-- {
+			m.fields[j], m.fields[j-1] = m.fields[j-1], m.fields[j]
+			m.headers[j], m.headers[j-1] = m.headers[j-1], m.headers[j]
+			m.conversions[j], m.conversions[j-1] = m.conversions[j-1], m.conversions[j]
+		}
+	}
+}
+
+// Map returns the values of the fields for the input record.
+func (m *recordMapping) Map(record []string) ([]string, []*soapforce.Error) {
+	values := make([]string, len(m.fields))
+	var errors []*soapforce.Error
+	for i, f := range m.fields {
+		value, err := f.value(record, m.tables)
+		if err != nil {
+			errors = append(errors, newApiError("INVALID_TYPE_ON_FIELD_IN_RECORD", fmt.Sprintf("%s: %s", f.rule.Field, err), f.rule.Field))
+			continue
+		}
+		values[i] = value
+	}
+	return values, errors
+}
+
+func (f *compiledField) value(record []string, tables map[string]map[string]string) (string, error) {
+	rule := f.rule
+	var value string
+	if rule.expr != nil {
+		v, err := rule.expr.Eval(record, tables)
+		if err != nil {
+			return "", err
+		}
+		value = v
+	} else if rule.Value != nil {
+		value = *rule.Value
+	} else {
+		values := make([]string, 0, len(f.columns))
+		for _, i := range f.columns {
+			if i < len(record) {
+				values = append(values, record[i])
+			}
+		}
+		if len(rule.Columns) > 0 {
+			value = joinNonEmpty(values, rule.Separator)
+		} else if len(values) > 0 {
+			value = values[0]
+		}
+	}
+	if rule.Trim {
+		value = strings.TrimSpace(value)
+	}
+	for i, p := range rule.patterns {
+		value = p.ReplaceAllString(value, rule.Replace[i].With)
+	}
+	if v, ok := rule.Values[value]; ok {
+		value = v
+	}
+	switch strings.ToLower(rule.Case) {
+	case "upper":
+		value = strings.ToUpper(value)
+	case "lower":
+		value = strings.ToLower(value)
+	}
+	if rule.Date != nil && value != "" {
+		t, err := time.Parse(rule.Date.From, value)
+		if err != nil {
+			return "", fmt.Errorf("value does not match date layout %s: %s", rule.Date.From, value)
+		}
+		value = t.Format(rule.Date.To)
+	}
+	if value == "" {
+		value = rule.Default
+	}
+	return value, nil
+}
+
+func joinNonEmpty(values []string, separator string) string {
+	buf := &bytes.Buffer{}
+	for _, v := range values {
+		if v == "" {
+			continue
+		}
+		if buf.Len() > 0 {
+			buf.WriteString(separator)
+		}
+		buf.WriteString(v)
+	}
+	return buf.String()
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRecordMapping(t *testing.T) {
+	headers := []string{"AccountName", "Status", "LastName", "FirstName", "Tel", "Code", "Birthday", "Memo", "Description"}
+	m, err := newRecordMapping(headers, "test/mapping_v2.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := []string{"Name", "Country__c", "Status__c", "FullName__c", "Phone", "Code__c", "Birthdate", "Description"}
+	if !reflect.DeepEqual(m.headers, expected) {
+		t.Fatalf("expected: %v, but %v", expected, m.headers)
+	}
+	values, errors := m.Map([]string{" acme ", "Active", "Yamada", "Taro", "03-1234-5678", "ab", "01/31/2019", "memo", "desc"})
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %s", errorMessages(errors))
+	}
+	expected = []string{"acme", "Japan", "有効", "Yamada Taro", "0312345678", "AB", "2019-01-31", "desc"}
+	if !reflect.DeepEqual(values, expected) {
+		t.Fatalf("expected: %v, but %v", expected, values)
+	}
+	values, errors = m.Map([]string{"acme", "Unknown", "", "Taro", "", "", "2019-01-31", "", ""})
+	expected = []string{"acme", "Japan", "Unknown", "Taro", "", "NONE", "", ""}
+	if !reflect.DeepEqual(values, expected) {
+		t.Fatalf("expected: %v, but %v", expected, values)
+	}
+	expectedError := "INVALID_TYPE_ON_FIELD_IN_RECORD: Birthdate: value does not match date layout 01/02/2006: 2019-01-31"
+	if actual := errorMessages(errors); actual != expectedError {
+		t.Fatalf("expected: '%s', but '%s'", expectedError, actual)
+	}
+}
+
+func TestExprMapping(t *testing.T) {
+	headers := []string{"LastName", "FirstName", "Amount", "Status", "Close Date"}
+	m, err := newRecordMapping(headers, "test/mapping_expr.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := []string{"Name", "Size__c", "StageName", "CloseDate"}
+	if !reflect.DeepEqual(m.headers, expected) {
+		t.Fatalf("expected: %v, but %v", expected, m.headers)
+	}
+	values, errors := m.Map([]string{"Yamada", "Taro", "1,500", "W", "03/31/2019"})
+	if len(errors) > 0 {
+		t.Fatalf("unexpected errors: %s", errorMessages(errors))
+	}
+	expected = []string{"YAMADA Taro", "Big", "Closed Won", "2019-03-31"}
+	if !reflect.DeepEqual(values, expected) {
+		t.Fatalf("expected: %v, but %v", expected, values)
+	}
+	values, errors = m.Map([]string{"Sato", "", "abc", "X", ""})
+	expected = []string{"SATO", "", "Prospecting", ""}
+	if !reflect.DeepEqual(values, expected) {
+		t.Fatalf("expected: %v, but %v", expected, values)
+	}
+	expectedError := "INVALID_TYPE_ON_FIELD_IN_RECORD: Size__c: not a number: abc"
+	if actual := errorMessages(errors); actual != expectedError {
+		t.Fatalf("expected: '%s', but '%s'", expectedError, actual)
+	}
+}
+
+func TestFlatMapping(t *testing.T) {
+	m, err := newRecordMapping([]string{"Phone", "Name", "Memo"}, "test/conversion_mapping.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := []string{"Phone", "Name", "Memo"}
+	if !reflect.DeepEqual(m.headers, expected) {
+		t.Fatalf("expected: %v, but %v", expected, m.headers)
+	}
+
+	m, err = newRecordMapping([]string{"Code", "Active", "Name"}, "test/conversion_mapping.yaml")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected = []string{"Code__c", "IsActive__c", "Name"}
+	if !reflect.DeepEqual(m.headers, expected) {
+		t.Fatalf("expected: %v, but %v", expected, m.headers)
+	}
+	values, _ := m.Map([]string{"1", "有効", "a"})
+	if !reflect.DeepEqual(values, []string{"1", "有効", "a"}) {
+		t.Fatalf("unexpected values: %v", values)
+	}
+}
+
+func TestInvalidMapping(t *testing.T) {
+	testCases := []struct {
+		mapping  string
+		expected string
+	}{
+		{"version: 3\n", "unsupported version 3"},
+		{"version: 2\nfields:\n  - column: Name\n", "fields[0] (): field is required"},
+		{"version: 2\nfields:\n  - field: Name\n", "fields[0] (Name): one of column, columns, value and expr is required"},
+		{"version: 2\nfields:\n  - field: Name\n    column: Name\n    value: a\n", "fields[0] (Name): one of column, columns, value and expr is required"},
+		{"version: 2\nfields:\n  - field: Name\n    column: Name\n    case: title\n", "fields[0] (Name): case must be upper or lower: title"},
+		{"version: 2\nfields:\n  - field: Name\n    column: Name\n    replace:\n      - pattern: \"[\"\n", "fields[0] (Name): replace: error parsing regexp"},
+		{"version: 2\nfields:\n  - field: Name\n    colum: Name\n", "field colum not found"},
+		{"version: 2\nfields:\n  - field: Name\n    column: AccountName\n", "column 'AccountName' of Name is not in the input file"},
+		{"version: 2\nfields:\n  - field: Name\n    expr: upper(Name\n", "fields[0] (Name): expr: syntax error at column 11 near end of expression: expected ',' or ')'"},
+		{"version: 2\nfields:\n  - field: Name\n    expr: title(Name)\n", "fields[0] (Name): expr: syntax error at column 1 near 'title': unknown function"},
+		{"version: 2\nfields:\n  - field: AccountId\n    column: Name\n    lookup:\n      object: Account\n", "fields[0] (AccountId): lookup.object and lookup.field are required"},
+		{"version: 2\nfields:\n  - field: AccountId\n    column: Name\n    lookup:\n      object: Account\n      field: Name FROM User --\n", "fields[0] (AccountId): lookup.field is not a field name: Name FROM User --"},
+		{"version: 2\nfields:\n  - field: Name\n    expr: upper(AccountName)\n", "expr of Name: unknown column 'AccountName'"},
+		{"version: 2\nfields:\n  - field: Name\n    expr: lookup(\"status\", Name)\n", "expr of Name: unknown table 'status'"},
+	}
+	f, err := ioutil.TempFile("", "mapping")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.Remove(f.Name())
+	f.Close()
+	for _, testCase := range testCases {
+		ioutil.WriteFile(f.Name(), []byte(testCase.mapping), 0644)
+		_, err := newRecordMapping([]string{"Name"}, f.Name())
+		if err == nil || !strings.Contains(err.Error(), testCase.expected) {
+			t.Fatalf("expected: '%s', but '%v'", testCase.expected, err)
+		}
+	}
+}
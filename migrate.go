@@ -0,0 +1,487 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/tzmfreedom/go-soapforce"
+	"github.com/urfave/cli"
+	"gopkg.in/yaml.v2"
+)
+
+// migrationPlan lists the objects copied from the source org to the target
+// org. The objects are loaded in the order of their references, not in the
+// order of the plan.
+type migrationPlan struct {
+	Objects []*migrationObject `yaml:"objects"`
+}
+
+type migrationObject struct {
+	Type  string `yaml:"type"`
+	Where string `yaml:"where"`
+	// Fields are the fields copied. By default every field which is createable
+	// in the target org is copied, except references to objects out of the plan.
+	Fields []string `yaml:"fields"`
+}
+
+// migrationStep is an object of the plan with the fields to copy.
+type migrationStep struct {
+	object string
+	where  string
+	// fields are set on insert, and deferred are the references to the
+	// objects loaded later, set by update after every object is inserted.
+	fields   []string
+	deferred []string
+	// references are the Id fields rewritten with the Id map.
+	references map[string][]string
+}
+
+func migrate(c *cli.Context) error {
+	if err := validateMigrateCommand(c); err != nil {
+		return err
+	}
+	plan, err := loadMigrationPlan(c.String("plan"))
+	if err != nil {
+		return err
+	}
+	source := newClient(c)
+	if err := login(source, c); err != nil {
+		return err
+	}
+	target := soapforce.NewClient()
+	target.SetDebug(c.Bool("debug"))
+	target.SetLoginUrl(c.String("target-endpoint"))
+	targetLogin := func() error {
+		_, err := authenticateWith(target, c.String("target-username"), c.String("target-password"), c.String("target-key"))
+		return err
+	}
+	if err := targetLogin(); err != nil {
+		return err
+	}
+	sourceRetry := newRetrier(c, source)
+	targetRetry := newLoginRetrier(c.Int("max-retries"), targetLogin)
+
+	sourceDescribes := map[string]*soapforce.DescribeSObjectResult{}
+	targetDescribes := map[string]*soapforce.DescribeSObjectResult{}
+	for _, o := range plan.Objects {
+		key := strings.ToLower(o.Type)
+		err := sourceRetry.Do(func() error {
+			var err error
+			sourceDescribes[key], err = source.DescribeSObject(o.Type)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		err = targetRetry.Do(func() error {
+			var err error
+			targetDescribes[key], err = target.DescribeSObject(o.Type)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	steps, err := planMigration(plan, sourceDescribes, targetDescribes)
+	if err != nil {
+		return err
+	}
+
+	ids, err := openIdMap(c.String("id-map"), c.Bool("resume"))
+	if err != nil {
+		return err
+	}
+	defer ids.Close()
+	errorFile, errors, err := openMigrationErrorFile(c.String("error-file"), c.Bool("resume"))
+	if err != nil {
+		return err
+	}
+	if errorFile != nil {
+		defer errorFile.Close()
+	}
+
+	m := &migrator{
+		ids:    ids,
+		errors: errors,
+		out:    os.Stdout,
+		query: func(soql string, fn func([]*soapforce.SObject) error) error {
+			return queryPages(source, sourceRetry, soql, fn)
+		},
+		create: saveSObjects(targetRetry, func(sobjects []*soapforce.SObject) ([]*soapforce.SaveResult, error) {
+			res, err := target.Create(sobjects)
+			return res, createOnce(err)
+		}),
+		update: saveSObjects(targetRetry, target.Update),
+	}
+	return m.Run(steps)
+}
+
+func validateMigrateCommand(c *cli.Context) error {
+	if err := validateLoginFlag(c, "migrate"); err != nil {
+		return err
+	}
+	for _, name := range []string{"target-username", "target-password", "target-endpoint", "plan", "id-map"} {
+		if c.String(name) == "" {
+			_ = cli.ShowCommandHelp(c, "migrate")
+			return cli.NewExitError(fmt.Sprintf("%s is required", name), 1)
+		}
+	}
+	return nil
+}
+
+func loadMigrationPlan(path string) (*migrationPlan, error) {
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	plan := &migrationPlan{}
+	if err := yaml.UnmarshalStrict(buf, plan); err != nil {
+		return nil, fmt.Errorf("plan %s: %s", path, err)
+	}
+	if len(plan.Objects) == 0 {
+		return nil, fmt.Errorf("plan %s: objects are required", path)
+	}
+	types := map[string]bool{}
+	for i, o := range plan.Objects {
+		if !soqlNamePattern.MatchString(o.Type) || strings.Contains(o.Type, ".") {
+			return nil, fmt.Errorf("plan %s: objects[%d]: type is not an object name: '%s'", path, i, o.Type)
+		}
+		if types[strings.ToLower(o.Type)] {
+			return nil, fmt.Errorf("plan %s: objects[%d]: %s is listed twice", path, i, o.Type)
+		}
+		types[strings.ToLower(o.Type)] = true
+		for _, f := range o.Fields {
+			if !soqlNamePattern.MatchString(f) || strings.Contains(f, ".") {
+				return nil, fmt.Errorf("plan %s: objects[%d]: not a field name: '%s'", path, i, f)
+			}
+		}
+	}
+	return plan, nil
+}
+
+// planMigration selects the fields of the objects and orders the objects so
+// that the parents are loaded before their children. References which cannot
+// be set on insert, such as self references and cycles, are deferred.
+func planMigration(plan *migrationPlan, source, target map[string]*soapforce.DescribeSObjectResult) ([]*migrationStep, error) {
+	inPlan := map[string]bool{}
+	for _, o := range plan.Objects {
+		inPlan[strings.ToLower(o.Type)] = true
+	}
+	steps := []*migrationStep{}
+	for _, o := range plan.Objects {
+		step, err := newMigrationStep(o, source[strings.ToLower(o.Type)], target[strings.ToLower(o.Type)], inPlan)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, step)
+	}
+
+	ordered := []*migrationStep{}
+	loaded := map[string]bool{}
+	for len(ordered) < len(steps) {
+		var next *migrationStep
+		for _, step := range steps {
+			if !loaded[strings.ToLower(step.object)] && step.ready(loaded) {
+				next = step
+				break
+			}
+		}
+		if next == nil {
+			// a cycle, broken in the order of the plan
+			for _, step := range steps {
+				if !loaded[strings.ToLower(step.object)] {
+					next = step
+					break
+				}
+			}
+		}
+		fields := []string{}
+		for _, f := range next.fields {
+			if targets, ok := next.references[f]; ok && !allLoaded(targets, loaded) {
+				next.deferred = append(next.deferred, f)
+				continue
+			}
+			fields = append(fields, f)
+		}
+		next.fields = fields
+		loaded[strings.ToLower(next.object)] = true
+		ordered = append(ordered, next)
+	}
+	return ordered, nil
+}
+
+func newMigrationStep(o *migrationObject, source, target *soapforce.DescribeSObjectResult, inPlan map[string]bool) (*migrationStep, error) {
+	if source == nil || target == nil {
+		return nil, fmt.Errorf("%s is not described", o.Type)
+	}
+	step := &migrationStep{object: source.Name, where: o.Where, references: map[string][]string{}}
+	listed := map[string]bool{}
+	for _, f := range o.Fields {
+		listed[strings.ToLower(f)] = true
+	}
+	targetFields := map[string]*soapforce.Field{}
+	for _, f := range target.Fields {
+		targetFields[strings.ToLower(f.Name)] = f
+	}
+	found := map[string]bool{}
+	for _, f := range source.Fields {
+		name := strings.ToLower(f.Name)
+		if name == "id" || (len(listed) > 0 && !listed[name]) {
+			continue
+		}
+		found[name] = true
+		if t := targetFields[name]; t == nil || !t.Createable {
+			if listed[name] {
+				return nil, fmt.Errorf("%s.%s is not createable in the target org", source.Name, f.Name)
+			}
+			continue
+		}
+		if fieldType(f) == "reference" {
+			targets := []string{}
+			for _, t := range f.ReferenceTo {
+				if inPlan[strings.ToLower(t)] {
+					targets = append(targets, t)
+				}
+			}
+			if len(targets) > 0 {
+				step.references[f.Name] = targets
+			} else if !listed[name] {
+				// the Ids of the records out of the plan differ between the orgs
+				continue
+			}
+		}
+		step.fields = append(step.fields, f.Name)
+	}
+	for _, f := range o.Fields {
+		if !found[strings.ToLower(f)] {
+			return nil, fmt.Errorf("%s.%s does not exist in the source org", source.Name, f)
+		}
+	}
+	return step, nil
+}
+
+// ready returns whether every parent of the object, but itself, is loaded.
+func (step *migrationStep) ready(loaded map[string]bool) bool {
+	for _, targets := range step.references {
+		for _, t := range targets {
+			if !strings.EqualFold(t, step.object) && !loaded[strings.ToLower(t)] {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func allLoaded(objects []string, loaded map[string]bool) bool {
+	for _, o := range objects {
+		if !loaded[strings.ToLower(o)] {
+			return false
+		}
+	}
+	return true
+}
+
+func (step *migrationStep) query(fields []string) string {
+	q := "SELECT " + strings.Join(append([]string{"Id"}, fields...), ", ") + " FROM " + step.object
+	if step.where != "" {
+		q += " WHERE " + step.where
+	}
+	return q
+}
+
+// migrator copies the records of the steps from the source org to the target org.
+type migrator struct {
+	ids    *idMap
+	errors *csv.Writer
+	out    io.Writer
+	query  func(soql string, fn func(records []*soapforce.SObject) error) error
+	create func(sobjects []*soapforce.SObject) ([]*soapforce.SaveResult, error)
+	update func(sobjects []*soapforce.SObject) ([]*soapforce.SaveResult, error)
+}
+
+type migrationStats struct {
+	created, updated, skipped, failed int
+}
+
+func (m *migrator) Run(steps []*migrationStep) error {
+	failed := 0
+	for _, step := range steps {
+		stats, err := m.insert(step)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(m.out, "%s: %d created, %d already migrated, %d failed\n", step.object, stats.created, stats.skipped, stats.failed)
+		failed += stats.failed
+	}
+	for _, step := range steps {
+		if len(step.deferred) == 0 {
+			continue
+		}
+		stats, err := m.updateDeferred(step)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(m.out, "%s: %d updated with %s, %d failed\n", step.object, stats.updated, strings.Join(step.deferred, ", "), stats.failed)
+		failed += stats.failed
+	}
+	if failed > 0 {
+		return cli.NewExitError(fmt.Sprintf("%d records failed to migrate", failed), 1)
+	}
+	return nil
+}
+
+func (m *migrator) insert(step *migrationStep) (*migrationStats, error) {
+	stats := &migrationStats{}
+	err := m.query(step.query(step.fields), func(records []*soapforce.SObject) error {
+		sourceIds := []string{}
+		sobjects := []*soapforce.SObject{}
+		for _, record := range records {
+			if _, ok := m.ids.Get(record.Id); ok {
+				stats.skipped++
+				continue
+			}
+			sobject := &soapforce.SObject{Type: step.object, Fields: map[string]interface{}{}}
+			if err := m.setFields(step, sobject, record, step.fields); err != nil {
+				stats.failed++
+				if err := m.writeError(step.object, record.Id, err.Error()); err != nil {
+					return err
+				}
+				continue
+			}
+			sourceIds = append(sourceIds, record.Id)
+			sobjects = append(sobjects, sobject)
+		}
+		for start := 0; start < len(sobjects); start += dmlBatchSize {
+			end := start + dmlBatchSize
+			if end > len(sobjects) {
+				end = len(sobjects)
+			}
+			results, err := m.create(sobjects[start:end])
+			if err != nil {
+				return err
+			}
+			for i, result := range results {
+				sourceId := sourceIds[start+i]
+				if result == nil || !result.Success {
+					stats.failed++
+					if err := m.writeResultError(step.object, sourceId, result); err != nil {
+						return err
+					}
+					continue
+				}
+				stats.created++
+				if err := m.ids.Add(step.object, sourceId, result.Id); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	return stats, err
+}
+
+// updateDeferred sets the deferred references of the migrated records. It is
+// safe to run again on resume.
+func (m *migrator) updateDeferred(step *migrationStep) (*migrationStats, error) {
+	stats := &migrationStats{}
+	err := m.query(step.query(step.deferred), func(records []*soapforce.SObject) error {
+		sourceIds := []string{}
+		sobjects := []*soapforce.SObject{}
+		for _, record := range records {
+			id, ok := m.ids.Get(record.Id)
+			if !ok {
+				continue
+			}
+			sobject := &soapforce.SObject{Type: step.object, Id: id, Fields: map[string]interface{}{}}
+			if err := m.setFields(step, sobject, record, step.deferred); err != nil {
+				stats.failed++
+				if err := m.writeError(step.object, record.Id, err.Error()); err != nil {
+					return err
+				}
+				continue
+			}
+			if len(sobject.Fields) == 0 {
+				continue
+			}
+			sourceIds = append(sourceIds, record.Id)
+			sobjects = append(sobjects, sobject)
+		}
+		for start := 0; start < len(sobjects); start += dmlBatchSize {
+			end := start + dmlBatchSize
+			if end > len(sobjects) {
+				end = len(sobjects)
+			}
+			results, err := m.update(sobjects[start:end])
+			if err != nil {
+				return err
+			}
+			for i, result := range results {
+				if result == nil || !result.Success {
+					stats.failed++
+					if err := m.writeResultError(step.object, sourceIds[start+i], result); err != nil {
+						return err
+					}
+					continue
+				}
+				stats.updated++
+			}
+		}
+		return nil
+	})
+	return stats, err
+}
+
+// setFields copies the fields of the source record, replacing the Ids of the
+// references with the Ids of the migrated parents.
+func (m *migrator) setFields(step *migrationStep, sobject *soapforce.SObject, record *soapforce.SObject, fields []string) error {
+	values := newCaseInsensitiveMap(record.Fields)
+	for _, f := range fields {
+		v := getField(values, f)
+		if v == "" {
+			continue
+		}
+		if _, ok := step.references[f]; ok {
+			id, ok := m.ids.Get(v)
+			if !ok {
+				return fmt.Errorf("%s: the parent %s is not migrated", f, v)
+			}
+			v = id
+		}
+		sobject.Fields[f] = v
+	}
+	return nil
+}
+
+func (m *migrator) writeResultError(object string, sourceId string, result *soapforce.SaveResult) error {
+	return m.writeError(object, sourceId, saveErrorMessage(result))
+}
+
+func (m *migrator) writeError(object string, sourceId string, message string) error {
+	if m.errors == nil {
+		return nil
+	}
+	m.errors.Write([]string{object, sourceId, message})
+	m.errors.Flush()
+	return m.errors.Error()
+}
+
+// openMigrationErrorFile opens the error file, which is continued on resume.
+func openMigrationErrorFile(path string, resume bool) (*os.File, *csv.Writer, error) {
+	var offset int64
+	if info, err := os.Stat(path); err == nil && resume {
+		offset = info.Size()
+	}
+	fp, w, err := createCsvWriter(path, "utf8", offset)
+	if err != nil {
+		return nil, nil, err
+	}
+	if offset == 0 {
+		w.Write([]string{"Type", "SourceId", "Error"})
+		w.Flush()
+	}
+	return fp, w, w.Error()
+}
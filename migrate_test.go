@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tzmfreedom/go-soapforce"
+)
+
+func newMigrationDescribes() map[string]*soapforce.DescribeSObjectResult {
+	return map[string]*soapforce.DescribeSObjectResult{
+		"account": {
+			Name: "Account",
+			Fields: []*soapforce.Field{
+				newDescribeField("Id", "id", soapforce.Field{}),
+				newDescribeField("Name", "string", soapforce.Field{Createable: true}),
+				newDescribeField("ParentId", "reference", soapforce.Field{Createable: true, ReferenceTo: []string{"Account"}}),
+				newDescribeField("OwnerId", "reference", soapforce.Field{Createable: true, ReferenceTo: []string{"User"}}),
+				newDescribeField("CreatedDate", "datetime", soapforce.Field{}),
+			},
+		},
+		"contact": {
+			Name: "Contact",
+			Fields: []*soapforce.Field{
+				newDescribeField("Id", "id", soapforce.Field{}),
+				newDescribeField("LastName", "string", soapforce.Field{Createable: true}),
+				newDescribeField("AccountId", "reference", soapforce.Field{Createable: true, ReferenceTo: []string{"Account"}}),
+			},
+		},
+	}
+}
+
+func TestPlanMigration(t *testing.T) {
+	plan := &migrationPlan{Objects: []*migrationObject{{Type: "Contact"}, {Type: "Account", Where: "Industry = 'Tech'"}}}
+	steps, err := planMigration(plan, newMigrationDescribes(), newMigrationDescribes())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(steps) != 2 || steps[0].object != "Account" || steps[1].object != "Contact" {
+		t.Fatalf("unexpected order: %v", steps)
+	}
+	if !reflect.DeepEqual(steps[0].fields, []string{"Name"}) || !reflect.DeepEqual(steps[0].deferred, []string{"ParentId"}) {
+		t.Fatalf("unexpected fields: %v %v", steps[0].fields, steps[0].deferred)
+	}
+	if !reflect.DeepEqual(steps[1].fields, []string{"LastName", "AccountId"}) || len(steps[1].deferred) != 0 {
+		t.Fatalf("unexpected fields: %v %v", steps[1].fields, steps[1].deferred)
+	}
+	expected := "SELECT Id, Name FROM Account WHERE Industry = 'Tech'"
+	if actual := steps[0].query(steps[0].fields); actual != expected {
+		t.Fatalf("expected: '%s', but '%s'", expected, actual)
+	}
+
+	plan = &migrationPlan{Objects: []*migrationObject{{Type: "Account", Fields: []string{"Name", "OwnerId"}}}}
+	steps, err = planMigration(plan, newMigrationDescribes(), newMigrationDescribes())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(steps[0].fields, []string{"Name", "OwnerId"}) {
+		t.Fatalf("unexpected fields: %v", steps[0].fields)
+	}
+
+	plan = &migrationPlan{Objects: []*migrationObject{{Type: "Account", Fields: []string{"CreatedDate"}}}}
+	_, err = planMigration(plan, newMigrationDescribes(), newMigrationDescribes())
+	expectedError := "Account.CreatedDate is not createable in the target org"
+	if err == nil || err.Error() != expectedError {
+		t.Fatalf("expected: '%s', but '%v'", expectedError, err)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "migrate")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	plan := &migrationPlan{Objects: []*migrationObject{{Type: "Contact"}, {Type: "Account"}}}
+	steps, err := planMigration(plan, newMigrationDescribes(), newMigrationDescribes())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	source := map[string][]*soapforce.SObject{
+		"Account": {
+			{Id: "A1", Fields: map[string]interface{}{"Name": "Parent", "ParentId": nil}},
+			{Id: "A2", Fields: map[string]interface{}{"Name": "Child", "ParentId": "A1"}},
+		},
+		"Contact": {
+			{Id: "C1", Fields: map[string]interface{}{"LastName": "a", "AccountId": "A2"}},
+			{Id: "C2", Fields: map[string]interface{}{"LastName": "b", "AccountId": "A9"}},
+			{Id: "C3", Fields: map[string]interface{}{"LastName": "", "AccountId": "A1"}},
+		},
+	}
+	created := []*soapforce.SObject{}
+	updated := []*soapforce.SObject{}
+	newMigrator := func(resume bool) (*migrator, *bytes.Buffer) {
+		ids, err := openIdMap(filepath.Join(dir, "idmap.csv"), resume)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		out := &bytes.Buffer{}
+		return &migrator{
+			ids: ids,
+			out: out,
+			query: func(soql string, fn func([]*soapforce.SObject) error) error {
+				object := soql[strings.LastIndex(soql, " ")+1:]
+				return fn(source[object])
+			},
+			create: func(sobjects []*soapforce.SObject) ([]*soapforce.SaveResult, error) {
+				results := make([]*soapforce.SaveResult, len(sobjects))
+				for i, sobject := range sobjects {
+					if sobject.Fields["LastName"] == nil && sobject.Type == "Contact" {
+						results[i] = &soapforce.SaveResult{Errors: []*soapforce.Error{newApiError("REQUIRED_FIELD_MISSING", "Required fields are missing: [LastName]")}}
+						continue
+					}
+					created = append(created, sobject)
+					results[i] = &soapforce.SaveResult{Success: true, Id: fmt.Sprintf("a00000000000%03dAAA", len(created))}
+				}
+				return results, nil
+			},
+			update: func(sobjects []*soapforce.SObject) ([]*soapforce.SaveResult, error) {
+				results := make([]*soapforce.SaveResult, len(sobjects))
+				for i, sobject := range sobjects {
+					updated = append(updated, sobject)
+					results[i] = &soapforce.SaveResult{Success: true, Id: sobject.Id}
+				}
+				return results, nil
+			},
+		}, out
+	}
+
+	m, out := newMigrator(false)
+	err = m.Run(steps)
+	m.ids.Close()
+	if err == nil || err.Error() != "2 records failed to migrate" {
+		t.Fatalf("expected: '%s', but '%v'", "2 records failed to migrate", err)
+	}
+	expectedOut := "Account: 2 created, 0 already migrated, 0 failed\nContact: 1 created, 0 already migrated, 2 failed\nAccount: 1 updated with ParentId, 0 failed\n"
+	if out.String() != expectedOut {
+		t.Fatalf("expected: '%s', but '%s'", expectedOut, out.String())
+	}
+	if len(created) != 3 || created[2].Fields["AccountId"] != "a00000000000002AAA" {
+		t.Fatalf("unexpected created records: %v", created)
+	}
+	if len(updated) != 1 || updated[0].Id != "a00000000000002AAA" || updated[0].Fields["ParentId"] != "a00000000000001AAA" {
+		t.Fatalf("unexpected updated records: %v", updated)
+	}
+
+	if _, err := openIdMap(filepath.Join(dir, "idmap.csv"), false); err == nil {
+		t.Fatalf("expected an error for an existing id map")
+	}
+
+	// resume creates only the records which failed
+	source["Contact"][1].Fields["AccountId"] = "A1"
+	m, out = newMigrator(true)
+	defer m.ids.Close()
+	err = m.Run(steps)
+	if err == nil || err.Error() != "1 records failed to migrate" {
+		t.Fatalf("expected: '%s', but '%v'", "1 records failed to migrate", err)
+	}
+	if len(created) != 4 || created[3].Fields["AccountId"] != "a00000000000001AAA" {
+		t.Fatalf("unexpected created records: %v", created)
+	}
+	if !strings.HasPrefix(out.String(), "Account: 0 created, 2 already migrated, 0 failed\nContact: 1 created, 1 already migrated, 1 failed\n") {
+		t.Fatalf("unexpected output: %s", out.String())
+	}
+	if m.ids.Len() != 4 {
+		t.Fatalf("expected: %d, but %d", 4, m.ids.Len())
+	}
+}
+
+func TestIdMap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "idmap")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "idmap.csv")
+
+	// the last line is written partially by a killed migration
+	ioutil.WriteFile(path, []byte("Type,SourceId,TargetId\nAccount,A1,001000000000001AAA\nAccount,A2,001000"), 0666)
+	m, err := openIdMap(path, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if id, ok := m.Get("A2"); ok {
+		t.Fatalf("unexpected id: %s", id)
+	}
+	if err := m.Add("Account", "A2", "001000000000002AAA"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	m.Close()
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := "Type,SourceId,TargetId\nAccount,A1,001000000000001AAA\nAccount,A2,001000000000002AAA\n"
+	if string(b) != expected {
+		t.Fatalf("expected: '%s', but '%s'", expected, string(b))
+	}
+
+	ioutil.WriteFile(path, []byte("Type,SourceId,TargetId\nAccount,A1,\n"), 0666)
+	if _, err := openIdMap(path, true); err == nil || !strings.Contains(err.Error(), "idmap.csv:2: invalid id map entry") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tzmfreedom/go-soapforce"
+)
+
+const (
+	jwtBearerGrantType = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+	// jwtLifetime is the validity of an assertion, which Salesforce limits to 3 minutes.
+	jwtLifetime = 3 * time.Minute
+)
+
+// oauthToken is the response of the OAuth token endpoint.
+type oauthToken struct {
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	InstanceUrl  string `json:"instance_url"`
+	Id           string `json:"id"`
+}
+
+type oauthError struct {
+	Error       string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+// jwtConfig is the connected app and the user of the JWT bearer flow.
+type jwtConfig struct {
+	ClientId   string
+	Username   string
+	PrivateKey *rsa.PrivateKey
+	Audience   string
+}
+
+// oauthBaseUrl returns the URL of the login server of the endpoint, which is
+// https unless the endpoint has a scheme.
+func oauthBaseUrl(endpoint string) string {
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	if strings.Contains(endpoint, "://") {
+		return endpoint
+	}
+	return "https://" + endpoint
+}
+
+func oauthTokenUrl(endpoint string) string {
+	return oauthBaseUrl(endpoint) + "/services/oauth2/token"
+}
+
+func readRsaPrivateKey(path string) (*rsa.PrivateKey, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data is found", path)
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s: not a RSA private key", path)
+	}
+	return rsaKey, nil
+}
+
+// signJwt returns the assertion of the JWT bearer flow signed with RS256.
+func signJwt(config *jwtConfig, now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss": config.ClientId,
+		"sub": config.Username,
+		"aud": config.Audience,
+		"exp": now.Add(jwtLifetime).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, config.PrivateKey, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + enc.EncodeToString(signature), nil
+}
+
+// requestJwtToken exchanges a signed assertion for an access token.
+func requestJwtToken(tokenUrl string, config *jwtConfig) (*oauthToken, error) {
+	assertion, err := signJwt(config, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return requestOAuthToken(tokenUrl, url.Values{
+		"grant_type": {jwtBearerGrantType},
+		"assertion":  {assertion},
+	})
+}
+
+func requestOAuthToken(tokenUrl string, params url.Values) (*oauthToken, error) {
+	res, err := http.PostForm(tokenUrl, params)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode >= 300 {
+		e := &oauthError{}
+		if err := json.Unmarshal(b, e); err == nil && e.Error != "" {
+			return nil, fmt.Errorf("%s: %s", e.Error, e.Description)
+		}
+		return nil, fmt.Errorf("POST %s: %s", tokenUrl, res.Status)
+	}
+	token := &oauthToken{}
+	if err := json.Unmarshal(b, token); err != nil {
+		return nil, err
+	}
+	if token.AccessToken == "" || token.InstanceUrl == "" {
+		return nil, errors.New("access_token and instance_url are not returned by the token endpoint")
+	}
+	return token, nil
+}
+
+// useSession configures the client with an access token instead of logging
+// in, and returns the session in the form of a login result.
+func useSession(client *soapforce.Client, instanceUrl string, sessionId string, apiVersion string) (*soapforce.LoginResult, error) {
+	serverUrl := fmt.Sprintf("%s/services/Soap/u/%s", strings.TrimSuffix(instanceUrl, "/"), apiVersion)
+	client.SetServerUrl(serverUrl)
+	client.SetAccessToken(sessionId)
+	userInfo, err := client.GetUserInfo()
+	if err != nil {
+		return nil, err
+	}
+	client.UserInfo = userInfo
+	res := &soapforce.LoginResult{
+		ServerUrl: serverUrl,
+		SessionId: sessionId,
+		UserInfo:  userInfo,
+	}
+	if userInfo != nil {
+		res.UserId = userInfo.UserId
+	}
+	return res, nil
+}
+
+// authenticateJwt logs in with the JWT bearer flow.
+func authenticateJwt(client *soapforce.Client, endpoint string, config *jwtConfig, apiVersion string) (*soapforce.LoginResult, error) {
+	token, err := requestJwtToken(oauthTokenUrl(endpoint), config)
+	if err != nil {
+		return nil, err
+	}
+	return useSession(client, token.InstanceUrl, token.AccessToken, apiVersion)
+}
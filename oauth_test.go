@@ -0,0 +1,145 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTokenServer is a stand-in of the token endpoint, which verifies the
+// assertion with the public key of the connected app.
+func newTokenServer(t *testing.T, key *rsa.PublicKey) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/services/oauth2/token" || r.FormValue("grant_type") != jwtBearerGrantType {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"unsupported_grant_type","error_description":"grant type not supported"}`))
+			return
+		}
+		parts := strings.Split(r.FormValue("assertion"), ".")
+		if len(parts) != 3 {
+			t.Errorf("unexpected assertion: %s", r.FormValue("assertion"))
+			return
+		}
+		signature, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant","error_description":"invalid assertion"}`))
+			return
+		}
+		b, _ := base64.RawURLEncoding.DecodeString(parts[1])
+		claims := map[string]interface{}{}
+		json.Unmarshal(b, &claims)
+		if claims["sub"] != "user@example.com" || claims["iss"] != "client-id" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error":"invalid_grant","error_description":"user hasn't approved this consumer"}`))
+			return
+		}
+		if exp, ok := claims["exp"].(float64); !ok || time.Unix(int64(exp), 0).Before(time.Now()) {
+			t.Errorf("unexpected exp: %v", claims["exp"])
+		}
+		w.Write([]byte(`{"access_token":"00Dxx!token","instance_url":"https://example.my.salesforce.com","id":"https://login.salesforce.com/id/00Dxx/005xx","token_type":"Bearer"}`))
+	}))
+}
+
+func TestRequestJwtToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	server := newTokenServer(t, &key.PublicKey)
+	defer server.Close()
+
+	config := &jwtConfig{ClientId: "client-id", Username: "user@example.com", PrivateKey: key, Audience: oauthBaseUrl(server.URL)}
+	token, err := requestJwtToken(oauthTokenUrl(server.URL), config)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if token.AccessToken != "00Dxx!token" || token.InstanceUrl != "https://example.my.salesforce.com" {
+		t.Fatalf("unexpected token: %v", token)
+	}
+
+	config.Username = "other@example.com"
+	_, err = requestJwtToken(oauthTokenUrl(server.URL), config)
+	expected := "invalid_grant: user hasn't approved this consumer"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("expected: '%s', but '%v'", expected, err)
+	}
+
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	config = &jwtConfig{ClientId: "client-id", Username: "user@example.com", PrivateKey: other}
+	_, err = requestJwtToken(oauthTokenUrl(server.URL), config)
+	expected = "invalid_grant: invalid assertion"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("expected: '%s', but '%v'", expected, err)
+	}
+}
+
+func TestReadRsaPrivateKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "jwt")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	pkcs8, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	blocks := map[string]*pem.Block{
+		"pkcs1.pem": {Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)},
+		"pkcs8.pem": {Type: "PRIVATE KEY", Bytes: pkcs8},
+	}
+	for name, block := range blocks {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		actual, err := readRsaPrivateKey(path)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if actual.N.Cmp(key.N) != 0 {
+			t.Fatalf("unexpected key of %s", name)
+		}
+	}
+
+	path := filepath.Join(dir, "invalid.pem")
+	ioutil.WriteFile(path, []byte("not a key"), 0600)
+	if _, err := readRsaPrivateKey(path); err == nil || !strings.Contains(err.Error(), "no PEM data") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+func TestOAuthBaseUrl(t *testing.T) {
+	cases := map[string]string{
+		"login.salesforce.com":        "https://login.salesforce.com",
+		"test.salesforce.com/":        "https://test.salesforce.com",
+		"http://127.0.0.1:8080":       "http://127.0.0.1:8080",
+		"https://example.my.site.com": "https://example.my.site.com",
+	}
+	for endpoint, expected := range cases {
+		if actual := oauthBaseUrl(endpoint); actual != expected {
+			t.Fatalf("expected: '%s', but '%s'", expected, actual)
+		}
+	}
+}
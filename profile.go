@@ -0,0 +1,258 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+
+	"github.com/urfave/cli"
+	"gopkg.in/yaml.v2"
+)
+
+// profile is a named set of connection options. Password is the one encrypted
+// with Key when Key is set.
+type profile struct {
+	Username   string `yaml:"username,omitempty"`
+	Password   string `yaml:"password,omitempty"`
+	Auth       string `yaml:"auth,omitempty"`
+	ClientId   string `yaml:"clientId,omitempty"`
+	PrivateKey string `yaml:"privateKey,omitempty"`
+	Endpoint   string `yaml:"endpoint,omitempty"`
+	ApiVersion string `yaml:"apiVersion,omitempty"`
+	Encoding   string `yaml:"encoding,omitempty"`
+	Key        string `yaml:"key,omitempty"`
+	BatchSize  int    `yaml:"batchSize,omitempty"`
+}
+
+// profileFlagNames are the flags set by the fields of a profile, in the order shown.
+var profileFlagNames = []string{
+	"username",
+	"password",
+	"auth",
+	"client-id",
+	"private-key",
+	"endpoint",
+	"api-version",
+	"encoding",
+	"key",
+	"batch-size",
+}
+
+// flagValues returns the values of the profile by the flag names.
+func (p *profile) flagValues() map[string]string {
+	values := map[string]string{
+		"username":    p.Username,
+		"password":    p.Password,
+		"auth":        p.Auth,
+		"client-id":   p.ClientId,
+		"private-key": p.PrivateKey,
+		"endpoint":    p.Endpoint,
+		"api-version": p.ApiVersion,
+		"encoding":    p.Encoding,
+		"key":         p.Key,
+	}
+	if p.BatchSize > 0 {
+		values["batch-size"] = strconv.Itoa(p.BatchSize)
+	}
+	return values
+}
+
+type config struct {
+	Profiles map[string]*profile `yaml:"profiles"`
+}
+
+// configPath returns $YASD_CONFIG, or ~/.yasd/config.yaml.
+func configPath() (string, error) {
+	if path := os.Getenv("YASD_CONFIG"); path != "" {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".yasd", "config.yaml"), nil
+}
+
+func loadConfig(path string) (*config, error) {
+	cfg := &config{Profiles: map[string]*profile{}}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.UnmarshalStrict(b, cfg); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if cfg.Profiles == nil {
+		cfg.Profiles = map[string]*profile{}
+	}
+	return cfg, nil
+}
+
+// Save writes the config readable only by the user, since profiles may have passwords.
+func (cfg *config) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	b, err := yaml.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0600)
+}
+
+func loadProfile(name string) (*profile, error) {
+	path, err := configPath()
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := loadConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	p, ok := cfg.Profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("profile %s is not found in %s", name, path)
+	}
+	return p, nil
+}
+
+// applyProfile sets the flags of the command to the values of --profile,
+// unless they are given by the command line or the environment variables.
+func applyProfile(c *cli.Context) error {
+	name := c.String("profile")
+	if name == "" {
+		return nil
+	}
+	p, err := loadProfile(name)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	return setProfileFlags(c, p)
+}
+
+func setProfileFlags(c *cli.Context, p *profile) error {
+	defined := map[string]bool{}
+	for _, name := range c.FlagNames() {
+		defined[name] = true
+	}
+	values := p.flagValues()
+	for _, name := range profileFlagNames {
+		value := values[name]
+		if value == "" || !defined[name] || c.IsSet(name) {
+			continue
+		}
+		if err := c.Set(name, value); err != nil {
+			return cli.NewExitError(fmt.Sprintf("invalid %s of the profile: %s", name, err), 1)
+		}
+	}
+	return nil
+}
+
+func addProfile(c *cli.Context) error {
+	name := c.Args().First()
+	if name == "" {
+		_ = cli.ShowCommandHelp(c, "add")
+		return cli.NewExitError("profile name is required", 1)
+	}
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	cfg, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+	if _, ok := cfg.Profiles[name]; ok {
+		return cli.NewExitError(fmt.Sprintf("profile %s exists, remove it first", name), 1)
+	}
+	cfg.Profiles[name] = &profile{
+		Username:   c.String("username"),
+		Password:   c.String("password"),
+		Auth:       c.String("auth"),
+		ClientId:   c.String("client-id"),
+		PrivateKey: c.String("private-key"),
+		Endpoint:   c.String("endpoint"),
+		ApiVersion: c.String("api-version"),
+		Encoding:   c.String("encoding"),
+		Key:        c.String("key"),
+		BatchSize:  c.Int("batch-size"),
+	}
+	return cfg.Save(path)
+}
+
+func listProfiles(c *cli.Context) error {
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	cfg, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+	names := []string{}
+	for name := range cfg.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Println(name)
+	}
+	return nil
+}
+
+func removeProfile(c *cli.Context) error {
+	name := c.Args().First()
+	if name == "" {
+		_ = cli.ShowCommandHelp(c, "remove")
+		return cli.NewExitError("profile name is required", 1)
+	}
+	path, err := configPath()
+	if err != nil {
+		return err
+	}
+	cfg, err := loadConfig(path)
+	if err != nil {
+		return err
+	}
+	if _, ok := cfg.Profiles[name]; !ok {
+		return cli.NewExitError(fmt.Sprintf("profile %s is not found in %s", name, path), 1)
+	}
+	// the builtin delete is shadowed by the delete command
+	profiles := map[string]*profile{}
+	for n, p := range cfg.Profiles {
+		if n != name {
+			profiles[n] = p
+		}
+	}
+	cfg.Profiles = profiles
+	return cfg.Save(path)
+}
+
+// showProfile prints the options of the profile, with the password masked.
+func showProfile(c *cli.Context) error {
+	name := c.Args().First()
+	if name == "" {
+		_ = cli.ShowCommandHelp(c, "show")
+		return cli.NewExitError("profile name is required", 1)
+	}
+	p, err := loadProfile(name)
+	if err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	values := p.flagValues()
+	if values["password"] != "" {
+		values["password"] = "********"
+	}
+	for _, flag := range profileFlagNames {
+		if values[flag] != "" {
+			fmt.Printf("%s: %s\n", flag, values[flag])
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/urfave/cli"
+)
+
+func TestConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "yasd", "config.yaml")
+
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	cfg.Profiles["uat"] = &profile{Username: "user@example.com.uat", Endpoint: "test.salesforce.com", BatchSize: 200}
+	if err := cfg.Save(path); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	cfg, err = loadConfig(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := &profile{Username: "user@example.com.uat", Endpoint: "test.salesforce.com", BatchSize: 200}
+	if !reflect.DeepEqual(cfg.Profiles["uat"], expected) {
+		t.Fatalf("expected: '%v', but '%v'", expected, cfg.Profiles["uat"])
+	}
+
+	ioutil.WriteFile(path, []byte("profiles:\n  uat:\n    endpont: test.salesforce.com\n"), 0600)
+	if _, err := loadConfig(path); err == nil {
+		t.Fatalf("unknown field is not rejected")
+	}
+}
+
+func TestSetProfileFlags(t *testing.T) {
+	os.Setenv("SALESFORCE_APIVERSION", "45.0")
+	defer os.Unsetenv("SALESFORCE_APIVERSION")
+
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	for _, f := range exportFlags {
+		f.Apply(set)
+	}
+	if err := set.Parse([]string{"--username", "other@example.com"}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	c := cli.NewContext(cli.NewApp(), set, nil)
+	c.Command = cli.Command{Name: "export", Flags: exportFlags}
+
+	p := &profile{
+		Username:   "user@example.com.uat",
+		Endpoint:   "test.salesforce.com",
+		ApiVersion: "44.0",
+		Encoding:   "sjis",
+		Key:        "/path/to/key",
+		BatchSize:  200,
+	}
+	if err := setProfileFlags(c, p); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := map[string]string{
+		"username":    "other@example.com",
+		"endpoint":    "test.salesforce.com",
+		"api-version": "45.0",
+		"encoding":    "sjis",
+		"key":         "/path/to/key",
+		"auth":        "password",
+	}
+	for name, value := range expected {
+		if actual := c.String(name); actual != value {
+			t.Fatalf("expected: '%s', but '%s'", value, actual)
+		}
+	}
+	if c.Int("batch-size") != 200 {
+		t.Fatalf("unexpected batch-size: %d", c.Int("batch-size"))
+	}
+}
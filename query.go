@@ -2,7 +2,6 @@ package main
 
 import (
 	"fmt"
-	"regexp"
 	"strings"
 
 	"github.com/tzmfreedom/go-soapforce"
@@ -14,7 +13,14 @@ func query(c *cli.Context) error {
 		return err
 	}
 	client := newClient(c)
-	if err := login(client, c); err != nil {
+	var bulk *bulk2Client
+	var err error
+	if c.String("api") == "bulk2" {
+		bulk, err = newBulk2ClientFromLogin(client, c)
+	} else {
+		err = login(client, c)
+	}
+	if err != nil {
 		return err
 	}
 
@@ -22,7 +28,19 @@ func query(c *cli.Context) error {
 	if err != nil {
 		return err
 	}
-	res, err := client.Query(q)
+	soql, err := parseSoql(q)
+	if err != nil {
+		return err
+	}
+	if bulk != nil {
+		return bulk2Query(c, bulk, q, soql)
+	}
+	retry := newRetrier(c, client)
+	var res *soapforce.QueryResult
+	err = retry.Do(func() error {
+		res, err = client.Query(q)
+		return err
+	})
 	if err != nil {
 		return err
 	}
@@ -30,33 +48,75 @@ func query(c *cli.Context) error {
 	if err != nil {
 		return err
 	}
+	writer, err = getSubqueryWriter(c, writer, soql, func(object string) (*soapforce.DescribeSObjectResult, error) {
+		return describeSObject(client, object)
+	})
+	if err != nil {
+		return err
+	}
 	defer writer.Close()
 
-	fields := getFields(q)
+	fields := soql.Columns()
 	writer.Header(fields)
 
-	for _, record := range res.Records {
-		writer.Write(fields, record)
-	}
-	for res.QueryLocator != "" {
-		res, err := client.QueryMore(res.QueryLocator)
+	for {
+		for _, record := range res.Records {
+			if err := fetchChildRecords(client, retry, record); err != nil {
+				return err
+			}
+			writer.Write(fields, record)
+		}
+		if res.QueryLocator == "" {
+			break
+		}
+		locator := res.QueryLocator
+		err = retry.Do(func() error {
+			res, err = client.QueryMore(locator)
+			return err
+		})
 		if err != nil {
 			return err
 		}
-		for _, record := range res.Records {
-			writer.Write(fields, record)
+	}
+	return nil
+}
+
+// fetchChildRecords pages in the rest of the parent-to-child subquery results
+// which did not fit in the parent record.
+func fetchChildRecords(client *soapforce.Client, retry *retrier, record *soapforce.SObject) error {
+	for _, v := range record.Fields {
+		qr, ok := v.(*soapforce.QueryResult)
+		if !ok || qr == nil {
+			continue
+		}
+		for !qr.Done && qr.QueryLocator != "" {
+			var res *soapforce.QueryResult
+			err := retry.Do(func() error {
+				var err error
+				res, err = client.QueryMore(qr.QueryLocator)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+			qr.Records = append(qr.Records, res.Records...)
+			qr.Done = res.Done
+			qr.QueryLocator = res.QueryLocator
 		}
 	}
 	return nil
 }
 
+// buildQuery expands "SELECT * FROM" into every field of the object.
 func buildQuery(c *soapforce.Client, original string) (string, error) {
-	r := regexp.MustCompile(`(?i)SELECT\s+(\*)\s+FROM\s+([a-zA-Z\d_]+)`)
-	matches := r.FindStringSubmatch(strings.TrimSpace(original))
-	if len(matches) == 0 {
+	soql, err := parseSoql(original)
+	if err != nil {
+		return "", err
+	}
+	if !soql.Star {
 		return original, nil
 	}
-	result, err := c.DescribeSObject(matches[2])
+	result, err := c.DescribeSObject(soql.From)
 	if err != nil {
 		return "", err
 	}
@@ -65,18 +125,7 @@ func buildQuery(c *soapforce.Client, original string) (string, error) {
 		fields[i] = f.Name
 	}
 	selectClause := strings.Join(fields, ",")
-	return r.ReplaceAllString(original, fmt.Sprintf("SELECT %s FROM $2", selectClause)), nil
-}
-
-func getFields(q string) []string {
-	r := regexp.MustCompile(`(?i)SELECT\s+([a-zA-Z\.\d_,\s]+)\sFROM\s`)
-	matches := r.FindStringSubmatch(q)
-	untrimedFields := strings.Split(matches[1], ",")
-	fields := make([]string, len(untrimedFields))
-	for i, f := range untrimedFields {
-		fields[i] = strings.TrimSpace(f)
-	}
-	return fields
+	return original[:soql.selectStart] + selectClause + " " + original[soql.selectEnd:], nil
 }
 
 func validateExportCommand(c *cli.Context) error {
@@ -88,13 +137,12 @@ func validateExportCommand(c *cli.Context) error {
 		_ = cli.ShowCommandHelp(c, "export")
 		return cli.NewExitError("query is required", 1)
 	}
-	r := regexp.MustCompile(`(?i)SELECT\s+([a-zA-Z\.\d_,\s]+)\sFROM\s`)
-	if !r.MatchString(q) {
-		r := regexp.MustCompile(`(?i)SELECT\s+(\*)\s+FROM\s+([a-zA-Z\d_]+)`)
-		if !r.MatchString(q) {
-			_ = cli.ShowCommandHelp(c, "export")
-			return cli.NewExitError("Malformed Query", 1)
-		}
+	if err := validateApiFlag(c, "export"); err != nil {
+		return err
+	}
+	if _, err := parseSoql(q); err != nil {
+		_ = cli.ShowCommandHelp(c, "export")
+		return cli.NewExitError(fmt.Sprintf("Malformed Query: %s", err), 1)
 	}
 	return nil
 }
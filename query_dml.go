@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/tzmfreedom/go-soapforce"
+	"github.com/urfave/cli"
+)
+
+// queryReader reads the records of a query as the input rows of a DML
+// command: the Id of the record followed by the values of --set.
+type queryReader struct {
+	res     *soapforce.QueryResult
+	more    func(locator string) (*soapforce.QueryResult, error)
+	headers []string
+	values  []string
+	next    int
+	started bool
+}
+
+func (r *queryReader) Read() ([]string, error) {
+	if !r.started {
+		r.started = true
+		return r.headers, nil
+	}
+	for r.next >= len(r.res.Records) {
+		if r.res.Done || r.res.QueryLocator == "" {
+			return nil, io.EOF
+		}
+		res, err := r.more(r.res.QueryLocator)
+		if err != nil {
+			return nil, err
+		}
+		r.res = res
+		r.next = 0
+	}
+	record := r.res.Records[r.next]
+	r.next++
+	return append([]string{record.Id}, r.values...), nil
+}
+
+func (r *queryReader) Close() error {
+	return nil
+}
+
+// openQueryDmlInput queries the records of --query and asks for the
+// confirmation with the number of the records, unless --yes is set.
+func openQueryDmlInput(c *cli.Context, command string, client *soapforce.Client, retry *retrier) (*dmlInput, error) {
+	q := c.String("query")
+	headers, values, err := parseSetFlags(c.StringSlice("set"))
+	if err != nil {
+		return nil, err
+	}
+	headers = append([]string{"Id"}, headers...)
+	query := client.Query
+	if command == "empty-recycle-bin" {
+		// deleted records are returned only by queryAll
+		query = client.QueryAll
+	}
+	var res *soapforce.QueryResult
+	err = retry.Do(func() error {
+		var err error
+		res, err = query(q)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !c.Bool("yes") {
+		ok, err := confirmQueryDml(os.Stdin, os.Stdout, queryDmlAction(c, command), c.String("type"), int(res.Size))
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, cli.NewExitError("canceled", 1)
+		}
+	}
+	handler, err := getResponseHandler(c, headers, nil)
+	if err != nil {
+		return nil, err
+	}
+	m, err := newRecordMapping(headers, "")
+	if err != nil {
+		return nil, err
+	}
+	reader := &queryReader{
+		res:     res,
+		headers: headers,
+		values:  values,
+		more: func(locator string) (*soapforce.QueryResult, error) {
+			var res *soapforce.QueryResult
+			err := retry.Do(func() error {
+				var err error
+				res, err = client.QueryMore(locator)
+				return err
+			})
+			return res, err
+		},
+	}
+	return &dmlInput{
+		reader:  reader,
+		headers: m.headers,
+		mapping: m,
+		handler: handler,
+	}, nil
+}
+
+// queryDmlAction describes the change of the command for the confirmation.
+func queryDmlAction(c *cli.Context, command string) string {
+	switch {
+	case command == "delete" && c.Bool("hard"):
+		return "deleted permanently"
+	case command == "empty-recycle-bin":
+		return "removed from the recycle bin"
+	}
+	return command + "d"
+}
+
+// confirmQueryDml shows the number of the records to change and reads the answer.
+func confirmQueryDml(in io.Reader, out io.Writer, action string, object string, count int) (bool, error) {
+	fmt.Fprintf(out, "%d %s records will be %s.\n", count, object, action)
+	if count == 0 {
+		return true, nil
+	}
+	fmt.Fprint(out, "Continue? [y/N]: ")
+	answer, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	switch strings.ToLower(strings.TrimSpace(answer)) {
+	case "y", "yes":
+		return true, nil
+	}
+	return false, nil
+}
+
+// parseSetFlags splits the Field=value pairs of --set.
+func parseSetFlags(values []string) ([]string, []string, error) {
+	fields := []string{}
+	setValues := []string{}
+	for _, v := range values {
+		i := strings.Index(v, "=")
+		if i < 0 {
+			return nil, nil, fmt.Errorf("set should be Field=value: %s", v)
+		}
+		field := strings.TrimSpace(v[:i])
+		if field == "" {
+			return nil, nil, fmt.Errorf("set should be Field=value: %s", v)
+		}
+		if strings.EqualFold(field, "Id") {
+			return nil, nil, fmt.Errorf("Id cannot be set")
+		}
+		for _, f := range fields {
+			if strings.EqualFold(f, field) {
+				return nil, nil, fmt.Errorf("%s is set more than once", field)
+			}
+		}
+		fields = append(fields, field)
+		setValues = append(setValues, v[i+1:])
+	}
+	return fields, setValues, nil
+}
+
+// validateQueryDmlFlags validates --query of delete, update and empty-recycle-bin, and sets
+// --type to the object of the query.
+func validateQueryDmlFlags(c *cli.Context, command string) error {
+	if err := validateLoginFlag(c, command); err != nil {
+		return err
+	}
+	if c.String("file") != "" {
+		_ = cli.ShowCommandHelp(c, command)
+		return cli.NewExitError("file and query cannot be used together", 1)
+	}
+	for _, flag := range []string{"mapping", "checkpoint", "resume", "dry-run", "start-row"} {
+		if c.IsSet(flag) {
+			_ = cli.ShowCommandHelp(c, command)
+			return cli.NewExitError(fmt.Sprintf("%s cannot be used with query", flag), 1)
+		}
+	}
+	if c.String("api") == "bulk2" {
+		_ = cli.ShowCommandHelp(c, command)
+		return cli.NewExitError("query is not supported by Bulk API 2.0", 1)
+	}
+	if err := validateDmlFlags(c, command); err != nil {
+		return err
+	}
+	soql, err := parseSoql(c.String("query"))
+	if err != nil {
+		_ = cli.ShowCommandHelp(c, command)
+		return cli.NewExitError(fmt.Sprintf("Malformed Query: %s", err), 1)
+	}
+	if err := validateDmlQuery(soql); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	if t := c.String("type"); t != "" && !strings.EqualFold(t, soql.From) {
+		return cli.NewExitError(fmt.Sprintf("type %s does not match the query of %s", t, soql.From), 1)
+	}
+	if command == "empty-recycle-bin" && !filtersDeleted(soql, soql.Where) {
+		return cli.NewExitError("query must filter the records by IsDeleted = true", 1)
+	}
+	if err := c.Set("type", soql.From); err != nil {
+		return err
+	}
+	sets := c.StringSlice("set")
+	if command == "update" && len(sets) == 0 {
+		_ = cli.ShowCommandHelp(c, command)
+		return cli.NewExitError("set is required", 1)
+	}
+	if _, _, err := parseSetFlags(sets); err != nil {
+		return cli.NewExitError(err.Error(), 1)
+	}
+	return nil
+}
+
+// filtersDeleted reports whether the condition returns only deleted records,
+// which are queried with queryAll together with the records which are not.
+func filtersDeleted(soql *soqlQuery, cond soqlCondition) bool {
+	switch c := cond.(type) {
+	case *soqlLogicalCondition:
+		if c.Op == "AND" {
+			return filtersDeleted(soql, c.Left) || filtersDeleted(soql, c.Right)
+		}
+		return filtersDeleted(soql, c.Left) && filtersDeleted(soql, c.Right)
+	case *soqlComparison:
+		return c.Op == "=" && c.Value != nil && c.Value.Type == soqlBooleanValue &&
+			strings.EqualFold(c.Value.Text, "true") && c.Expr.Func == "" &&
+			strings.EqualFold(soql.trimAlias(c.Expr.Field), "IsDeleted")
+	}
+	return false
+}
+
+// validateDmlQuery checks that the query returns the Ids of the records.
+func validateDmlQuery(soql *soqlQuery) error {
+	err := soql.validateRecords()
+	if err == errSoqlAggregate {
+		return fmt.Errorf("aggregate queries cannot be used to change records")
+	}
+	return err
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tzmfreedom/go-soapforce"
+	"github.com/urfave/cli"
+)
+
+func TestQueryReader(t *testing.T) {
+	pages := map[string]*soapforce.QueryResult{
+		"locator-2": {Done: true, Records: []*soapforce.SObject{{Id: "00Q3"}}},
+	}
+	r := &queryReader{
+		res: &soapforce.QueryResult{
+			QueryLocator: "locator-2",
+			Records:      []*soapforce.SObject{{Id: "00Q1"}, {Id: "00Q2"}},
+		},
+		more: func(locator string) (*soapforce.QueryResult, error) {
+			return pages[locator], nil
+		},
+		headers: []string{"Id", "Status"},
+		values:  []string{"Closed"},
+	}
+	actual := [][]string{}
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		actual = append(actual, record)
+	}
+	expected := [][]string{{"Id", "Status"}, {"00Q1", "Closed"}, {"00Q2", "Closed"}, {"00Q3", "Closed"}}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Fatalf("expected: '%v', but '%v'", expected, actual)
+	}
+}
+
+func TestParseSetFlags(t *testing.T) {
+	fields, values, err := parseSetFlags([]string{"Status=Closed", "Description=a=b", "Rating="})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(fields, []string{"Status", "Description", "Rating"}) || !reflect.DeepEqual(values, []string{"Closed", "a=b", ""}) {
+		t.Fatalf("unexpected values: %v %v", fields, values)
+	}
+
+	cases := map[string][]string{
+		"set should be Field=value: Status":  {"Status"},
+		"set should be Field=value: =Closed": {"=Closed"},
+		"Id cannot be set":                   {"Id=001"},
+		"status is set more than once":       {"Status=Open", "status=Closed"},
+	}
+	for expected, values := range cases {
+		_, _, err := parseSetFlags(values)
+		if err == nil || err.Error() != expected {
+			t.Fatalf("expected: '%s', but '%v'", expected, err)
+		}
+	}
+}
+
+func TestConfirmQueryDml(t *testing.T) {
+	cases := []struct {
+		answer   string
+		count    int
+		expected bool
+	}{
+		{"y\n", 3, true},
+		{"Yes\n", 3, true},
+		{"n\n", 3, false},
+		{"\n", 3, false},
+		{"", 3, false},
+		{"", 0, true},
+	}
+	for _, c := range cases {
+		out := &bytes.Buffer{}
+		ok, err := confirmQueryDml(strings.NewReader(c.answer), out, "deleted", "Lead", c.count)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if ok != c.expected {
+			t.Fatalf("expected: '%v', but '%v' for '%s'", c.expected, ok, c.answer)
+		}
+	}
+	out := &bytes.Buffer{}
+	confirmQueryDml(strings.NewReader("y\n"), out, "updated", "Lead", 3)
+	expected := "3 Lead records will be updated.\nContinue? [y/N]: "
+	if out.String() != expected {
+		t.Fatalf("expected: '%s', but '%s'", expected, out.String())
+	}
+}
+
+func newQueryDmlContext(t *testing.T, args ...string) *cli.Context {
+	set := flag.NewFlagSet("test", flag.ContinueOnError)
+	for _, f := range updateFlags {
+		f.Apply(set)
+	}
+	args = append([]string{"--username", "user", "--password", "pass"}, args...)
+	if err := set.Parse(args); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return cli.NewContext(cli.NewApp(), set, nil)
+}
+
+func TestValidateQueryDmlFlags(t *testing.T) {
+	c := newQueryDmlContext(t, "--query", "SELECT Id FROM Lead WHERE Status = 'Open'", "--set", "Status=Closed")
+	if err := validateQueryDmlFlags(c, "update"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if c.String("type") != "Lead" {
+		t.Fatalf("expected: '%s', but '%s'", "Lead", c.String("type"))
+	}
+
+	cases := map[string][]string{
+		"file and query cannot be used together":        {"--query", "SELECT Id FROM Lead", "--set", "Status=Closed", "--file", "lead.csv"},
+		"resume cannot be used with query":              {"--query", "SELECT Id FROM Lead", "--set", "Status=Closed", "--resume"},
+		"query is not supported by Bulk API 2.0":        {"--query", "SELECT Id FROM Lead", "--set", "Status=Closed", "--api", "bulk2"},
+		"Id is required in the select list":             {"--query", "SELECT Name FROM Lead", "--set", "Status=Closed"},
+		"type Account does not match the query of Lead": {"--query", "SELECT Id FROM Lead", "--set", "Status=Closed", "--type", "Account"},
+		"set is required":                               {"--query", "SELECT Id FROM Lead"},
+	}
+	for expected, args := range cases {
+		err := validateQueryDmlFlags(newQueryDmlContext(t, args...), "update")
+		if err == nil || err.Error() != expected {
+			t.Fatalf("expected: '%s', but '%v'", expected, err)
+		}
+	}
+
+	for query, expected := range map[string]bool{
+		"SELECT Id FROM Lead WHERE IsDeleted = true":                         true,
+		"SELECT Id FROM Lead l WHERE Status = 'Open' AND l.IsDeleted = TRUE": true,
+		"SELECT Id FROM Lead WHERE IsDeleted = true OR Status = 'Open'":      false,
+		"SELECT Id FROM Lead WHERE IsDeleted = false":                        false,
+		"SELECT Id FROM Lead": false,
+	} {
+		err := validateQueryDmlFlags(newQueryDmlContext(t, "--query", query), "empty-recycle-bin")
+		if expected && err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !expected && (err == nil || err.Error() != "query must filter the records by IsDeleted = true") {
+			t.Fatalf("expected: '%s', but '%v'", "query must filter the records by IsDeleted = true", err)
+		}
+	}
+}
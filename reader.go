@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"encoding/csv"
 	"errors"
 	"os"
@@ -17,6 +18,7 @@ import (
 	"io/ioutil"
 
 	"fmt"
+	"sort"
 	"strconv"
 
 	"github.com/tealeg/xlsx"
@@ -48,8 +50,11 @@ type CsvReader struct {
 }
 
 func (r *CsvReader) Read() ([]string, error) {
-	if r.startRow > r.counter {
+	if r.counter > 0 && r.startRow > r.counter {
 		r.counter++
+		if _, err := r.cr.Read(); err != nil {
+			return nil, err
+		}
 		return nil, nil
 	}
 	r.counter++
@@ -94,7 +99,7 @@ func (r *ExcelReader) Read() ([]string, error) {
 	if r.maxRow <= r.counter {
 		return nil, io.EOF
 	}
-	if r.startRow > r.counter {
+	if r.counter > 0 && r.startRow > r.counter {
 		r.counter++
 		return nil, nil
 	}
@@ -134,10 +139,17 @@ type FixWidthFileReader struct {
 	s           *bufio.Scanner
 	e           string
 	byteNumbers []int
+	counter     int
+	startRow    int
 }
 
 func (r *FixWidthFileReader) Read() ([]string, error) {
 	if r.s.Scan() {
+		if r.counter > 0 && r.startRow > r.counter {
+			r.counter++
+			return nil, nil
+		}
+		r.counter++
 		var s Stringer
 		switch strings.ToUpper(r.e) {
 		case "SHIFT-JIS", "SJIS", "SHIFT_JIS":
@@ -161,76 +173,121 @@ func (r *FixWidthFileReader) Read() ([]string, error) {
 		}
 		return values, nil
 	}
-	return nil, nil
+	if err := r.s.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
 }
 
 func (r *FixWidthFileReader) Close() error {
 	return r.f.Close()
 }
 
-func newFixWidthFileReader(f string, e string, byteNumbers []int) (*FixWidthFileReader, error) {
+func newFixWidthFileReader(f string, e string, byteNumbers []int, start int) (*FixWidthFileReader, error) {
 	fp, err := os.Open(f)
 	if err != nil {
 		return nil, err
 	}
 	s := bufio.NewScanner(fp)
-	return &FixWidthFileReader{f: fp, s: s, e: e, byteNumbers: byteNumbers}, nil
+	return &FixWidthFileReader{f: fp, s: s, e: e, byteNumbers: byteNumbers, startRow: start}, nil
 }
 
 type JsonReader struct {
-	records  []map[string]interface{}
-	f        *os.File
+	headers  []string
+	records  []map[string]string
 	counter  int
 	startRow int
 }
 
 func (r *JsonReader) Read() ([]string, error) {
-	if len(r.records) <= r.counter {
+	if r.counter == 0 {
+		r.counter++
+		return r.headers, nil
+	}
+	if len(r.records) < r.counter {
 		return nil, io.EOF
 	}
 	if r.startRow > r.counter {
 		r.counter++
 		return nil, nil
 	}
-	record := r.records[r.counter]
-	values := make([]string, len(record))
+	record := r.records[r.counter-1]
 	r.counter++
-	return values, nil
+	return getRecordValues(r.headers, record), nil
 }
 
-func (r *JsonReader) Close() error {
-	return r.f.Close()
-}
+func (r *JsonReader) Close() error { return nil }
 
 func newJsonReader(filename string, startRow int) (*JsonReader, error) {
-	records := []map[string]interface{}{}
-	b, err := ioutil.ReadFile(filename)
+	f, err := os.Open(filename)
 	if err != nil {
 		return nil, err
 	}
-	json.Unmarshal(b, records)
-	return &JsonReader{records: records, startRow: startRow}, nil
+	defer f.Close()
+	d := json.NewDecoder(f)
+	d.UseNumber()
+	rawRecords := []map[string]interface{}{}
+	if err := d.Decode(&rawRecords); err != nil {
+		return nil, err
+	}
+	records := make([]map[string]string, len(rawRecords))
+	for i, rawRecord := range rawRecords {
+		records[i] = flattenRecord(rawRecord)
+	}
+	return &JsonReader{headers: getRecordHeaders(records), records: records, startRow: startRow}, nil
 }
 
+// JsonlReader streams line-delimited JSON, decoding one line per Read().
+// The header is built by a preliminary pass over the file, so that records
+// are never held in memory.
 type JsonlReader struct {
-	records  []map[string]interface{}
+	headers  []string
 	f        *os.File
+	br       *bufio.Reader
+	line     int
 	counter  int
 	startRow int
 }
 
 func (r *JsonlReader) Read() ([]string, error) {
-	if len(r.records) <= r.counter {
-		return nil, io.EOF
+	if r.counter == 0 {
+		r.counter++
+		return r.headers, nil
+	}
+	b, err := r.readLine()
+	if err != nil {
+		return nil, err
 	}
 	if r.startRow > r.counter {
 		r.counter++
 		return nil, nil
 	}
-	record := r.records[r.counter]
-	values := make([]string, len(record))
+	record, err := decodeJsonlLine(b, r.line)
+	if err != nil {
+		return nil, err
+	}
 	r.counter++
-	return values, nil
+	return getRecordValues(r.headers, record), nil
+}
+
+// readLine returns the next non-blank line, or io.EOF when the stream is exhausted.
+func (r *JsonlReader) readLine() ([]byte, error) {
+	for {
+		b, err := r.br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if len(b) == 0 && err == io.EOF {
+			return nil, io.EOF
+		}
+		r.line++
+		if len(bytes.TrimSpace(b)) != 0 {
+			return b, nil
+		}
+		if err == io.EOF {
+			return nil, io.EOF
+		}
+	}
 }
 
 func (r *JsonlReader) Close() error {
@@ -238,54 +295,173 @@ func (r *JsonlReader) Close() error {
 }
 
 func newJsonlReader(filename string, startRow int) (*JsonlReader, error) {
-	records := []map[string]interface{}{}
-	b, err := ioutil.ReadFile(filename)
+	f, err := os.Open(filename)
 	if err != nil {
 		return nil, err
 	}
-	json.Unmarshal(b, records)
-	return &JsonlReader{records: records, startRow: startRow}, nil
+	r := &JsonlReader{f: f, br: bufio.NewReader(f), startRow: startRow}
+	keys := map[string]struct{}{}
+	for {
+		b, err := r.readLine()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		record, err := decodeJsonlLine(b, r.line)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		for k := range record {
+			keys[k] = struct{}{}
+		}
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	r.headers = sortedKeys(keys)
+	r.br.Reset(f)
+	r.line = 0
+	return r, nil
+}
+
+func decodeJsonlLine(b []byte, line int) (map[string]string, error) {
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	rawRecord := map[string]interface{}{}
+	if err := d.Decode(&rawRecord); err != nil {
+		return nil, fmt.Errorf("jsonl is not valid at line %d: %s", line, err)
+	}
+	return flattenRecord(rawRecord), nil
 }
 
 type YamlReader struct {
-	records  []map[string]interface{}
-	f        *os.File
+	headers  []string
+	records  []map[string]string
 	counter  int
 	startRow int
 }
 
 func (r *YamlReader) Read() ([]string, error) {
-	if len(r.records) <= r.counter {
+	if r.counter == 0 {
+		r.counter++
+		return r.headers, nil
+	}
+	if len(r.records) < r.counter {
 		return nil, io.EOF
 	}
 	if r.startRow > r.counter {
 		r.counter++
 		return nil, nil
 	}
-	record := r.records[r.counter]
-	values := make([]string, len(record))
+	record := r.records[r.counter-1]
 	r.counter++
-	return values, nil
+	return getRecordValues(r.headers, record), nil
 }
 
-func (r *YamlReader) Close() error {
-	return r.f.Close()
-}
+func (r *YamlReader) Close() error { return nil }
 
 func newYamlReader(filename string, startRow int) (*YamlReader, error) {
-	records := []map[string]interface{}{}
 	b, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return nil, err
 	}
-	yaml.Unmarshal(b, records)
-	return &YamlReader{records: records, startRow: startRow}, nil
+	rawRecords := []map[string]interface{}{}
+	if err := yaml.Unmarshal(b, &rawRecords); err != nil {
+		return nil, err
+	}
+	records := make([]map[string]string, len(rawRecords))
+	for i, rawRecord := range rawRecords {
+		records[i] = flattenRecord(rawRecord)
+	}
+	return &YamlReader{headers: getRecordHeaders(records), records: records, startRow: startRow}, nil
+}
+
+// flattenRecord converts a decoded JSON/YAML object into a flat map of string values.
+// Nested objects are expanded to dotted keys (e.g. Owner.Email) so that they can be
+// handled as relationship fields by createSObject.
+func flattenRecord(record map[string]interface{}) map[string]string {
+	flatten := map[string]string{}
+	for k, v := range record {
+		flattenValue(flatten, k, v)
+	}
+	return flatten
+}
+
+func flattenValue(flatten map[string]string, key string, value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for k, child := range v {
+			flattenValue(flatten, key+"."+k, child)
+		}
+	case map[interface{}]interface{}:
+		for k, child := range v {
+			flattenValue(flatten, key+"."+fmt.Sprint(k), child)
+		}
+	default:
+		flatten[key] = stringifyValue(v)
+	}
+}
+
+func stringifyValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case bool:
+		return strconv.FormatBool(v)
+	case json.Number:
+		return v.String()
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case []interface{}:
+		values := make([]string, len(v))
+		for i, e := range v {
+			values[i] = stringifyValue(e)
+		}
+		return strings.Join(values, ";")
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// getRecordHeaders returns the sorted union of keys of all records.
+func getRecordHeaders(records []map[string]string) []string {
+	keys := map[string]struct{}{}
+	for _, record := range records {
+		for k := range record {
+			keys[k] = struct{}{}
+		}
+	}
+	return sortedKeys(keys)
+}
+
+func sortedKeys(keys map[string]struct{}) []string {
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+func getRecordValues(headers []string, record map[string]string) []string {
+	values := make([]string, len(headers))
+	for i, h := range headers {
+		values[i] = record[h]
+	}
+	return values
 }
 
-func getReader(c *cli.Context) (Reader, error) {
+// getReader opens the input file. Data rows before start are returned as nil.
+func getReader(c *cli.Context, start int) (Reader, error) {
 	f := c.String("file")
 	encoding := c.String("encoding")
-	start := c.Int("start-row")
 	ext := filepath.Ext(f)
 
 	var r Reader
@@ -315,7 +491,7 @@ func getReader(c *cli.Context) (Reader, error) {
 				return nil, err
 			}
 		}
-		r, err = newFixWidthFileReader(f, encoding, bi)
+		r, err = newFixWidthFileReader(f, encoding, bi, start)
 	}
 	return r, err
 }
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"io"
+	"strings"
 	"testing"
 )
 
@@ -29,6 +31,25 @@ func TestReadFromCsv(t *testing.T) {
 	reader.Close()
 }
 
+func TestReadFromCsvWithStartRow(t *testing.T) {
+	reader, err := newCsvReader("test/success.csv", "utf8", "", 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer reader.Close()
+	assertArrayEqual(t, reader, []string{"あ", "i", ""})
+	values, err := reader.Read()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if values != nil {
+		t.Fatalf("expected nil, but %v", values)
+	}
+	if _, err := reader.Read(); err != io.EOF {
+		t.Fatalf("expected io.EOF, but %v", err)
+	}
+}
+
 func assertArrayEqual(t *testing.T, reader Reader, expected []string) {
 	values, err := reader.Read()
 	if err != nil {
@@ -68,7 +89,7 @@ func TestReadFromExcel(t *testing.T) {
 func TestReadFromFixWidth(t *testing.T) {
 	filename := "test/success.dat"
 	encoding := "utf8"
-	reader, err := newFixWidthFileReader(filename, encoding, []int{6, 3, 1})
+	reader, err := newFixWidthFileReader(filename, encoding, []int{6, 3, 1}, 0)
 	if err != nil {
 		t.Fatalf("unexpected error: %s", err)
 	}
@@ -87,3 +108,105 @@ func TestReadFromFixWidth(t *testing.T) {
 	assertArrayEqual(t, reader, expected)
 	reader.Close()
 }
+
+func TestReadFromJson(t *testing.T) {
+	reader, err := newJsonReader("test/success.json", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	assertRecordReader(t, reader)
+	reader.Close()
+}
+
+func TestReadFromJsonl(t *testing.T) {
+	reader, err := newJsonlReader("test/success.jsonl", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	assertRecordReader(t, reader)
+	reader.Close()
+}
+
+func TestReadFromYaml(t *testing.T) {
+	reader, err := newYamlReader("test/success.yaml", 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	assertRecordReader(t, reader)
+	reader.Close()
+}
+
+func assertRecordReader(t *testing.T, reader Reader) {
+	expected := []string{
+		"AnnualRevenue",
+		"Description",
+		"Id",
+		"IsActive__c",
+		"Name",
+		"NumberOfEmployees",
+		"Owner.Email",
+	}
+	assertArrayEqual(t, reader, expected)
+
+	expected = []string{
+		"",
+		"",
+		"001000000000001",
+		"true",
+		"あ",
+		"10",
+		"a@example.com",
+	}
+	assertArrayEqual(t, reader, expected)
+
+	expected = []string{
+		"1234.5",
+		"",
+		"",
+		"false",
+		"う",
+		"",
+		"",
+	}
+	assertArrayEqual(t, reader, expected)
+
+	_, err := reader.Read()
+	if err != io.EOF {
+		t.Fatalf("expected EOF, but '%v'", err)
+	}
+}
+
+func TestReadFromJsonlWithStartRow(t *testing.T) {
+	reader, err := newJsonlReader("test/success.jsonl", 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer reader.Close()
+	headers, err := reader.Read()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(headers) != 7 {
+		t.Fatalf("expected %d, but %d", 7, len(headers))
+	}
+	values, err := reader.Read()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if values != nil {
+		t.Fatalf("expected nil, but '%v'", values)
+	}
+	expected := []string{"1234.5", "", "", "false", "う", "", ""}
+	assertArrayEqual(t, reader, expected)
+}
+
+func TestReadFromMalformedJsonl(t *testing.T) {
+	_, err := newJsonlReader("test/malformed.jsonl", 0)
+	if err == nil {
+		t.Fatal("expected error, but nil")
+	}
+	expected := "jsonl is not valid at line 3"
+	if !strings.HasPrefix(err.Error(), expected) {
+		t.Fatalf("expected '%s', but '%s'", expected, err.Error())
+	}
+}
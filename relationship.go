@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/tzmfreedom/go-soapforce"
+)
+
+// relationshipColumn is a header which sets a reference field by an external
+// Id of the parent, e.g. Account.External__c. The type of the parent is given
+// for polymorphic relationships, e.g. What:Opportunity.Ext_Id__c.
+type relationshipColumn struct {
+	Relationship string
+	Type         string
+	Field        string
+}
+
+// parseRelationshipColumn returns nil for the headers of the fields of the object.
+func parseRelationshipColumn(header string) (*relationshipColumn, error) {
+	if !strings.Contains(header, ".") {
+		return nil, nil
+	}
+	parts := strings.Split(header, ".")
+	if len(parts) > 2 {
+		return nil, fmt.Errorf("%s: multi-level relationships are not supported, use the external Id of the direct parent (e.g. %s.%s)", header, parts[0], parts[len(parts)-1])
+	}
+	col := &relationshipColumn{Relationship: parts[0], Field: parts[1]}
+	if i := strings.Index(parts[0], ":"); i >= 0 {
+		col.Relationship = parts[0][:i]
+		col.Type = parts[0][i+1:]
+		if col.Type == "" {
+			return nil, fmt.Errorf("%s: type of the relationship is empty", header)
+		}
+	}
+	if col.Relationship == "" || col.Field == "" {
+		return nil, fmt.Errorf("%s: relationship and field are required", header)
+	}
+	return col, nil
+}
+
+// targetType returns the type of the parent among the targets of the
+// relationship. targets are empty when the relationship is unknown.
+func (col *relationshipColumn) targetType(targets []string) (string, error) {
+	if col.Type != "" {
+		if len(targets) == 0 {
+			return col.Type, nil
+		}
+		for _, t := range targets {
+			if strings.EqualFold(t, col.Type) {
+				return t, nil
+			}
+		}
+		return "", fmt.Errorf("%s is not a parent of %s, the parents are %s", col.Type, col.Relationship, strings.Join(targets, ", "))
+	}
+	switch len(targets) {
+	case 0:
+		return "", nil
+	case 1:
+		return targets[0], nil
+	}
+	return "", fmt.Errorf("%s is polymorphic, specify the type of the parent as %s:%s.%s (one of %s)", col.Relationship, col.Relationship, targets[0], col.Field, strings.Join(targets, ", "))
+}
+
+// value returns the parent of the reference field for the API.
+func (col *relationshipColumn) value(targets []string, value string) map[string]string {
+	t, _ := col.targetType(targets)
+	return map[string]string{"type": t, col.Field: value}
+}
+
+// referenceMaps has the parents of the relationships of the described
+// objects by org and object, with lowercase relationship names.
+var referenceMaps = struct {
+	sync.Mutex
+	m map[string]map[string][]string
+}{m: map[string]map[string][]string{}}
+
+func registerReferenceMap(orgId string, describe *soapforce.DescribeSObjectResult) {
+	referenceMaps.Lock()
+	defer referenceMaps.Unlock()
+	referenceMaps.m[orgId+"/"+strings.ToLower(describe.Name)] = newReferenceMap(describe)
+}
+
+func getReferenceMap(orgId string, object string) map[string][]string {
+	referenceMaps.Lock()
+	defer referenceMaps.Unlock()
+	return referenceMaps.m[orgId+"/"+strings.ToLower(object)]
+}
+
+func newReferenceMap(describe *soapforce.DescribeSObjectResult) map[string][]string {
+	references := map[string][]string{}
+	for _, f := range describe.Fields {
+		if fieldType(f) == "reference" && f.RelationshipName != "" {
+			references[strings.ToLower(f.RelationshipName)] = f.ReferenceTo
+		}
+	}
+	return references
+}
+
+// validateRelationshipColumns checks that the parent of every relationship
+// column can be determined before any record is sent.
+func validateRelationshipColumns(describe *soapforce.DescribeSObjectResult, headers []string) error {
+	references := newReferenceMap(describe)
+	for _, header := range headers {
+		if isResultColumn(header) {
+			continue
+		}
+		col, err := parseRelationshipColumn(header)
+		if err != nil {
+			return err
+		}
+		if col == nil {
+			continue
+		}
+		if _, err := col.targetType(references[strings.ToLower(col.Relationship)]); err != nil {
+			return fmt.Errorf("%s: %s", header, err)
+		}
+	}
+	return nil
+}
+
+// setRelationshipField sets the parent of a relationship column to the fields of the record.
+func setRelationshipField(client *soapforce.Client, sObjectType string, fields map[string]interface{}, header string, value string) {
+	col, err := parseRelationshipColumn(header)
+	if err != nil || col == nil {
+		return
+	}
+	orgId := ""
+	if client.UserInfo != nil {
+		orgId = client.UserInfo.OrganizationId
+	}
+	targets := getReferenceMap(orgId, sObjectType)[strings.ToLower(col.Relationship)]
+	fields[col.Relationship] = col.value(targets, value)
+}
+
+// bulk2RelationshipHeader returns the header of a relationship column in the
+// syntax of Bulk API, which puts the type first, e.g. Opportunity:What.Ext_Id__c.
+func bulk2RelationshipHeader(references map[string][]string, header string) string {
+	col, err := parseRelationshipColumn(header)
+	if err != nil || col == nil {
+		return header
+	}
+	targets := references[strings.ToLower(col.Relationship)]
+	if len(targets) < 2 {
+		return col.Relationship + "." + col.Field
+	}
+	t, _ := col.targetType(targets)
+	return t + ":" + col.Relationship + "." + col.Field
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/tzmfreedom/go-soapforce"
+)
+
+func newTaskDescribe() *soapforce.DescribeSObjectResult {
+	return &soapforce.DescribeSObjectResult{
+		Name: "Task",
+		Fields: []*soapforce.Field{
+			newDescribeField("Subject", "string", soapforce.Field{Createable: true}),
+			newDescribeField("WhatId", "reference", soapforce.Field{Createable: true, RelationshipName: "What", ReferenceTo: []string{"Account", "Opportunity"}}),
+			newDescribeField("OwnerId", "reference", soapforce.Field{Createable: true, RelationshipName: "Owner", ReferenceTo: []string{"User"}}),
+		},
+	}
+}
+
+func TestParseRelationshipColumn(t *testing.T) {
+	testCases := []struct {
+		header   string
+		expected *relationshipColumn
+		err      string
+	}{
+		{"Name", nil, ""},
+		{"Account.Ext_Id__c", &relationshipColumn{Relationship: "Account", Field: "Ext_Id__c"}, ""},
+		{"What:Opportunity.Ext_Id__c", &relationshipColumn{Relationship: "What", Type: "Opportunity", Field: "Ext_Id__c"}, ""},
+		{"Account.Owner.Email", nil, "Account.Owner.Email: multi-level relationships are not supported, use the external Id of the direct parent (e.g. Account.Email)"},
+		{"What:.Ext_Id__c", nil, "What:.Ext_Id__c: type of the relationship is empty"},
+		{"Account.", nil, "Account.: relationship and field are required"},
+	}
+	for _, testCase := range testCases {
+		actual, err := parseRelationshipColumn(testCase.header)
+		if testCase.err != "" {
+			if err == nil || err.Error() != testCase.err {
+				t.Fatalf("expected: '%s', but '%v'", testCase.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !reflect.DeepEqual(actual, testCase.expected) {
+			t.Fatalf("expected: %v, but %v", testCase.expected, actual)
+		}
+	}
+}
+
+func TestValidateRelationshipColumns(t *testing.T) {
+	describe := newTaskDescribe()
+	testCases := []struct {
+		headers []string
+		err     string
+	}{
+		{[]string{"Subject", "What:Opportunity.Ext_Id__c", "Owner.Email", "yasd__Row"}, ""},
+		{[]string{"What:opportunity.Ext_Id__c"}, ""},
+		{[]string{"What.Ext_Id__c"}, "What.Ext_Id__c: What is polymorphic, specify the type of the parent as What:Account.Ext_Id__c (one of Account, Opportunity)"},
+		{[]string{"What:Lead.Email"}, "What:Lead.Email: Lead is not a parent of What, the parents are Account, Opportunity"},
+		{[]string{"What:Opportunity.Account.Name"}, "multi-level relationships are not supported"},
+	}
+	for _, testCase := range testCases {
+		err := validateRelationshipColumns(describe, testCase.headers)
+		if testCase.err == "" {
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), testCase.err) {
+			t.Fatalf("expected: '%s', but '%v'", testCase.err, err)
+		}
+	}
+}
+
+func TestCreateSObjectWithRelationships(t *testing.T) {
+	client := &soapforce.Client{}
+	registerReferenceMap("", newTaskDescribe())
+	headers := []string{"Subject", "What:Opportunity.Ext_Id__c", "Owner.Email"}
+	sobject := createInsertSObject(client, "Task", headers, []string{"a", "O-1", "a@example.com"}, false)
+	expected := map[string]interface{}{
+		"Subject": "a",
+		"What":    map[string]string{"type": "Opportunity", "Ext_Id__c": "O-1"},
+		"Owner":   map[string]string{"type": "User", "Email": "a@example.com"},
+	}
+	if !reflect.DeepEqual(sobject.Fields, expected) {
+		t.Fatalf("expected: %v, but %v", expected, sobject.Fields)
+	}
+
+	actual := bulk2Headers(newTaskDescribe(), headers)
+	expectedHeaders := []string{"Subject", "Opportunity:What.Ext_Id__c", "Owner.Email"}
+	if !reflect.DeepEqual(actual, expectedHeaders) {
+		t.Fatalf("expected: %v, but %v", expectedHeaders, actual)
+	}
+}
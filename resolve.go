@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/tzmfreedom/go-soapforce"
+)
+
+// maxLookupValues is the number of values in the IN clause of a lookup query,
+// which keeps the query under the SOQL length limit.
+const maxLookupValues = 200
+
+var soqlNamePattern = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]*(\.[A-Za-z][A-Za-z0-9_]*)*$`)
+
+// lookupRule resolves the value of a reference field by a natural key of the
+// parent, e.g. the Name of an Account, instead of the Id or an external Id.
+type lookupRule struct {
+	Object string `yaml:"object"`
+	Field  string `yaml:"field"`
+	// Where narrows the parent records, e.g. "IsActive__c = true".
+	Where string `yaml:"where"`
+}
+
+func (l *lookupRule) validate() error {
+	if l.Object == "" || l.Field == "" {
+		return fmt.Errorf("lookup.object and lookup.field are required")
+	}
+	if !soqlNamePattern.MatchString(l.Object) || strings.Contains(l.Object, ".") {
+		return fmt.Errorf("lookup.object is not an object name: %s", l.Object)
+	}
+	if !soqlNamePattern.MatchString(l.Field) {
+		return fmt.Errorf("lookup.field is not a field name: %s", l.Field)
+	}
+	return nil
+}
+
+func (l *lookupRule) key() string {
+	return strings.ToLower(l.Object + "." + l.Field + "?" + l.Where)
+}
+
+// fieldLookup is the lookup of a column of the mapped records.
+type fieldLookup struct {
+	index int
+	field string
+	rule  *lookupRule
+}
+
+// querier runs a SOQL query and returns every record of the result.
+type querier func(soql string) ([]*soapforce.SObject, error)
+
+// newSoapQuerier queries with the client, retrying on transient faults.
+func newSoapQuerier(client *soapforce.Client, retry *retrier) querier {
+	return func(soql string) ([]*soapforce.SObject, error) {
+		records := []*soapforce.SObject{}
+		err := queryPages(client, retry, soql, func(page []*soapforce.SObject) error {
+			records = append(records, page...)
+			return nil
+		})
+		return records, err
+	}
+}
+
+// queryPages calls fn with each page of the query result.
+func queryPages(client *soapforce.Client, retry *retrier, soql string, fn func(records []*soapforce.SObject) error) error {
+	var res *soapforce.QueryResult
+	err := retry.Do(func() error {
+		var err error
+		res, err = client.Query(soql)
+		return err
+	})
+	for err == nil {
+		if err := fn(res.Records); err != nil {
+			return err
+		}
+		if res.QueryLocator == "" {
+			return nil
+		}
+		locator := res.QueryLocator
+		err = retry.Do(func() error {
+			var err error
+			res, err = client.QueryMore(locator)
+			return err
+		})
+	}
+	return err
+}
+
+// referenceResolver replaces the natural keys of the lookup fields with the
+// Ids of the parents. The Ids are cached for the whole load, so a value is
+// queried only once.
+type referenceResolver struct {
+	lookups []*fieldLookup
+	query   querier
+
+	// mu guards cache only, so that the batches query concurrently.
+	mu sync.Mutex
+	// cache has the Ids of the lowercase values by lookup, as SOQL compares
+	// strings case-insensitively.
+	cache map[string]map[string][]string
+}
+
+// newReferenceResolver returns nil when the mapping has no lookup.
+func newReferenceResolver(m *recordMapping, query querier) *referenceResolver {
+	if len(m.lookups) == 0 {
+		return nil
+	}
+	return &referenceResolver{
+		lookups: m.lookups,
+		query:   query,
+		cache:   map[string]map[string][]string{},
+	}
+}
+
+// Resolve replaces the lookup values of the records in place, and returns the
+// errors of the records whose value matches no parent or several parents.
+func (r *referenceResolver) Resolve(records [][]string) ([][]*soapforce.Error, error) {
+	errors := make([][]*soapforce.Error, len(records))
+	if r == nil {
+		return errors, nil
+	}
+	for _, l := range r.lookups {
+		found, err := r.fetch(l, records)
+		if err != nil {
+			return nil, err
+		}
+		for i, record := range records {
+			if l.index >= len(record) || record[l.index] == "" {
+				continue
+			}
+			value := record[l.index]
+			ids := found[strings.ToLower(value)]
+			switch len(ids) {
+			case 1:
+				record[l.index] = ids[0]
+			case 0:
+				errors[i] = append(errors[i], newApiError("INVALID_CROSS_REFERENCE_KEY",
+					fmt.Sprintf("%s: no %s found with %s '%s'", l.field, l.rule.Object, l.rule.Field, value), l.field))
+			default:
+				errors[i] = append(errors[i], newApiError("DUPLICATE_VALUE",
+					fmt.Sprintf("%s: %d %s records found with %s '%s'", l.field, len(ids), l.rule.Object, l.rule.Field, value), l.field))
+			}
+		}
+	}
+	return errors, nil
+}
+
+// fetch returns the Ids of the lowercase values of the records, querying the
+// values which are not cached yet. Values without a match are cached as
+// empty. The queries run without the lock, so a value may be queried by two
+// batches at the same time, which get the same Ids.
+func (r *referenceResolver) fetch(l *fieldLookup, records [][]string) (map[string][]string, error) {
+	key := l.rule.key()
+	found := map[string][]string{}
+	fetched := map[string][]string{}
+	values := []string{}
+	r.mu.Lock()
+	cache := r.cache[key]
+	for _, record := range records {
+		if l.index >= len(record) || record[l.index] == "" {
+			continue
+		}
+		v := strings.ToLower(record[l.index])
+		if _, ok := found[v]; ok {
+			continue
+		}
+		if ids, ok := cache[v]; ok {
+			found[v] = ids
+			continue
+		}
+		if _, ok := fetched[v]; ok {
+			continue
+		}
+		fetched[v] = []string{}
+		values = append(values, record[l.index])
+	}
+	r.mu.Unlock()
+	for start := 0; start < len(values); start += maxLookupValues {
+		end := start + maxLookupValues
+		if end > len(values) {
+			end = len(values)
+		}
+		sobjects, err := r.query(buildLookupQuery(l.rule, values[start:end]))
+		if err != nil {
+			return nil, err
+		}
+		for _, sobject := range sobjects {
+			v := strings.ToLower(getField(newCaseInsensitiveMap(sobject.Fields), l.rule.Field))
+			if ids, ok := fetched[v]; ok {
+				fetched[v] = append(ids, sobject.Id)
+			}
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cache, ok := r.cache[key]
+	if !ok {
+		cache = map[string][]string{}
+		r.cache[key] = cache
+	}
+	for v, ids := range fetched {
+		cache[v] = ids
+		found[v] = ids
+	}
+	return found, nil
+}
+
+func buildLookupQuery(rule *lookupRule, values []string) string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = quoteSoqlString(v)
+	}
+	q := fmt.Sprintf("SELECT Id, %s FROM %s WHERE %s IN (%s)", rule.Field, rule.Object, rule.Field, strings.Join(quoted, ", "))
+	if rule.Where != "" {
+		q += " AND (" + rule.Where + ")"
+	}
+	return q
+}
+
+func quoteSoqlString(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`, "\n", `\n`, "\r", `\r`, "\t", `\t`).Replace(s) + "'"
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tzmfreedom/go-soapforce"
+)
+
+func TestReferenceResolver(t *testing.T) {
+	queries := []string{}
+	query := func(soql string) ([]*soapforce.SObject, error) {
+		queries = append(queries, soql)
+		return []*soapforce.SObject{
+			{Id: "001000000000001", Fields: map[string]interface{}{"Name": "Acme"}},
+			{Id: "001000000000002", Fields: map[string]interface{}{"Name": "Dup"}},
+			{Id: "001000000000003", Fields: map[string]interface{}{"Name": "dup"}},
+		}, nil
+	}
+	m := &recordMapping{}
+	m.add(&fieldMapping{Field: "Name"}, []int{0})
+	m.add(&fieldMapping{Field: "AccountId", Lookup: &lookupRule{Object: "Account", Field: "Name", Where: "Type = 'Customer'"}}, []int{1})
+	m.lookups = []*fieldLookup{{index: 1, field: "AccountId", rule: m.fields[1].rule.Lookup}}
+	r := newReferenceResolver(m, query)
+
+	records := [][]string{{"a", "ACME"}, {"b", "Dup"}, {"c", "O'Neil"}, {"d", ""}}
+	errors, err := r.Resolve(records)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expectedQuery := `SELECT Id, Name FROM Account WHERE Name IN ('ACME', 'Dup', 'O\'Neil') AND (Type = 'Customer')`
+	if len(queries) != 1 || queries[0] != expectedQuery {
+		t.Fatalf("expected: '%s', but '%v'", expectedQuery, queries)
+	}
+	expected := [][]string{{"a", "001000000000001"}, {"b", "Dup"}, {"c", "O'Neil"}, {"d", ""}}
+	if !reflect.DeepEqual(records, expected) {
+		t.Fatalf("expected: %v, but %v", expected, records)
+	}
+	messages := []string{}
+	for _, errs := range errors {
+		messages = append(messages, errorMessages(errs))
+	}
+	expectedMessages := []string{
+		"",
+		"DUPLICATE_VALUE: AccountId: 2 Account records found with Name 'Dup'",
+		"INVALID_CROSS_REFERENCE_KEY: AccountId: no Account found with Name 'O'Neil'",
+		"",
+	}
+	if !reflect.DeepEqual(messages, expectedMessages) {
+		t.Fatalf("expected: %v, but %v", expectedMessages, messages)
+	}
+
+	// cached values, including the values without a match, are not queried again
+	records = [][]string{{"e", "acme"}, {"f", "O'Neil"}, {"g", "New"}}
+	if _, err := r.Resolve(records); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(queries) != 2 || !strings.HasSuffix(queries[1], "IN ('New') AND (Type = 'Customer')") {
+		t.Fatalf("unexpected queries: %v", queries)
+	}
+	if records[0][1] != "001000000000001" {
+		t.Fatalf("expected: '%s', but '%s'", "001000000000001", records[0][1])
+	}
+}
+
+func TestResolveLookupInBatch(t *testing.T) {
+	m := &recordMapping{}
+	m.add(&fieldMapping{Field: "AccountId"}, []int{0})
+	m.lookups = []*fieldLookup{{index: 0, field: "AccountId", rule: &lookupRule{Object: "Account", Field: "Name"}}}
+	resolver := newReferenceResolver(m, func(soql string) ([]*soapforce.SObject, error) {
+		return []*soapforce.SObject{{Id: "001000000000001", Fields: map[string]interface{}{"Name": "Acme"}}}, nil
+	})
+	in := &dmlInput{mapping: m, resolver: resolver}
+	r := &dmlRunner{convert: in.Convert, resolve: in.Resolve}
+	batch := &dmlBatch{rows: []int{1, 2}, records: [][]string{{"Acme"}, {"Unknown"}}}
+	valid, rejected, errors, err := r.convertBatch(batch)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(valid.rows, []int{1}) || valid.records[0][0] != "001000000000001" || valid.inputs[0][0] != "Acme" {
+		t.Fatalf("unexpected valid batch: %v %v %v", valid.rows, valid.records, valid.inputs)
+	}
+	if !reflect.DeepEqual(rejected.rows, []int{2}) || rejected.records[0][0] != "Unknown" || len(errors) != 1 {
+		t.Fatalf("unexpected rejected batch: %v %v %v", rejected.rows, rejected.records, errors)
+	}
+}
+
+func TestReferenceResolverQueriesConcurrently(t *testing.T) {
+	m := &recordMapping{}
+	m.add(&fieldMapping{Field: "AccountId"}, []int{0})
+	m.lookups = []*fieldLookup{{index: 0, field: "AccountId", rule: &lookupRule{Object: "Account", Field: "Name"}}}
+	// each query waits for the other, which blocks when the queries run under the lock
+	started := make(chan bool, 2)
+	r := newReferenceResolver(m, func(soql string) ([]*soapforce.SObject, error) {
+		started <- true
+		timeout := time.After(time.Second)
+		for len(started) < 2 {
+			select {
+			case <-timeout:
+				return nil, fmt.Errorf("the other query did not start")
+			case <-time.After(time.Millisecond):
+			}
+		}
+		return []*soapforce.SObject{{Id: "001000000000001", Fields: map[string]interface{}{"Name": "Acme"}}}, nil
+	})
+	errs := make(chan error, 2)
+	for _, name := range []string{"Acme", "Other"} {
+		go func(name string) {
+			_, err := r.Resolve([][]string{{name}})
+			errs <- err
+		}(name)
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if _, ok := r.cache[m.lookups[0].rule.key()]["other"]; !ok {
+		t.Fatalf("unexpected cache: %v", r.cache)
+	}
+}
@@ -2,9 +2,11 @@ package main
 
 import (
 	"encoding/csv"
+	"fmt"
 	"io"
 	"os"
 	"runtime"
+	"strconv"
 	"strings"
 
 	"github.com/tzmfreedom/go-soapforce"
@@ -13,136 +15,230 @@ import (
 	"golang.org/x/text/transform"
 )
 
+// resultColumnPrefix marks the columns added to the success and error files.
+// These columns are ignored on load, so that an error file can be fixed and
+// loaded again with the same command.
+const resultColumnPrefix = "yasd__"
+
+func isResultColumn(header string) bool {
+	return strings.HasPrefix(header, resultColumnPrefix)
+}
+
 type responseHandler interface {
-	Handle(results []*soapforce.SaveResult) error
-	HandleUpsert(results []*soapforce.UpsertResult) error
-	HandleDelete(results []*soapforce.DeleteResult) error
-	HandleUndelete(results []*soapforce.UndeleteResult) error
+	Handle(batch *dmlBatch, results []*soapforce.SaveResult) error
+	HandleUpsert(batch *dmlBatch, results []*soapforce.UpsertResult) error
+	HandleDelete(batch *dmlBatch, results []*soapforce.DeleteResult) error
+	HandleUndelete(batch *dmlBatch, results []*soapforce.UndeleteResult) error
 }
 
 type NoopResponseWriteHandler struct{}
 
-func (h *NoopResponseWriteHandler) Handle(results []*soapforce.SaveResult) error         { return nil }
-func (h *NoopResponseWriteHandler) HandleUpsert(results []*soapforce.UpsertResult) error { return nil }
-func (h *NoopResponseWriteHandler) HandleDelete(results []*soapforce.DeleteResult) error { return nil }
-func (h *NoopResponseWriteHandler) HandleUndelete(results []*soapforce.UndeleteResult) error {
+func (h *NoopResponseWriteHandler) Handle(batch *dmlBatch, results []*soapforce.SaveResult) error {
+	return nil
+}
+func (h *NoopResponseWriteHandler) HandleUpsert(batch *dmlBatch, results []*soapforce.UpsertResult) error {
+	return nil
+}
+func (h *NoopResponseWriteHandler) HandleDelete(batch *dmlBatch, results []*soapforce.DeleteResult) error {
+	return nil
+}
+func (h *NoopResponseWriteHandler) HandleUndelete(batch *dmlBatch, results []*soapforce.UndeleteResult) error {
 	return nil
 }
 
+// ResponseWriteHandler writes each result with its source row number and
+// original input columns. The success file has the Id of the record, and the
+// error file has the status codes, messages and fields of the errors.
 type ResponseWriteHandler struct {
+	successFile   *os.File
+	errorFile     *os.File
 	successWriter *csv.Writer
 	errorWriter   *csv.Writer
+	// columns are the indexes of the input columns, without the result
+	// columns of an error file loaded again.
+	columns []int
+	// rowColumn is the index of the row number column of an error file loaded
+	// again, which has the row of the original input, or -1.
+	rowColumn int
 }
 
-func (h *ResponseWriteHandler) Handle(results []*soapforce.SaveResult) error {
-	for _, result := range results {
-		if result.Success {
-			fields := []string{}
-			fields = append(fields, result.Id)
-			h.successWriter.Write(fields)
-		} else {
-			fields := []string{}
-			errorMessages := []string{}
-			for _, error := range result.Errors {
-				errorMessages = append(errorMessages, error.Message)
-			}
-			errorMsg := strings.Join(errorMessages, ":")
-			fields = append(fields, errorMsg)
-			h.errorWriter.Write(fields)
-		}
+func (h *ResponseWriteHandler) Handle(batch *dmlBatch, results []*soapforce.SaveResult) error {
+	for i, result := range results {
+		h.write(batch, i, result.Id, result.Success, result.Errors)
 	}
-	h.successWriter.Flush()
-	h.errorWriter.Flush()
-	return nil
+	return h.flush()
 }
 
-func (h *ResponseWriteHandler) HandleUpsert(results []*soapforce.UpsertResult) error {
-	for _, result := range results {
-		if result.Success {
-			fields := []string{}
-			fields = append(fields, result.Id)
-			h.successWriter.Write(fields)
-		} else {
-			fields := []string{}
-			errorMessages := []string{}
-			for _, error := range result.Errors {
-				errorMessages = append(errorMessages, error.Message)
-			}
-			errorMsg := strings.Join(errorMessages, ":")
-			fields = append(fields, errorMsg)
-			h.errorWriter.Write(fields)
-		}
+func (h *ResponseWriteHandler) HandleUpsert(batch *dmlBatch, results []*soapforce.UpsertResult) error {
+	for i, result := range results {
+		h.write(batch, i, result.Id, result.Success, result.Errors)
 	}
-	h.successWriter.Flush()
-	h.errorWriter.Flush()
-	return nil
+	return h.flush()
+}
+
+func (h *ResponseWriteHandler) HandleDelete(batch *dmlBatch, results []*soapforce.DeleteResult) error {
+	for i, result := range results {
+		h.write(batch, i, result.Id, result.Success, result.Errors)
+	}
+	return h.flush()
+}
+
+func (h *ResponseWriteHandler) HandleUndelete(batch *dmlBatch, results []*soapforce.UndeleteResult) error {
+	for i, result := range results {
+		h.write(batch, i, result.Id, result.Success, result.Errors)
+	}
+	return h.flush()
 }
 
-func (h *ResponseWriteHandler) HandleDelete(results []*soapforce.DeleteResult) error {
-	for _, result := range results {
-		if result.Success {
-			fields := []string{}
-			fields = append(fields, result.Id)
-			h.successWriter.Write(fields)
-		} else {
-			fields := []string{}
-			errorMessages := []string{}
-			for _, error := range result.Errors {
-				errorMessages = append(errorMessages, error.Message)
-			}
-			errorMsg := strings.Join(errorMessages, ":")
-			fields = append(fields, errorMsg)
-			h.errorWriter.Write(fields)
+func (h *ResponseWriteHandler) write(batch *dmlBatch, i int, id string, success bool, errors []*soapforce.Error) {
+	fields := []string{""}
+	if i < len(batch.rows) {
+		fields[0] = strconv.Itoa(batch.rows[i])
+	}
+	records := batch.records
+	if batch.inputs != nil {
+		records = batch.inputs
+	}
+	var input []string
+	if i < len(records) {
+		input = records[i]
+	}
+	if h.rowColumn >= 0 && h.rowColumn < len(input) && input[h.rowColumn] != "" {
+		fields[0] = input[h.rowColumn]
+	}
+	for _, column := range h.columns {
+		value := ""
+		if column < len(input) {
+			value = input[column]
 		}
+		fields = append(fields, value)
+	}
+	fields = append(fields, id)
+	if success {
+		h.successWriter.Write(fields)
+		return
+	}
+	statusCodes := []string{}
+	errorMessages := []string{}
+	errorFields := []string{}
+	for _, error := range errors {
+		statusCodes = append(statusCodes, stringifyStatusCode(error.StatusCode))
+		errorMessages = append(errorMessages, error.Message)
+		errorFields = append(errorFields, error.Fields...)
 	}
+	fields = append(fields, strings.Join(statusCodes, ";"), strings.Join(errorMessages, ";"), strings.Join(errorFields, ","))
+	h.errorWriter.Write(fields)
+}
+
+func (h *ResponseWriteHandler) flush() error {
 	h.successWriter.Flush()
 	h.errorWriter.Flush()
-	return nil
+	if err := h.successWriter.Error(); err != nil {
+		return err
+	}
+	return h.errorWriter.Error()
+}
+
+// Offsets returns the current sizes of the success and error files.
+func (h *ResponseWriteHandler) Offsets() (int64, int64, error) {
+	successOffset, err := fileOffset(h.successFile)
+	if err != nil {
+		return 0, 0, err
+	}
+	errorOffset, err := fileOffset(h.errorFile)
+	return successOffset, errorOffset, err
 }
 
-func (h *ResponseWriteHandler) HandleUndelete(results []*soapforce.UndeleteResult) error {
-	for _, result := range results {
-		if result.Success {
-			fields := []string{}
-			fields = append(fields, result.Id)
-			h.successWriter.Write(fields)
-		} else {
-			fields := []string{}
-			errorMessages := []string{}
-			for _, error := range result.Errors {
-				errorMessages = append(errorMessages, error.Message)
-			}
-			errorMsg := strings.Join(errorMessages, ":")
-			fields = append(fields, errorMsg)
-			h.errorWriter.Write(fields)
+func fileOffset(f *os.File) (int64, error) {
+	if f == nil {
+		return 0, nil
+	}
+	return f.Seek(0, io.SeekCurrent)
+}
+
+// saveErrorMessage formats the errors of a failed save, one per line.
+func saveErrorMessage(result *soapforce.SaveResult) string {
+	messages := []string{}
+	if result != nil {
+		for _, e := range result.Errors {
+			messages = append(messages, fmt.Sprintf("%s: %s", stringifyStatusCode(e.StatusCode), e.Message))
 		}
 	}
-	h.successWriter.Flush()
-	h.errorWriter.Flush()
-	return nil
+	return strings.Join(messages, "\n")
 }
 
-func newResponseWriteHandler(success string, error string, encoding string) (*ResponseWriteHandler, error) {
-	successWriter, err := createCsvWriter(success, encoding)
+// stringifyStatusCode returns the status code of soapforce.Error, which is empty when it is not set.
+func stringifyStatusCode(code *soapforce.StatusCode) string {
+	if code == nil {
+		return ""
+	}
+	return string(*code)
+}
+
+func newResponseWriteHandler(success string, error string, encoding string, headers []string) (*ResponseWriteHandler, error) {
+	h, err := openResponseWriteHandler(success, error, encoding, headers, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	successHeaders := []string{resultColumnPrefix + "Row"}
+	for _, column := range h.columns {
+		successHeaders = append(successHeaders, headers[column])
+	}
+	successHeaders = append(successHeaders, resultColumnPrefix+"Id")
+	errorHeaders := append([]string{}, successHeaders...)
+	errorHeaders = append(errorHeaders, resultColumnPrefix+"StatusCode", resultColumnPrefix+"Error", resultColumnPrefix+"Fields")
+	h.successWriter.Write(successHeaders)
+	h.errorWriter.Write(errorHeaders)
+	return h, h.flush()
+}
+
+// openResponseWriteHandler writes the results after the offsets of the success
+// and error files, discarding anything written after them by an interrupted run.
+func openResponseWriteHandler(success string, error string, encoding string, headers []string, successOffset int64, errorOffset int64) (*ResponseWriteHandler, error) {
+	successFile, successWriter, err := createCsvWriter(success, encoding, successOffset)
 	if err != nil {
 		return nil, err
 	}
-	errorWriter, err := createCsvWriter(error, encoding)
+	errorFile, errorWriter, err := createCsvWriter(error, encoding, errorOffset)
 	if err != nil {
 		return nil, err
 	}
-	return &ResponseWriteHandler{
+	h := &ResponseWriteHandler{
+		successFile:   successFile,
+		errorFile:     errorFile,
 		successWriter: successWriter,
 		errorWriter:   errorWriter,
-	}, nil
+		rowColumn:     -1,
+	}
+	for i, header := range headers {
+		switch {
+		case header == resultColumnPrefix+"Row":
+			h.rowColumn = i
+		case !isResultColumn(header):
+			h.columns = append(h.columns, i)
+		}
+	}
+	return h, nil
 }
 
-func createCsvWriter(path string, encoding string) (*csv.Writer, error) {
+// createCsvWriter opens the file truncated to offset and writes after it.
+// Without path, it writes to stderr and returns a nil file.
+func createCsvWriter(path string, encoding string, offset int64) (*os.File, *csv.Writer, error) {
+	var fp *os.File
 	var writer *csv.Writer
 	if path != "" {
-		fp, err := os.Create(path)
+		var err error
+		fp, err = os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
+		}
+		if err := fp.Truncate(offset); err != nil {
+			fp.Close()
+			return nil, nil, err
+		}
+		if _, err := fp.Seek(offset, io.SeekStart); err != nil {
+			fp.Close()
+			return nil, nil, err
 		}
 		var w io.Writer
 		switch strings.ToUpper(encoding) {
@@ -158,14 +254,20 @@ func createCsvWriter(path string, encoding string) (*csv.Writer, error) {
 	if runtime.GOOS == "windows" {
 		writer.UseCRLF = true
 	}
-	return writer, nil
+	return fp, writer, nil
 }
 
-func getResponseHandler(c *cli.Context) (responseHandler, error) {
+// getResponseHandler creates the handler of the DML results. headers are the
+// original header of the input file, before mapping. When the checkpoint is
+// resumed, the results are appended to the files of the previous run.
+func getResponseHandler(c *cli.Context, headers []string, cp *checkpoint) (responseHandler, error) {
 	success := c.String("success-file")
 	error := c.String("error-file")
 	encoding := c.String("encoding")
 
-	h, err := newResponseWriteHandler(success, error, encoding)
+	if cp != nil && cp.resumed {
+		return openResponseWriteHandler(success, error, encoding, headers, cp.SuccessOffset, cp.ErrorOffset)
+	}
+	h, err := newResponseWriteHandler(success, error, encoding, headers)
 	return h, err
 }
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tzmfreedom/go-soapforce"
+)
+
+func TestResponseWriteHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "yasd")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	successFile := filepath.Join(dir, "success.csv")
+	errorFile := filepath.Join(dir, "error.csv")
+
+	handler, err := newResponseWriteHandler(successFile, errorFile, "utf8", []string{"Name", "Phone"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	batch := &dmlBatch{
+		rows:    []int{3, 5},
+		records: [][]string{{"a", "000"}, {""}},
+	}
+	results := []*soapforce.SaveResult{
+		{Id: "001a", Success: true},
+		{Errors: []*soapforce.Error{
+			{Message: "Required fields are missing: [Name]", Fields: []string{"Name"}},
+		}},
+	}
+	if err := handler.Handle(batch, results); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	b, _ := ioutil.ReadFile(successFile)
+	expected := "yasd__Row,Name,Phone,yasd__Id\n3,a,000,001a\n"
+	if string(b) != expected {
+		t.Fatalf("expected: '%s', but '%s'", expected, string(b))
+	}
+	b, _ = ioutil.ReadFile(errorFile)
+	expected = "yasd__Row,Name,Phone,yasd__Id,yasd__StatusCode,yasd__Error,yasd__Fields\n5,,,,,Required fields are missing: [Name],Name\n"
+	if string(b) != expected {
+		t.Fatalf("expected: '%s', but '%s'", expected, string(b))
+	}
+}
+
+func TestResponseWriteHandlerWithErrorFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "yasd")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	successFile := filepath.Join(dir, "success.csv")
+	errorFile := filepath.Join(dir, "error.csv")
+
+	headers := []string{"yasd__Row", "Name", "yasd__Id", "yasd__StatusCode", "yasd__Error", "yasd__Fields"}
+	handler, err := newResponseWriteHandler(successFile, errorFile, "utf8", headers)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	batch := &dmlBatch{
+		rows:    []int{1, 2},
+		records: [][]string{{"5", "a", "", "", "error", ""}, {"8", "b", "", "", "error", ""}},
+	}
+	results := []*soapforce.SaveResult{
+		{Id: "001a", Success: true},
+		{Errors: []*soapforce.Error{{Message: "error"}}},
+	}
+	if err := handler.Handle(batch, results); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	b, _ := ioutil.ReadFile(successFile)
+	expected := "yasd__Row,Name,yasd__Id\n5,a,001a\n"
+	if string(b) != expected {
+		t.Fatalf("expected: '%s', but '%s'", expected, string(b))
+	}
+	b, _ = ioutil.ReadFile(errorFile)
+	expected = "yasd__Row,Name,yasd__Id,yasd__StatusCode,yasd__Error,yasd__Fields\n8,b,,,error,\n"
+	if string(b) != expected {
+		t.Fatalf("expected: '%s', but '%s'", expected, string(b))
+	}
+}
+
+func TestStringifyStatusCode(t *testing.T) {
+	code := soapforce.StatusCode("REQUIRED_FIELD_MISSING")
+	testCases := []struct {
+		code     *soapforce.StatusCode
+		expected string
+	}{
+		{&code, "REQUIRED_FIELD_MISSING"},
+		{nil, ""},
+	}
+	for _, testCase := range testCases {
+		actual := stringifyStatusCode(testCase.code)
+		if actual != testCase.expected {
+			t.Fatalf("expected: '%s', but '%s'", testCase.expected, actual)
+		}
+	}
+}
+
+func TestCreateSObjectIgnoresResultColumns(t *testing.T) {
+	client := &soapforce.Client{}
+	headers := []string{"yasd__Row", "Id", "Name", "yasd__Error"}
+	sobject := createSObject(client, "Account", headers, []string{"1", "001a", "a", "error"}, false)
+	if sobject.Id != "001a" || len(sobject.Fields) != 1 || sobject.Fields["Name"] != "a" {
+		t.Fatalf("unexpected sobject: %v", sobject)
+	}
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tzmfreedom/go-soapforce"
+	"github.com/urfave/cli"
+)
+
+const (
+	// defaultMaxRetries is how many times a call is retried on transient faults.
+	defaultMaxRetries = 5
+	// maxBackoff caps the exponential backoff between retries.
+	maxBackoff = time.Minute
+)
+
+// retryableFaults are the API faults which succeed when the call is sent
+// again later. The call is not processed when they are returned.
+var retryableFaults = []string{
+	"UNABLE_TO_LOCK_ROW",
+	"REQUEST_LIMIT_EXCEEDED",
+	"ConcurrentPerOrgLongTxn",
+	"SERVER_UNAVAILABLE",
+}
+
+// connectionErrors are the network errors after which the call may or may not
+// have been processed, so only idempotent calls are sent again.
+var connectionErrors = []string{
+	"connection reset",
+	"broken pipe",
+	"unexpected EOF",
+}
+
+// retryableStatusCodes are the per-record errors retried with the failed rows only.
+var retryableStatusCodes = []string{
+	"UNABLE_TO_LOCK_ROW",
+}
+
+// retrier retries API calls with jittered exponential backoff. An expired
+// session is refreshed with relogin, which is shared by concurrent callers.
+type retrier struct {
+	maxRetries int
+	backoff    time.Duration
+	relogin    func() error
+
+	mu sync.Mutex
+	// session counts the logins, so that concurrent callers log in once for
+	// the same expired session.
+	session int
+}
+
+// partialBatchError is returned when retrying the failed records of a batch
+// fails. The batch is not retried as a whole, since its other records are saved.
+type partialBatchError struct {
+	err error
+}
+
+func (e *partialBatchError) Error() string {
+	return e.err.Error()
+}
+
+// createError is a connection error of a call creating records, which is not
+// retried since sending the call again may create the records twice.
+type createError struct {
+	err error
+	// rows are the source rows of the records, which are set by the DML runner
+	// to record them in the checkpoint.
+	rows []int
+}
+
+func (e *createError) Error() string {
+	return fmt.Sprintf("%s: the records may have been created", e.err)
+}
+
+// createOnce returns the error of a call creating records, which is not
+// retried when the connection is lost.
+func createOnce(err error) error {
+	if err != nil && isConnectionError(err) {
+		return &createError{err: err}
+	}
+	return err
+}
+
+func newRetrier(c *cli.Context, client *soapforce.Client) *retrier {
+	return newLoginRetrier(c.Int("max-retries"), func() error {
+		return login(client, c)
+	})
+}
+
+func newLoginRetrier(maxRetries int, relogin func() error) *retrier {
+	return &retrier{
+		maxRetries: maxRetries,
+		backoff:    time.Second,
+		relogin:    relogin,
+	}
+}
+
+// Do calls the API until it succeeds, fails with a fault which is not
+// transient or the retries are exhausted.
+func (r *retrier) Do(call func() error) error {
+	if r == nil {
+		return call()
+	}
+	for attempt := 0; ; attempt++ {
+		session := r.currentSession()
+		err := call()
+		if err == nil || attempt >= r.maxRetries {
+			return err
+		}
+		switch err.(type) {
+		case *partialBatchError, *createError:
+			return err
+		}
+		if isInvalidSessionError(err) && r.relogin != nil {
+			if err := r.refreshSession(session); err != nil {
+				return err
+			}
+			continue
+		}
+		if !isRetryableError(err) {
+			return err
+		}
+		time.Sleep(r.wait(attempt))
+	}
+}
+
+// Records sends the records of a batch, and then sends again only the records
+// whose errors are retryable. send is called with the indexes of the records
+// and returns the errors of each of them. The first call is retried as a whole
+// by the caller, the following calls are retried by Records.
+func (r *retrier) Records(n int, send func(indexes []int) ([][]*soapforce.Error, error)) error {
+	indexes := make([]int, n)
+	for i := range indexes {
+		indexes[i] = i
+	}
+	errs, err := send(indexes)
+	if err != nil || r == nil {
+		return err
+	}
+	for attempt := 0; attempt < r.maxRetries; attempt++ {
+		failed := []int{}
+		for i, e := range errs {
+			if i < len(indexes) && hasRetryableStatusCode(e) {
+				failed = append(failed, indexes[i])
+			}
+		}
+		if len(failed) == 0 {
+			return nil
+		}
+		time.Sleep(r.wait(attempt))
+		indexes = failed
+		err := r.Do(func() error {
+			var err error
+			errs, err = send(indexes)
+			return err
+		})
+		if err != nil {
+			return &partialBatchError{err: err}
+		}
+	}
+	return nil
+}
+
+// saveRecords sends the n records of a batch with retry.Records and returns
+// the result of each record. call sends the records of the indexes.
+func saveRecords(retry *retrier, n int, call func(indexes []int) ([]*soapforce.SaveResult, error)) ([]*soapforce.SaveResult, error) {
+	res := make([]*soapforce.SaveResult, n)
+	err := retry.Records(n, func(indexes []int) ([][]*soapforce.Error, error) {
+		results, err := call(indexes)
+		errs := make([][]*soapforce.Error, len(results))
+		for i, result := range results {
+			if i < len(indexes) {
+				res[indexes[i]] = result
+			}
+			errs[i] = result.Errors
+		}
+		return errs, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// upsertRecords is saveRecords of upsert.
+func upsertRecords(retry *retrier, n int, call func(indexes []int) ([]*soapforce.UpsertResult, error)) ([]*soapforce.UpsertResult, error) {
+	res := make([]*soapforce.UpsertResult, n)
+	err := retry.Records(n, func(indexes []int) ([][]*soapforce.Error, error) {
+		results, err := call(indexes)
+		errs := make([][]*soapforce.Error, len(results))
+		for i, result := range results {
+			if i < len(indexes) {
+				res[indexes[i]] = result
+			}
+			errs[i] = result.Errors
+		}
+		return errs, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// deleteRecords is saveRecords of delete.
+func deleteRecords(retry *retrier, n int, call func(indexes []int) ([]*soapforce.DeleteResult, error)) ([]*soapforce.DeleteResult, error) {
+	res := make([]*soapforce.DeleteResult, n)
+	err := retry.Records(n, func(indexes []int) ([][]*soapforce.Error, error) {
+		results, err := call(indexes)
+		errs := make([][]*soapforce.Error, len(results))
+		for i, result := range results {
+			if i < len(indexes) {
+				res[indexes[i]] = result
+			}
+			errs[i] = result.Errors
+		}
+		return errs, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// undeleteRecords is saveRecords of undelete.
+func undeleteRecords(retry *retrier, n int, call func(indexes []int) ([]*soapforce.UndeleteResult, error)) ([]*soapforce.UndeleteResult, error) {
+	res := make([]*soapforce.UndeleteResult, n)
+	err := retry.Records(n, func(indexes []int) ([][]*soapforce.Error, error) {
+		results, err := call(indexes)
+		errs := make([][]*soapforce.Error, len(results))
+		for i, result := range results {
+			if i < len(indexes) {
+				res[indexes[i]] = result
+			}
+			errs[i] = result.Errors
+		}
+		return errs, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// saveSObjects returns the call saving the records with saveRecords, which is
+// retried as a whole on transient faults.
+func saveSObjects(retry *retrier, call func([]*soapforce.SObject) ([]*soapforce.SaveResult, error)) func([]*soapforce.SObject) ([]*soapforce.SaveResult, error) {
+	return func(sobjects []*soapforce.SObject) ([]*soapforce.SaveResult, error) {
+		var res []*soapforce.SaveResult
+		err := retry.Do(func() error {
+			var err error
+			res, err = saveRecords(retry, len(sobjects), func(indexes []int) ([]*soapforce.SaveResult, error) {
+				return call(selectSObjects(sobjects, indexes))
+			})
+			return err
+		})
+		return res, err
+	}
+}
+
+func selectSObjects(sobjects []*soapforce.SObject, indexes []int) []*soapforce.SObject {
+	selected := make([]*soapforce.SObject, len(indexes))
+	for i, index := range indexes {
+		selected[i] = sobjects[index]
+	}
+	return selected
+}
+
+func selectIds(ids []string, indexes []int) []string {
+	selected := make([]string, len(indexes))
+	for i, index := range indexes {
+		selected[i] = ids[index]
+	}
+	return selected
+}
+
+func (r *retrier) currentSession() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.session
+}
+
+func (r *retrier) refreshSession(session int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.session != session {
+		return nil
+	}
+	if err := r.relogin(); err != nil {
+		return err
+	}
+	r.session++
+	return nil
+}
+
+// wait returns the backoff of the attempt, jittered between half and the full value.
+func (r *retrier) wait(attempt int) time.Duration {
+	wait := r.backoff
+	for i := 0; i < attempt && wait < maxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > maxBackoff {
+		wait = maxBackoff
+	}
+	half := int64(wait / 2)
+	return time.Duration(half + rand.Int63n(half+1))
+}
+
+func isRetryableError(err error) bool {
+	if isConnectionError(err) {
+		return true
+	}
+	msg := err.Error()
+	for _, fault := range retryableFaults {
+		if strings.Contains(msg, fault) {
+			return true
+		}
+	}
+	return false
+}
+
+func isConnectionError(err error) bool {
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return true
+	}
+	msg := err.Error()
+	for _, e := range connectionErrors {
+		if strings.Contains(msg, e) {
+			return true
+		}
+	}
+	return false
+}
+
+func isInvalidSessionError(err error) bool {
+	return strings.Contains(err.Error(), "INVALID_SESSION_ID")
+}
+
+func hasRetryableStatusCode(errors []*soapforce.Error) bool {
+	for _, e := range errors {
+		code := stringifyStatusCode(e.StatusCode)
+		for _, retryable := range retryableStatusCodes {
+			if code == retryable {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/tzmfreedom/go-soapforce"
+)
+
+func TestRetrierRetriesTransientFaults(t *testing.T) {
+	r := &retrier{maxRetries: 3, backoff: time.Millisecond}
+	testCases := []struct {
+		err      error
+		expected int
+	}{
+		{errors.New("UNABLE_TO_LOCK_ROW: unable to obtain exclusive access to this record"), 4},
+		{errors.New("read tcp 10.0.0.1:443: read: connection reset by peer"), 4},
+		{errors.New("SERVER_UNAVAILABLE: server is unavailable"), 4},
+		{errors.New("INVALID_FIELD: No such column 'Foo' on entity 'Account'"), 1},
+	}
+	for _, testCase := range testCases {
+		calls := 0
+		err := r.Do(func() error {
+			calls++
+			return testCase.err
+		})
+		if err != testCase.err {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if calls != testCase.expected {
+			t.Fatalf("expected %d, but %d", testCase.expected, calls)
+		}
+	}
+}
+
+func TestRetrierRelogins(t *testing.T) {
+	logins := 0
+	r := &retrier{
+		maxRetries: 3,
+		backoff:    time.Millisecond,
+		relogin: func() error {
+			logins++
+			return nil
+		},
+	}
+	calls := 0
+	err := r.Do(func() error {
+		calls++
+		if logins == 0 {
+			return errors.New("INVALID_SESSION_ID: Invalid Session ID found in SessionHeader")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if logins != 1 || calls != 2 {
+		t.Fatalf("expected 1 login and 2 calls, but %d and %d", logins, calls)
+	}
+}
+
+func TestRetrierRetriesFailedRecords(t *testing.T) {
+	r := &retrier{maxRetries: 3, backoff: time.Millisecond}
+	sent := [][]int{}
+	err := r.Records(4, func(indexes []int) ([][]*soapforce.Error, error) {
+		sent = append(sent, indexes)
+		errs := make([][]*soapforce.Error, len(indexes))
+		if len(sent) == 1 {
+			errs[1] = []*soapforce.Error{newApiError("UNABLE_TO_LOCK_ROW", "unable to obtain exclusive access to this record")}
+			errs[2] = []*soapforce.Error{newApiError("REQUIRED_FIELD_MISSING", "Required fields are missing: [Name]", "Name")}
+			errs[3] = []*soapforce.Error{newApiError("UNABLE_TO_LOCK_ROW", "unable to obtain exclusive access to this record")}
+		}
+		return errs, nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(sent) != 2 || !reflect.DeepEqual(sent[1], []int{1, 3}) {
+		t.Fatalf("expected [0 1 2 3] and [1 3], but %v", sent)
+	}
+}
+
+func TestRetrierDoesNotRetryPartialBatch(t *testing.T) {
+	r := &retrier{maxRetries: 2, backoff: time.Millisecond}
+	calls := 0
+	err := r.Do(func() error {
+		calls++
+		return r.Records(2, func(indexes []int) ([][]*soapforce.Error, error) {
+			if len(indexes) == 2 {
+				return [][]*soapforce.Error{nil, {newApiError("UNABLE_TO_LOCK_ROW", "unable to obtain exclusive access to this record")}}, nil
+			}
+			return nil, errors.New("SERVER_UNAVAILABLE: server is unavailable")
+		})
+	})
+	if _, ok := err.(*partialBatchError); !ok {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected %d, but %d", 1, calls)
+	}
+}
+
+func TestDeleteRecords(t *testing.T) {
+	r := &retrier{maxRetries: 3, backoff: time.Millisecond}
+	ids := []string{"A1", "A2", "A3"}
+	calls := 0
+	res, err := deleteRecords(r, len(ids), func(indexes []int) ([]*soapforce.DeleteResult, error) {
+		calls++
+		results := make([]*soapforce.DeleteResult, len(indexes))
+		for i, id := range selectIds(ids, indexes) {
+			results[i] = &soapforce.DeleteResult{Id: id, Success: true}
+			if calls == 1 && id == "A2" {
+				results[i] = &soapforce.DeleteResult{Errors: []*soapforce.Error{newApiError("UNABLE_TO_LOCK_ROW", "unable to obtain exclusive access to this record")}}
+			}
+		}
+		return results, nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if calls != 2 {
+		t.Fatalf("expected %d, but %d", 2, calls)
+	}
+	for i, result := range res {
+		if !result.Success || result.Id != ids[i] {
+			t.Fatalf("unexpected result: %v", result)
+		}
+	}
+}
+
+func TestRetrierDoesNotResendCreates(t *testing.T) {
+	r := &retrier{maxRetries: 3, backoff: time.Millisecond}
+	calls := 0
+	err := r.Do(func() error {
+		calls++
+		return createOnce(errors.New("read tcp 10.0.0.1:443: connection reset by peer"))
+	})
+	if _, ok := err.(*createError); !ok {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected %d, but %d", 1, calls)
+	}
+
+	// the faults returned without processing the call are retried
+	calls = 0
+	err = r.Do(func() error {
+		calls++
+		if calls == 1 {
+			return createOnce(errors.New("SERVER_UNAVAILABLE: server is unavailable"))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if calls != 2 {
+		t.Fatalf("expected %d, but %d", 2, calls)
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/tzmfreedom/go-soapforce"
+	"github.com/urfave/cli"
+)
+
+var rollbackHeaders = []string{"Operation", "Type", "Id"}
+
+// rollbacker reverts the changes of a journal in reverse order: inserted and
+// undeleted records are deleted, updated records get the values before the
+// update, and deleted records are undeleted, or recreated from the values
+// before the delete when they cannot be undeleted.
+type rollbacker struct {
+	deleteIds      func(ids []string) ([]*soapforce.DeleteResult, error)
+	undeleteIds    func(ids []string) ([]*soapforce.UndeleteResult, error)
+	createSObjects func(sobjects []*soapforce.SObject) ([]*soapforce.SaveResult, error)
+	updateSObjects func(sobjects []*soapforce.SObject) ([]*soapforce.SaveResult, error)
+	handler        responseHandler
+	out            io.Writer
+}
+
+func rollback(c *cli.Context) error {
+	if err := validateLoginFlag(c, "rollback"); err != nil {
+		return err
+	}
+	if c.String("journal") == "" {
+		_ = cli.ShowCommandHelp(c, "rollback")
+		return cli.NewExitError("journal is required", 1)
+	}
+	entries, err := readJournal(c.String("journal"))
+	if err != nil {
+		return err
+	}
+	client := newClient(c)
+	if err := login(client, c); err != nil {
+		return err
+	}
+	retry := newRetrier(c, client)
+	handler, err := getResponseHandler(c, rollbackHeaders, nil)
+	if err != nil {
+		return err
+	}
+	r := &rollbacker{
+		deleteIds: func(ids []string) ([]*soapforce.DeleteResult, error) {
+			var res []*soapforce.DeleteResult
+			err := retry.Do(func() error {
+				var err error
+				res, err = deleteIds(client, retry, ids)
+				return err
+			})
+			return res, err
+		},
+		undeleteIds: func(ids []string) ([]*soapforce.UndeleteResult, error) {
+			var res []*soapforce.UndeleteResult
+			err := retry.Do(func() error {
+				var err error
+				res, err = undeleteIds(client, retry, ids)
+				return err
+			})
+			return res, err
+		},
+		createSObjects: saveSObjects(retry, func(sobjects []*soapforce.SObject) ([]*soapforce.SaveResult, error) {
+			res, err := client.Create(sobjects)
+			return res, createOnce(err)
+		}),
+		updateSObjects: func(sobjects []*soapforce.SObject) ([]*soapforce.SaveResult, error) {
+			var res []*soapforce.SaveResult
+			err := retry.Do(func() error {
+				var err error
+				res, err = updateSObjects(client, retry, sobjects)
+				return err
+			})
+			return res, err
+		},
+		handler: handler,
+		out:     os.Stdout,
+	}
+	return r.Run(entries)
+}
+
+// Run reverts the entries in chunks of the same operation and type. A chunk
+// has each record once, since an API call cannot change a record twice.
+func (r *rollbacker) Run(entries []*journalEntry) error {
+	entries, unknown := resolveJournal(entries)
+	reverted := 0
+	failed := 0
+	seq := 0
+	for _, e := range unknown {
+		fmt.Fprintf(r.out, "%d records of %s may have been inserted, but cannot be rolled back since their Ids are not known (line %d)\n", e.Count, e.Type, e.line)
+		failed += e.Count
+	}
+	for end := len(entries); end > 0; {
+		first := entries[end-1]
+		chunk := []*journalEntry{}
+		ids := map[string]bool{}
+		for end > 0 && len(chunk) < dmlBatchSize {
+			e := entries[end-1]
+			if e.Operation != first.Operation || e.Type != first.Type || ids[e.Id] {
+				break
+			}
+			ids[e.Id] = true
+			chunk = append(chunk, e)
+			end--
+		}
+		batch := &dmlBatch{seq: seq}
+		seq++
+		for _, e := range chunk {
+			batch.rows = append(batch.rows, e.line)
+			batch.records = append(batch.records, []string{e.Operation, e.Type, e.Id})
+		}
+		ok, err := r.revert(batch, chunk)
+		if err != nil {
+			return err
+		}
+		reverted += ok
+		failed += len(chunk) - ok
+	}
+	fmt.Fprintf(r.out, "%d changes rolled back, %d failed\n", reverted, failed)
+	if failed > 0 {
+		return cli.NewExitError(fmt.Sprintf("%d changes failed to roll back", failed), 1)
+	}
+	return nil
+}
+
+// revert reverts the chunk and returns the number of the records reverted.
+func (r *rollbacker) revert(batch *dmlBatch, chunk []*journalEntry) (int, error) {
+	ids := make([]string, len(chunk))
+	for i, e := range chunk {
+		ids[i] = e.Id
+	}
+	ok := 0
+	switch chunk[0].Operation {
+	case "insert", "undelete":
+		res, err := r.deleteIds(ids)
+		if err != nil {
+			return 0, err
+		}
+		for _, result := range res {
+			if result != nil && result.Success {
+				ok++
+			}
+		}
+		return ok, r.handler.HandleDelete(batch, res)
+	case "delete":
+		res, err := r.undeleteIds(ids)
+		if err != nil {
+			return 0, err
+		}
+		res, err = r.recreate(chunk, res)
+		if err != nil {
+			return 0, err
+		}
+		for _, result := range res {
+			if result != nil && result.Success {
+				ok++
+			}
+		}
+		return ok, r.handler.HandleUndelete(batch, res)
+	default:
+		sobjects := make([]*soapforce.SObject, len(chunk))
+		for i, e := range chunk {
+			sobjects[i] = beforeImage(e)
+		}
+		res, err := r.updateSObjects(sobjects)
+		if err != nil {
+			return 0, err
+		}
+		for _, result := range res {
+			if result != nil && result.Success {
+				ok++
+			}
+		}
+		return ok, r.handler.Handle(batch, res)
+	}
+}
+
+// recreate creates the deleted records which failed to be undeleted, such as
+// the records deleted with --hard, from the values before the delete. The
+// results of the undelete are replaced by the results of the create, which
+// have the Ids of the new records. The uncertain deletes are not recreated,
+// since the records may not have been deleted.
+func (r *rollbacker) recreate(chunk []*journalEntry, res []*soapforce.UndeleteResult) ([]*soapforce.UndeleteResult, error) {
+	indexes := []int{}
+	sobjects := []*soapforce.SObject{}
+	for i, result := range res {
+		e := chunk[i]
+		if (result != nil && result.Success) || e.uncertain || len(e.Before) == 0 {
+			continue
+		}
+		sobject := beforeImage(e)
+		sobject.Id = ""
+		sobject.FieldsToNull = nil
+		indexes = append(indexes, i)
+		sobjects = append(sobjects, sobject)
+	}
+	if len(sobjects) == 0 {
+		return res, nil
+	}
+	created, err := r.createSObjects(sobjects)
+	if err != nil {
+		return nil, err
+	}
+	for i, result := range created {
+		if result != nil {
+			res[indexes[i]] = &soapforce.UndeleteResult{Id: result.Id, Success: result.Success, Errors: result.Errors}
+		}
+	}
+	return res, nil
+}
+
+// beforeImage returns the record with the values before the update. Empty
+// values were null, and are set to null.
+func beforeImage(e *journalEntry) *soapforce.SObject {
+	fields := map[string]interface{}{}
+	fieldsToNull := []string{}
+	for f, v := range e.Before {
+		if v == "" {
+			fieldsToNull = append(fieldsToNull, f)
+		} else {
+			fields[f] = v
+		}
+	}
+	sort.Strings(fieldsToNull)
+	return &soapforce.SObject{Type: e.Type, Id: e.Id, Fields: fields, FieldsToNull: fieldsToNull}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/tzmfreedom/go-soapforce"
+	"github.com/urfave/cli"
+)
+
+// defaultSessionTimeout is the default session timeout of Salesforce orgs.
+const defaultSessionTimeout = 2 * time.Hour
+
+// cachedSession is a session saved by the login command.
+type cachedSession struct {
+	Username    string    `json:"username"`
+	Endpoint    string    `json:"endpoint"`
+	InstanceUrl string    `json:"instanceUrl"`
+	SessionId   string    `json:"sessionId"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+
+	// RefreshToken of a web login is encrypted with the key of KeyPath.
+	RefreshToken string `json:"refreshToken,omitempty"`
+	ClientId     string `json:"clientId,omitempty"`
+	KeyPath      string `json:"keyPath,omitempty"`
+}
+
+// usable reports whether the session has not expired or can be refreshed.
+func (s *cachedSession) usable(now time.Time) bool {
+	return now.Before(s.ExpiresAt) || s.RefreshToken != ""
+}
+
+// sessionCache has the sessions of the logins, the most recent last.
+type sessionCache struct {
+	Sessions []*cachedSession `json:"sessions"`
+}
+
+// sessionCachePath returns $YASD_SESSION_FILE, or ~/.yasd/sessions.json.
+func sessionCachePath() (string, error) {
+	if path := os.Getenv("YASD_SESSION_FILE"); path != "" {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".yasd", "sessions.json"), nil
+}
+
+func loadSessionCache(path string) (*sessionCache, error) {
+	cache := &sessionCache{}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return cache, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, cache); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return cache, nil
+}
+
+// Save writes the cache readable only by the user, since it has the sessions.
+func (cache *sessionCache) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(cache, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0600)
+}
+
+// Find returns the session of the user on the endpoint which has not expired
+// or can be refreshed. Without username, the session of the last login is returned.
+func (cache *sessionCache) Find(username string, endpoint string, now time.Time) *cachedSession {
+	for i := len(cache.Sessions) - 1; i >= 0; i-- {
+		s := cache.Sessions[i]
+		if !strings.EqualFold(s.Endpoint, endpoint) || !s.usable(now) {
+			continue
+		}
+		if username == "" || strings.EqualFold(s.Username, username) {
+			return s
+		}
+	}
+	return nil
+}
+
+// Put adds the session as the most recent one, replacing the session of the
+// same user and endpoint and dropping the unusable ones.
+func (cache *sessionCache) Put(session *cachedSession, now time.Time) {
+	sessions := []*cachedSession{}
+	for _, s := range cache.Sessions {
+		same := strings.EqualFold(s.Username, session.Username) && strings.EqualFold(s.Endpoint, session.Endpoint)
+		if !same && s.usable(now) {
+			sessions = append(sessions, s)
+		}
+	}
+	cache.Sessions = append(sessions, session)
+}
+
+// Remove drops the session, which is rejected by the org.
+func (cache *sessionCache) Remove(session *cachedSession) {
+	sessions := []*cachedSession{}
+	for _, s := range cache.Sessions {
+		if s.SessionId != session.SessionId {
+			sessions = append(sessions, s)
+		}
+	}
+	cache.Sessions = sessions
+}
+
+// findCachedSession returns the cached session for the flags, if any.
+func findCachedSession(c *cli.Context) (*cachedSession, error) {
+	path, err := sessionCachePath()
+	if err != nil {
+		return nil, err
+	}
+	cache, err := loadSessionCache(path)
+	if err != nil {
+		return nil, err
+	}
+	return cache.Find(c.String("username"), c.String("endpoint"), time.Now()), nil
+}
+
+// updateSessionCache applies the update to the session cache file.
+func updateSessionCache(update func(cache *sessionCache)) error {
+	path, err := sessionCachePath()
+	if err != nil {
+		return err
+	}
+	cache, err := loadSessionCache(path)
+	if err != nil {
+		return err
+	}
+	update(cache)
+	return cache.Save(path)
+}
+
+// useCachedSession configures the client with the cached session. An expired
+// or rejected session is refreshed with its refresh token, if any, and
+// otherwise removed from the cache, and false is returned.
+func useCachedSession(client *soapforce.Client, c *cli.Context, session *cachedSession) (*soapforce.LoginResult, bool, error) {
+	apiVersion := c.String("api-version")
+	if time.Now().Before(session.ExpiresAt) {
+		res, err := useSession(client, session.InstanceUrl, session.SessionId, apiVersion)
+		if err == nil {
+			return res, true, nil
+		}
+		if !isInvalidSessionError(err) {
+			return nil, false, err
+		}
+	}
+	if session.RefreshToken == "" {
+		return nil, false, updateSessionCache(func(cache *sessionCache) {
+			cache.Remove(session)
+		})
+	}
+	if err := refreshCachedSession(c, session); err != nil {
+		return nil, false, err
+	}
+	res, err := useSession(client, session.InstanceUrl, session.SessionId, apiVersion)
+	if err != nil {
+		return nil, false, err
+	}
+	return res, true, nil
+}
+
+// refreshCachedSession gets a new access token of the session and caches it.
+func refreshCachedSession(c *cli.Context, session *cachedSession) error {
+	keypath := c.String("key")
+	if keypath == "" {
+		keypath = session.KeyPath
+	}
+	refreshToken, err := decryptCredential(keypath, session.RefreshToken)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt the refresh token of %s: %s", session.Username, err)
+	}
+	token, err := refreshOAuthToken(session.Endpoint, session.ClientId, c.String("client-secret"), refreshToken)
+	if err != nil {
+		return fmt.Errorf("failed to refresh the session of %s: %s, log in again with the login command", session.Username, err)
+	}
+	now := time.Now()
+	session.SessionId = token.AccessToken
+	session.InstanceUrl = token.InstanceUrl
+	session.ExpiresAt = now.Add(defaultSessionTimeout)
+	return updateSessionCache(func(cache *sessionCache) {
+		cache.Put(session, now)
+	})
+}
+
+// loginCommand authenticates with the flags, or on the browser with --web,
+// and caches the session for the following commands.
+func loginCommand(c *cli.Context) error {
+	if c.Bool("web") {
+		return loginWebCommand(c)
+	}
+	if err := validateCredentialFlag(c, "login"); err != nil {
+		return err
+	}
+	client := newClient(c)
+	res, err := authenticateWithFlags(client, c)
+	if err != nil {
+		return err
+	}
+	instanceUrl, err := getInstanceUrl(res.ServerUrl)
+	if err != nil {
+		return err
+	}
+	return cacheSession(&cachedSession{
+		Username:    c.String("username"),
+		Endpoint:    c.String("endpoint"),
+		InstanceUrl: instanceUrl,
+		SessionId:   res.SessionId,
+		ExpiresAt:   time.Now().Add(c.Duration("session-timeout")),
+	})
+}
+
+// loginWebCommand logs in with the OAuth web server flow, and caches the
+// session with the refresh token encrypted by --key.
+func loginWebCommand(c *cli.Context) error {
+	if err := validateWebLoginFlag(c); err != nil {
+		return err
+	}
+	key, err := readEncryptionKey(c.String("key"))
+	if err != nil {
+		return err
+	}
+	token, err := loginWeb(c.String("endpoint"), c.String("client-id"), c.String("client-secret"), c.Int("callback-port"))
+	if err != nil {
+		return err
+	}
+	if token.RefreshToken == "" {
+		return cli.NewExitError("refresh token is not returned, add the refresh_token scope to the connected app", 1)
+	}
+	refreshToken, err := encrypt([]byte(token.RefreshToken), key)
+	if err != nil {
+		return err
+	}
+	res, err := useSession(newClient(c), token.InstanceUrl, token.AccessToken, c.String("api-version"))
+	if err != nil {
+		return err
+	}
+	username := c.String("username")
+	if res.UserInfo != nil {
+		username = res.UserInfo.UserName
+	}
+	keypath, err := filepath.Abs(c.String("key"))
+	if err != nil {
+		return err
+	}
+	return cacheSession(&cachedSession{
+		Username:     username,
+		Endpoint:     c.String("endpoint"),
+		InstanceUrl:  token.InstanceUrl,
+		SessionId:    token.AccessToken,
+		ExpiresAt:    time.Now().Add(c.Duration("session-timeout")),
+		RefreshToken: refreshToken,
+		ClientId:     c.String("client-id"),
+		KeyPath:      keypath,
+	})
+}
+
+func validateWebLoginFlag(c *cli.Context) error {
+	if c.String("client-id") == "" {
+		_ = cli.ShowCommandHelp(c, "login")
+		return cli.NewExitError("client-id is required", 1)
+	}
+	if c.String("key") == "" {
+		_ = cli.ShowCommandHelp(c, "login")
+		return cli.NewExitError("key is required to encrypt the refresh token", 1)
+	}
+	if c.String("endpoint") == "" {
+		_ = cli.ShowCommandHelp(c, "login")
+		return cli.NewExitError("endpoint is required", 1)
+	}
+	return nil
+}
+
+func cacheSession(session *cachedSession) error {
+	err := updateSessionCache(func(cache *sessionCache) {
+		cache.Put(session, time.Now())
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Logged in to %s as %s, the session is cached until %s\n", session.InstanceUrl, session.Username, session.ExpiresAt.Format(time.RFC3339))
+	return nil
+}
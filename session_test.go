@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSessionCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "session")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "yasd", "sessions.json")
+
+	cache, err := loadSessionCache(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	cache.Put(&cachedSession{Username: "foo@example.com", Endpoint: "login.salesforce.com", SessionId: "expired", ExpiresAt: now.Add(-time.Minute)}, now)
+	cache.Put(&cachedSession{Username: "foo@example.com", Endpoint: "test.salesforce.com", SessionId: "sandbox", ExpiresAt: now.Add(time.Hour)}, now)
+	cache.Put(&cachedSession{Username: "bar@example.com", Endpoint: "login.salesforce.com", SessionId: "bar", ExpiresAt: now.Add(time.Hour)}, now)
+	cache.Put(&cachedSession{Username: "foo@example.com", Endpoint: "login.salesforce.com", SessionId: "foo", ExpiresAt: now.Add(2 * time.Hour)}, now)
+	if err := cache.Save(path); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("unexpected mode: %s", info.Mode())
+	}
+
+	cache, err = loadSessionCache(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(cache.Sessions) != 3 {
+		t.Fatalf("unexpected sessions: %v", cache.Sessions)
+	}
+	cases := []struct {
+		username string
+		endpoint string
+		now      time.Time
+		expected string
+	}{
+		{"foo@example.com", "login.salesforce.com", now, "foo"},
+		{"BAR@example.com", "login.salesforce.com", now, "bar"},
+		{"", "login.salesforce.com", now, "foo"},
+		{"", "test.salesforce.com", now, "sandbox"},
+		{"baz@example.com", "login.salesforce.com", now, ""},
+		{"bar@example.com", "login.salesforce.com", now.Add(time.Hour), ""},
+		{"", "login.salesforce.com", now.Add(90 * time.Minute), "foo"},
+	}
+	for _, c := range cases {
+		actual := ""
+		if s := cache.Find(c.username, c.endpoint, c.now); s != nil {
+			actual = s.SessionId
+		}
+		if actual != c.expected {
+			t.Fatalf("expected: '%s', but '%s'", c.expected, actual)
+		}
+	}
+
+	cache.Remove(cache.Find("foo@example.com", "login.salesforce.com", now))
+	if s := cache.Find("", "login.salesforce.com", now); s == nil || s.SessionId != "bar" {
+		t.Fatalf("unexpected session: %v", s)
+	}
+
+	// a session of the web login is refreshed after it expires
+	later := now.Add(24 * time.Hour)
+	cache.Put(&cachedSession{Username: "web@example.com", Endpoint: "login.salesforce.com", SessionId: "web", ExpiresAt: now.Add(time.Hour), RefreshToken: "encrypted"}, now)
+	cache.Put(&cachedSession{Username: "baz@example.com", Endpoint: "test.salesforce.com", SessionId: "baz", ExpiresAt: later.Add(time.Hour)}, later)
+	if len(cache.Sessions) != 2 || cache.Sessions[0].SessionId != "web" {
+		t.Fatalf("unexpected sessions: %v", cache.Sessions)
+	}
+	if s := cache.Find("", "login.salesforce.com", later); s == nil || s.SessionId != "web" {
+		t.Fatalf("unexpected session: %v", s)
+	}
+}
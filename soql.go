@@ -0,0 +1,1002 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+type soqlTokenType int
+
+const (
+	tokEOF soqlTokenType = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokDateTime
+	tokOperator
+	tokLParen
+	tokRParen
+	tokComma
+	tokColon
+	tokStar
+)
+
+var soqlTokenNames = map[soqlTokenType]string{
+	tokEOF:      "end of query",
+	tokIdent:    "identifier",
+	tokString:   "string",
+	tokNumber:   "number",
+	tokDateTime: "date",
+	tokOperator: "operator",
+	tokLParen:   "'('",
+	tokRParen:   "')'",
+	tokComma:    "','",
+	tokColon:    "':'",
+	tokStar:     "'*'",
+}
+
+type soqlToken struct {
+	typ  soqlTokenType
+	text string
+	pos  int
+}
+
+// is reports whether the token is the given keyword, compared case-insensitively.
+func (t *soqlToken) is(keyword string) bool {
+	return t.typ == tokIdent && strings.EqualFold(t.text, keyword)
+}
+
+func (t *soqlToken) String() string {
+	if t.typ == tokEOF {
+		return soqlTokenNames[tokEOF]
+	}
+	return fmt.Sprintf("'%s'", t.text)
+}
+
+// soqlSyntaxError points at the offending token of a query.
+type soqlSyntaxError struct {
+	query   string
+	pos     int
+	near    string
+	message string
+}
+
+func (e *soqlSyntaxError) Error() string {
+	line, column := 1, 1
+	for _, r := range e.query[:e.pos] {
+		if r == '\n' {
+			line++
+			column = 1
+		} else {
+			column++
+		}
+	}
+	return fmt.Sprintf("syntax error at line %d, column %d near %s: %s", line, column, e.near, e.message)
+}
+
+func lexSoql(q string) ([]*soqlToken, error) {
+	tokens := []*soqlToken{}
+	i := 0
+	for i < len(q) {
+		r, size := utf8.DecodeRuneInString(q[i:])
+		switch {
+		case unicode.IsSpace(r):
+			i += size
+		case r == '(':
+			tokens = append(tokens, &soqlToken{typ: tokLParen, text: "(", pos: i})
+			i++
+		case r == ')':
+			tokens = append(tokens, &soqlToken{typ: tokRParen, text: ")", pos: i})
+			i++
+		case r == ',':
+			tokens = append(tokens, &soqlToken{typ: tokComma, text: ",", pos: i})
+			i++
+		case r == ':':
+			tokens = append(tokens, &soqlToken{typ: tokColon, text: ":", pos: i})
+			i++
+		case r == '*':
+			tokens = append(tokens, &soqlToken{typ: tokStar, text: "*", pos: i})
+			i++
+		case r == '=':
+			tokens = append(tokens, &soqlToken{typ: tokOperator, text: "=", pos: i})
+			i++
+		case r == '!' || r == '<' || r == '>':
+			op := string(r)
+			if i+1 < len(q) && (q[i+1] == '=' || (r == '<' && q[i+1] == '>')) {
+				op = q[i : i+2]
+			}
+			if op == "!" {
+				return nil, &soqlSyntaxError{query: q, pos: i, near: "'!'", message: "unknown operator"}
+			}
+			tokens = append(tokens, &soqlToken{typ: tokOperator, text: op, pos: i})
+			i += len(op)
+		case r == '\'':
+			s, n, err := lexSoqlString(q, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, &soqlToken{typ: tokString, text: s, pos: i})
+			i += n
+		case unicode.IsDigit(r) || ((r == '-' || r == '+') && i+1 < len(q) && isAsciiDigit(q[i+1])):
+			typ, n := lexSoqlNumber(q[i:])
+			tokens = append(tokens, &soqlToken{typ: typ, text: q[i : i+n], pos: i})
+			i += n
+		case unicode.IsLetter(r) || r == '_':
+			j := i
+			for j < len(q) {
+				r, size := utf8.DecodeRuneInString(q[j:])
+				if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '.' {
+					break
+				}
+				j += size
+			}
+			tokens = append(tokens, &soqlToken{typ: tokIdent, text: q[i:j], pos: i})
+			i = j
+		default:
+			return nil, &soqlSyntaxError{query: q, pos: i, near: fmt.Sprintf("'%c'", r), message: "unexpected character"}
+		}
+	}
+	tokens = append(tokens, &soqlToken{typ: tokEOF, pos: len(q)})
+	return tokens, nil
+}
+
+// lexSoqlString returns the unescaped content of the quoted string starting at
+// q[start] and the number of bytes consumed including the quotes.
+func lexSoqlString(q string, start int) (string, int, error) {
+	var b strings.Builder
+	for i := start + 1; i < len(q); i++ {
+		switch q[i] {
+		case '\\':
+			if i+1 >= len(q) {
+				break
+			}
+			i++
+			switch q[i] {
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			default:
+				b.WriteByte(q[i])
+			}
+		case '\'':
+			return b.String(), i - start + 1, nil
+		default:
+			b.WriteByte(q[i])
+		}
+	}
+	return "", 0, &soqlSyntaxError{query: q, pos: start, near: "'''", message: "unterminated string literal"}
+}
+
+// lexSoqlNumber scans a number, date (2006-01-02) or datetime
+// (2006-01-02T15:04:05Z, 2006-01-02T15:04:05+09:00) literal.
+func lexSoqlNumber(s string) (soqlTokenType, int) {
+	isDate := len(s) >= 10 && isAsciiDigits(s[0:4]) && s[4] == '-' && isAsciiDigits(s[5:7]) && s[7] == '-' && isAsciiDigits(s[8:10])
+	if isDate {
+		n := 10
+		if n < len(s) && s[n] == 'T' {
+			n++
+			for n < len(s) && (isAsciiDigit(s[n]) || strings.IndexByte(":.+-Z", s[n]) >= 0) {
+				n++
+			}
+		}
+		return tokDateTime, n
+	}
+	n := 0
+	if s[0] == '-' || s[0] == '+' {
+		n++
+	}
+	for n < len(s) && (isAsciiDigit(s[n]) || s[n] == '.') {
+		n++
+	}
+	return tokNumber, n
+}
+
+func isAsciiDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isAsciiDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if !isAsciiDigit(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// soqlQuery is the syntax tree of a SOQL statement.
+type soqlQuery struct {
+	Fields    []*soqlSelectItem
+	From      string
+	FromAlias string
+	Scope     string
+	Where     soqlCondition
+	With      string
+	GroupBy   []*soqlExpr
+	Having    soqlCondition
+	OrderBy   []*soqlOrderItem
+	Limit     int
+	Offset    int
+	For       []string
+
+	// Star is set for the non-standard "SELECT * FROM" form which is expanded by buildQuery.
+	Star bool
+	// selectStart and selectEnd are byte offsets of the select list (up to the
+	// FROM keyword) in the source query.
+	selectStart int
+	selectEnd   int
+}
+
+// soqlSelectItem is one entry of a select list. Exactly one of Expr, Subquery
+// and TypeOf is set.
+type soqlSelectItem struct {
+	Expr     *soqlExpr
+	Alias    string
+	Subquery *soqlQuery
+	TypeOf   *soqlTypeOf
+}
+
+// soqlExpr is a field path, a function call or a literal.
+type soqlExpr struct {
+	Field   string
+	Func    string
+	Args    []*soqlExpr
+	Literal *soqlValue
+}
+
+type soqlTypeOf struct {
+	Field string
+	Whens []*soqlTypeOfWhen
+	Else  []string
+}
+
+type soqlTypeOfWhen struct {
+	Type   string
+	Fields []string
+}
+
+type soqlOrderItem struct {
+	Expr       *soqlExpr
+	Descending bool
+	NullsLast  bool
+}
+
+type soqlValueType int
+
+const (
+	soqlStringValue soqlValueType = iota
+	soqlNumberValue
+	soqlDateValue
+	soqlDateLiteralValue
+	soqlBooleanValue
+	soqlNullValue
+	soqlBindValue
+)
+
+type soqlValue struct {
+	Type soqlValueType
+	Text string
+}
+
+type soqlCondition interface {
+	soqlCondition()
+}
+
+// soqlLogicalCondition joins two conditions with AND or OR.
+type soqlLogicalCondition struct {
+	Op    string
+	Left  soqlCondition
+	Right soqlCondition
+}
+
+type soqlNotCondition struct {
+	Condition soqlCondition
+}
+
+// soqlComparison compares an expression with a value, a list of values
+// (IN, NOT IN, INCLUDES, EXCLUDES) or a semi-join subquery.
+type soqlComparison struct {
+	Expr     *soqlExpr
+	Op       string
+	Value    *soqlValue
+	Values   []*soqlValue
+	Subquery *soqlQuery
+}
+
+func (c *soqlLogicalCondition) soqlCondition() {}
+func (c *soqlNotCondition) soqlCondition()     {}
+func (c *soqlComparison) soqlCondition()       {}
+
+var soqlAggregateFunctions = map[string]bool{
+	"AVG":              true,
+	"COUNT":            true,
+	"COUNT_DISTINCT":   true,
+	"MIN":              true,
+	"MAX":              true,
+	"SUM":              true,
+	"GROUPING":         true,
+	"CALENDAR_MONTH":   true,
+	"CALENDAR_QUARTER": true,
+	"CALENDAR_YEAR":    true,
+	"DAY_IN_MONTH":     true,
+	"DAY_IN_WEEK":      true,
+	"DAY_IN_YEAR":      true,
+	"DAY_ONLY":         true,
+	"FISCAL_MONTH":     true,
+	"FISCAL_QUARTER":   true,
+	"FISCAL_YEAR":      true,
+	"HOUR_IN_DAY":      true,
+	"WEEK_IN_MONTH":    true,
+	"WEEK_IN_YEAR":     true,
+	"DISTANCE":         true,
+	"GEOLOCATION":      true,
+}
+
+// soqlClauseKeywords terminate a select list item and cannot be used as an alias.
+var soqlClauseKeywords = map[string]bool{
+	"SELECT": true,
+	"FROM":   true,
+	"WHERE":  true,
+	"WITH":   true,
+	"GROUP":  true,
+	"HAVING": true,
+	"ORDER":  true,
+	"LIMIT":  true,
+	"OFFSET": true,
+	"FOR":    true,
+	"USING":  true,
+	"ALL":    true,
+	"AND":    true,
+	"OR":     true,
+	"NOT":    true,
+	"UPDATE": true,
+}
+
+type soqlParser struct {
+	query  string
+	tokens []*soqlToken
+	pos    int
+}
+
+func parseSoql(q string) (*soqlQuery, error) {
+	tokens, err := lexSoql(q)
+	if err != nil {
+		return nil, err
+	}
+	p := &soqlParser{query: q, tokens: tokens}
+	query, err := p.parseQuery()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().typ != tokEOF {
+		return nil, p.errorf("unexpected token after end of query")
+	}
+	return query, nil
+}
+
+func (p *soqlParser) peek() *soqlToken {
+	return p.tokens[p.pos]
+}
+
+func (p *soqlParser) peekAt(offset int) *soqlToken {
+	if p.pos+offset >= len(p.tokens) {
+		return p.tokens[len(p.tokens)-1]
+	}
+	return p.tokens[p.pos+offset]
+}
+
+func (p *soqlParser) next() *soqlToken {
+	t := p.tokens[p.pos]
+	if t.typ != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *soqlParser) errorf(format string, a ...interface{}) error {
+	t := p.peek()
+	return &soqlSyntaxError{query: p.query, pos: t.pos, near: t.String(), message: fmt.Sprintf(format, a...)}
+}
+
+func (p *soqlParser) expect(typ soqlTokenType) (*soqlToken, error) {
+	if p.peek().typ != typ {
+		return nil, p.errorf("expected %s", soqlTokenNames[typ])
+	}
+	return p.next(), nil
+}
+
+func (p *soqlParser) expectKeyword(keyword string) error {
+	if !p.peek().is(keyword) {
+		return p.errorf("expected %s", keyword)
+	}
+	p.next()
+	return nil
+}
+
+func (p *soqlParser) acceptKeyword(keyword string) bool {
+	if p.peek().is(keyword) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *soqlParser) parseQuery() (*soqlQuery, error) {
+	q := &soqlQuery{Limit: -1, Offset: -1}
+	if err := p.expectKeyword("SELECT"); err != nil {
+		return nil, err
+	}
+	q.selectStart = p.peek().pos
+	if p.peek().typ == tokStar {
+		p.next()
+		q.Star = true
+	} else {
+		for {
+			item, err := p.parseSelectItem()
+			if err != nil {
+				return nil, err
+			}
+			q.Fields = append(q.Fields, item)
+			if p.peek().typ != tokComma {
+				break
+			}
+			p.next()
+		}
+	}
+	q.selectEnd = p.peek().pos
+
+	if err := p.expectKeyword("FROM"); err != nil {
+		return nil, err
+	}
+	from, err := p.expect(tokIdent)
+	if err != nil {
+		return nil, err
+	}
+	q.From = from.text
+	if t := p.peek(); t.typ == tokIdent && !soqlClauseKeywords[strings.ToUpper(t.text)] {
+		q.FromAlias = p.next().text
+	}
+
+	if p.acceptKeyword("USING") {
+		if err := p.expectKeyword("SCOPE"); err != nil {
+			return nil, err
+		}
+		scope, err := p.expect(tokIdent)
+		if err != nil {
+			return nil, err
+		}
+		q.Scope = scope.text
+	}
+	if p.acceptKeyword("WHERE") {
+		if q.Where, err = p.parseCondition(); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptKeyword("WITH") {
+		with, err := p.expect(tokIdent)
+		if err != nil {
+			return nil, err
+		}
+		q.With = with.text
+	}
+	if p.acceptKeyword("GROUP") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		if q.GroupBy, err = p.parseExprList(); err != nil {
+			return nil, err
+		}
+		if p.acceptKeyword("HAVING") {
+			if q.Having, err = p.parseCondition(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if p.acceptKeyword("ORDER") {
+		if err := p.expectKeyword("BY"); err != nil {
+			return nil, err
+		}
+		if q.OrderBy, err = p.parseOrderBy(); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptKeyword("LIMIT") {
+		if q.Limit, err = p.parseInteger(); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptKeyword("OFFSET") {
+		if q.Offset, err = p.parseInteger(); err != nil {
+			return nil, err
+		}
+	}
+	if p.acceptKeyword("FOR") {
+		for {
+			t, err := p.expect(tokIdent)
+			if err != nil {
+				return nil, err
+			}
+			q.For = append(q.For, strings.ToUpper(t.text))
+			if p.peek().typ != tokComma {
+				break
+			}
+			p.next()
+		}
+	}
+	if p.acceptKeyword("ALL") {
+		if err := p.expectKeyword("ROWS"); err != nil {
+			return nil, err
+		}
+	}
+	return q, nil
+}
+
+func (p *soqlParser) parseSelectItem() (*soqlSelectItem, error) {
+	t := p.peek()
+	if t.typ == tokLParen {
+		p.next()
+		subquery, err := p.parseQuery()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokRParen); err != nil {
+			return nil, err
+		}
+		return &soqlSelectItem{Subquery: subquery}, nil
+	}
+	if t.is("TYPEOF") {
+		p.next()
+		typeOf, err := p.parseTypeOf()
+		if err != nil {
+			return nil, err
+		}
+		return &soqlSelectItem{TypeOf: typeOf}, nil
+	}
+	if t.typ != tokIdent || soqlClauseKeywords[strings.ToUpper(t.text)] {
+		return nil, p.errorf("expected field name")
+	}
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	item := &soqlSelectItem{Expr: expr}
+	if t := p.peek(); t.typ == tokIdent && !soqlClauseKeywords[strings.ToUpper(t.text)] {
+		if expr.Func == "" {
+			return nil, p.errorf("alias is only allowed for functions")
+		}
+		item.Alias = p.next().text
+	}
+	return item, nil
+}
+
+func (p *soqlParser) parseTypeOf() (*soqlTypeOf, error) {
+	field, err := p.expect(tokIdent)
+	if err != nil {
+		return nil, err
+	}
+	typeOf := &soqlTypeOf{Field: field.text}
+	for p.acceptKeyword("WHEN") {
+		typ, err := p.expect(tokIdent)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expectKeyword("THEN"); err != nil {
+			return nil, err
+		}
+		fields, err := p.parseFieldList()
+		if err != nil {
+			return nil, err
+		}
+		typeOf.Whens = append(typeOf.Whens, &soqlTypeOfWhen{Type: typ.text, Fields: fields})
+	}
+	if len(typeOf.Whens) == 0 {
+		return nil, p.errorf("expected WHEN")
+	}
+	if p.acceptKeyword("ELSE") {
+		if typeOf.Else, err = p.parseFieldList(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expectKeyword("END"); err != nil {
+		return nil, err
+	}
+	return typeOf, nil
+}
+
+func (p *soqlParser) parseFieldList() ([]string, error) {
+	fields := []string{}
+	for {
+		if soqlClauseKeywords[strings.ToUpper(p.peek().text)] {
+			return nil, p.errorf("expected field name")
+		}
+		t, err := p.expect(tokIdent)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, t.text)
+		if p.peek().typ != tokComma || p.peekAt(1).is("WHEN") || p.peekAt(1).is("ELSE") {
+			break
+		}
+		p.next()
+	}
+	return fields, nil
+}
+
+// parseExpr parses a field path or a function call such as FORMAT(MIN(Amount)).
+func (p *soqlParser) parseExpr() (*soqlExpr, error) {
+	t, err := p.expect(tokIdent)
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().typ != tokLParen {
+		return &soqlExpr{Field: t.text}, nil
+	}
+	p.next()
+	expr := &soqlExpr{Func: t.text, Args: []*soqlExpr{}}
+	if p.peek().typ == tokRParen {
+		p.next()
+		return expr, nil
+	}
+	for {
+		var arg *soqlExpr
+		if p.peek().typ == tokIdent && !p.peek().is("NULL") && !p.peek().is("TRUE") && !p.peek().is("FALSE") {
+			if arg, err = p.parseExpr(); err != nil {
+				return nil, err
+			}
+		} else {
+			v, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			arg = &soqlExpr{Literal: v}
+		}
+		expr.Args = append(expr.Args, arg)
+		if p.peek().typ != tokComma {
+			break
+		}
+		p.next()
+	}
+	if _, err := p.expect(tokRParen); err != nil {
+		return nil, err
+	}
+	return expr, nil
+}
+
+func (p *soqlParser) parseExprList() ([]*soqlExpr, error) {
+	exprs := []*soqlExpr{}
+	for {
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+		if p.peek().typ != tokComma {
+			return exprs, nil
+		}
+		p.next()
+	}
+}
+
+func (p *soqlParser) parseOrderBy() ([]*soqlOrderItem, error) {
+	items := []*soqlOrderItem{}
+	for {
+		expr, err := p.parseExpr()
+		if err != nil {
+			return nil, err
+		}
+		item := &soqlOrderItem{Expr: expr}
+		if p.acceptKeyword("DESC") {
+			item.Descending = true
+		} else {
+			p.acceptKeyword("ASC")
+		}
+		if p.acceptKeyword("NULLS") {
+			if p.acceptKeyword("LAST") {
+				item.NullsLast = true
+			} else if err := p.expectKeyword("FIRST"); err != nil {
+				return nil, err
+			}
+		}
+		items = append(items, item)
+		if p.peek().typ != tokComma {
+			return items, nil
+		}
+		p.next()
+	}
+}
+
+func (p *soqlParser) parseInteger() (int, error) {
+	t := p.peek()
+	if t.typ != tokNumber {
+		return 0, p.errorf("expected integer")
+	}
+	n, err := strconv.Atoi(t.text)
+	if err != nil {
+		return 0, p.errorf("expected integer")
+	}
+	p.next()
+	return n, nil
+}
+
+func (p *soqlParser) parseCondition() (soqlCondition, error) {
+	left, err := p.parseAndCondition()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKeyword("OR") {
+		right, err := p.parseAndCondition()
+		if err != nil {
+			return nil, err
+		}
+		left = &soqlLogicalCondition{Op: "OR", Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *soqlParser) parseAndCondition() (soqlCondition, error) {
+	left, err := p.parseNotCondition()
+	if err != nil {
+		return nil, err
+	}
+	for p.acceptKeyword("AND") {
+		right, err := p.parseNotCondition()
+		if err != nil {
+			return nil, err
+		}
+		left = &soqlLogicalCondition{Op: "AND", Left: left, Right: right}
+	}
+	return left, nil
+}
+
+func (p *soqlParser) parseNotCondition() (soqlCondition, error) {
+	if p.acceptKeyword("NOT") {
+		c, err := p.parseNotCondition()
+		if err != nil {
+			return nil, err
+		}
+		return &soqlNotCondition{Condition: c}, nil
+	}
+	if p.peek().typ == tokLParen {
+		p.next()
+		c, err := p.parseCondition()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(tokRParen); err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *soqlParser) parseComparison() (soqlCondition, error) {
+	if t := p.peek(); t.typ != tokIdent || soqlClauseKeywords[strings.ToUpper(t.text)] {
+		return nil, p.errorf("expected field name")
+	}
+	expr, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	c := &soqlComparison{Expr: expr}
+	t := p.peek()
+	switch {
+	case t.typ == tokOperator:
+		c.Op = p.next().text
+	case t.is("LIKE"):
+		p.next()
+		c.Op = "LIKE"
+	case t.is("IN"), t.is("INCLUDES"), t.is("EXCLUDES"):
+		c.Op = strings.ToUpper(p.next().text)
+		return p.parseInOperand(c)
+	case t.is("NOT") && p.peekAt(1).is("IN"):
+		p.next()
+		p.next()
+		c.Op = "NOT IN"
+		return p.parseInOperand(c)
+	default:
+		return nil, p.errorf("expected comparison operator")
+	}
+	if c.Value, err = p.parseValue(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (p *soqlParser) parseInOperand(c *soqlComparison) (soqlCondition, error) {
+	if p.peek().typ == tokColon {
+		v, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		c.Value = v
+		return c, nil
+	}
+	if _, err := p.expect(tokLParen); err != nil {
+		return nil, err
+	}
+	if p.peek().is("SELECT") {
+		subquery, err := p.parseQuery()
+		if err != nil {
+			return nil, err
+		}
+		c.Subquery = subquery
+	} else {
+		for {
+			v, err := p.parseValue()
+			if err != nil {
+				return nil, err
+			}
+			c.Values = append(c.Values, v)
+			if p.peek().typ != tokComma {
+				break
+			}
+			p.next()
+		}
+	}
+	if _, err := p.expect(tokRParen); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (p *soqlParser) parseValue() (*soqlValue, error) {
+	t := p.peek()
+	switch t.typ {
+	case tokString:
+		p.next()
+		return &soqlValue{Type: soqlStringValue, Text: t.text}, nil
+	case tokNumber:
+		p.next()
+		return &soqlValue{Type: soqlNumberValue, Text: t.text}, nil
+	case tokDateTime:
+		p.next()
+		return &soqlValue{Type: soqlDateValue, Text: t.text}, nil
+	case tokColon:
+		p.next()
+		name, err := p.expect(tokIdent)
+		if err != nil {
+			return nil, err
+		}
+		return &soqlValue{Type: soqlBindValue, Text: name.text}, nil
+	case tokIdent:
+		switch {
+		case t.is("NULL"):
+			p.next()
+			return &soqlValue{Type: soqlNullValue, Text: "null"}, nil
+		case t.is("TRUE"), t.is("FALSE"):
+			p.next()
+			return &soqlValue{Type: soqlBooleanValue, Text: strings.ToLower(t.text)}, nil
+		case soqlClauseKeywords[strings.ToUpper(t.text)]:
+			return nil, p.errorf("expected value")
+		}
+		p.next()
+		text := t.text
+		if p.peek().typ == tokColon {
+			p.next()
+			n, err := p.expect(tokNumber)
+			if err != nil {
+				return nil, err
+			}
+			text += ":" + n.text
+		}
+		return &soqlValue{Type: soqlDateLiteralValue, Text: text}, nil
+	}
+	return nil, p.errorf("expected value")
+}
+
+// Columns returns the field names of the records returned for the query, in
+// select list order. Unaliased aggregate functions are named expr0, expr1, ...
+// as in the API response.
+func (q *soqlQuery) Columns() []string {
+	columns := []string{}
+	seen := map[string]bool{}
+	add := func(column string) {
+		if !seen[strings.ToLower(column)] {
+			seen[strings.ToLower(column)] = true
+			columns = append(columns, column)
+		}
+	}
+	exprCount := 0
+	for _, item := range q.Fields {
+		switch {
+		case item.Subquery != nil:
+			add(item.Subquery.From)
+		case item.TypeOf != nil:
+			for _, when := range item.TypeOf.Whens {
+				for _, f := range when.Fields {
+					add(item.TypeOf.Field + "." + f)
+				}
+			}
+			for _, f := range item.TypeOf.Else {
+				add(item.TypeOf.Field + "." + f)
+			}
+		case item.Alias != "":
+			add(item.Alias)
+		case item.Expr.isAggregate():
+			add(fmt.Sprintf("expr%d", exprCount))
+			exprCount++
+		default:
+			if f := item.Expr.fieldName(); f != "" {
+				add(q.trimAlias(f))
+			}
+		}
+	}
+	return columns
+}
+
+var (
+	errSoqlAggregate = errors.New("aggregate queries do not return records")
+	errSoqlNoId      = errors.New("Id is required in the select list")
+)
+
+// validateRecords checks that the query returns records, not aggregate
+// results, with their Ids. The subqueries are not checked.
+func (q *soqlQuery) validateRecords() error {
+	if len(q.GroupBy) > 0 {
+		return errSoqlAggregate
+	}
+	hasId := q.Star
+	for _, item := range q.Fields {
+		if item.Expr == nil {
+			continue
+		}
+		if item.Expr.isAggregate() {
+			return errSoqlAggregate
+		}
+		if strings.EqualFold(q.trimAlias(item.Expr.Field), "Id") {
+			hasId = true
+		}
+	}
+	if !hasId {
+		return errSoqlNoId
+	}
+	return nil
+}
+
+func (q *soqlQuery) trimAlias(field string) string {
+	if q.FromAlias != "" && strings.HasPrefix(strings.ToLower(field), strings.ToLower(q.FromAlias)+".") {
+		return field[len(q.FromAlias)+1:]
+	}
+	return field
+}
+
+func (e *soqlExpr) isAggregate() bool {
+	if e.Func == "" {
+		return false
+	}
+	if soqlAggregateFunctions[strings.ToUpper(e.Func)] {
+		return true
+	}
+	for _, arg := range e.Args {
+		if arg.isAggregate() {
+			return true
+		}
+	}
+	return false
+}
+
+// fieldName returns the field that a non-aggregate expression is reported
+// under, e.g. Status for toLabel(Status).
+func (e *soqlExpr) fieldName() string {
+	if e.Func == "" {
+		return e.Field
+	}
+	for _, arg := range e.Args {
+		if arg.Literal == nil {
+			return arg.fieldName()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSoqlColumns(t *testing.T) {
+	testCases := []struct {
+		query    string
+		expected []string
+	}{
+		{
+			"SELECT Id, Name, Account.Owner.Name FROM Contact",
+			[]string{"Id", "Name", "Account.Owner.Name"},
+		},
+		{
+			"select id,name from account where name = 'SELECT x FROM y' limit 10",
+			[]string{"id", "name"},
+		},
+		{
+			"SELECT COUNT(Id), MAX(Amount) maxAmount, SUM(Amount), StageName FROM Opportunity GROUP BY StageName",
+			[]string{"expr0", "maxAmount", "expr1", "StageName"},
+		},
+		{
+			"SELECT toLabel(Status), FORMAT(Amount) amt, convertCurrency(AnnualRevenue) FROM Lead",
+			[]string{"Status", "amt", "AnnualRevenue"},
+		},
+		{
+			"SELECT FORMAT(MIN(Amount)) FROM Opportunity",
+			[]string{"expr0"},
+		},
+		{
+			"SELECT Id, (SELECT Id, Email FROM Contacts) FROM Account",
+			[]string{"Id", "Contacts"},
+		},
+		{
+			"SELECT TYPEOF What WHEN Account THEN Phone, Name WHEN Opportunity THEN Amount ELSE Name END, Subject FROM Event",
+			[]string{"What.Phone", "What.Name", "What.Amount", "Subject"},
+		},
+		{
+			"SELECT a.Name, a.Owner.Name FROM Account a",
+			[]string{"Name", "Owner.Name"},
+		},
+	}
+	for _, testCase := range testCases {
+		q, err := parseSoql(testCase.query)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		actual := q.Columns()
+		if !reflect.DeepEqual(actual, testCase.expected) {
+			t.Fatalf("expected: '%v', but '%v'", testCase.expected, actual)
+		}
+	}
+}
+
+func TestParseSoqlClauses(t *testing.T) {
+	query := `SELECT Name FROM Lead
+WHERE (Status IN ('Open', 'Working') OR IsConverted = false)
+  AND CreatedDate < LAST_N_DAYS:365
+  AND LastModifiedDate >= 2020-01-01T00:00:00Z
+  AND Id NOT IN (SELECT WhoId FROM Task)
+ORDER BY Name DESC NULLS LAST, CreatedDate
+LIMIT 100 OFFSET 20`
+	q, err := parseSoql(query)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if q.From != "Lead" {
+		t.Fatalf("expected '%s', but '%s'", "Lead", q.From)
+	}
+	if q.Limit != 100 || q.Offset != 20 {
+		t.Fatalf("expected limit 100 offset 20, but limit %d offset %d", q.Limit, q.Offset)
+	}
+	if len(q.OrderBy) != 2 || !q.OrderBy[0].Descending || !q.OrderBy[0].NullsLast {
+		t.Fatalf("unexpected order by: %v", q.OrderBy)
+	}
+	and, ok := q.Where.(*soqlLogicalCondition)
+	if !ok || and.Op != "AND" {
+		t.Fatalf("unexpected where: %v", q.Where)
+	}
+	semiJoin, ok := and.Right.(*soqlComparison)
+	if !ok || semiJoin.Op != "NOT IN" || semiJoin.Subquery == nil || semiJoin.Subquery.From != "Task" {
+		t.Fatalf("unexpected semi join: %v", and.Right)
+	}
+}
+
+func TestParseSoqlStar(t *testing.T) {
+	query := "SELECT * FROM Account WHERE Name != null"
+	q, err := parseSoql(query)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !q.Star {
+		t.Fatal("expected star query")
+	}
+	actual := query[:q.selectStart] + "Id,Name " + query[q.selectEnd:]
+	expected := "SELECT Id,Name FROM Account WHERE Name != null"
+	if actual != expected {
+		t.Fatalf("expected '%s', but '%s'", expected, actual)
+	}
+}
+
+func TestParseSoqlError(t *testing.T) {
+	testCases := []struct {
+		query    string
+		expected string
+	}{
+		{
+			"SELECT Id Name FROM Account",
+			"syntax error at line 1, column 11 near 'Name': alias is only allowed for functions",
+		},
+		{
+			"SELECT Id, FROM Account",
+			"syntax error at line 1, column 12 near 'FROM': expected field name",
+		},
+		{
+			"SELECT Id FROM Account\nWHERE Name = 'abc",
+			"syntax error at line 2, column 14 near ''': unterminated string literal",
+		},
+		{
+			"SELECT Id FROM Account WHERE Name",
+			"syntax error at line 1, column 34 near end of query: expected comparison operator",
+		},
+		{
+			"SELECT Id FROM Account LIMIT ten",
+			"syntax error at line 1, column 30 near 'ten': expected integer",
+		},
+	}
+	for _, testCase := range testCases {
+		_, err := parseSoql(testCase.query)
+		if err == nil {
+			t.Fatalf("expected error for '%s', but nil", testCase.query)
+		}
+		if err.Error() != testCase.expected {
+			t.Fatalf("expected: '%s', but '%s'", testCase.expected, err.Error())
+		}
+	}
+}
+
+func TestSoqlValidateRecords(t *testing.T) {
+	testCases := []struct {
+		query    string
+		expected error
+	}{
+		{"SELECT Id, Name FROM Account", nil},
+		{"SELECT a.Id FROM Account a", nil},
+		{"SELECT * FROM Account", nil},
+		{"SELECT Id, (SELECT LastName FROM Contacts) FROM Account", nil},
+		{"SELECT Name FROM Account", errSoqlNoId},
+		{"SELECT COUNT(Id) FROM Account", errSoqlAggregate},
+		{"SELECT Id FROM Account GROUP BY Id", errSoqlAggregate},
+	}
+	for _, testCase := range testCases {
+		soql, err := parseSoql(testCase.query)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err := soql.validateRecords(); err != testCase.expected {
+			t.Fatalf("%s: expected: '%v', but '%v'", testCase.query, testCase.expected, err)
+		}
+	}
+}
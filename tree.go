@@ -0,0 +1,757 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/tzmfreedom/go-soapforce"
+	"github.com/urfave/cli"
+)
+
+// A tree document has the records of parent-to-child queries with the
+// children nested in their parents, and references between the records
+// written as @ followed by the reference Id of the record instead of its Id:
+//
+//   {"records": [{"attributes": {"type": "Account", "referenceId": "AccountRef1"},
+//     "Name": "Acme", "Contacts": {"records": [...]}}]}
+//
+// The children of a relationship are linked to their parent on import, so
+// the lookup field to the parent can be omitted.
+
+const treeReferencePrefix = "@"
+
+type treeDocument struct {
+	Records []*treeRecord `json:"records"`
+}
+
+type treeRecord struct {
+	Type        string
+	ReferenceId string
+	// Fields are the names of Values in order.
+	Fields   []string
+	Values   map[string]string
+	Children []*treeChildren
+
+	// id is the Id of the exported record.
+	id string
+}
+
+type treeChildren struct {
+	Relationship string
+	Records      []*treeRecord
+}
+
+type treeAttributes struct {
+	Type        string `json:"type"`
+	ReferenceId string `json:"referenceId"`
+}
+
+func (r *treeRecord) MarshalJSON() ([]byte, error) {
+	buf := &bytes.Buffer{}
+	buf.WriteString("{")
+	write := func(key string, value interface{}) error {
+		if buf.Len() > 1 {
+			buf.WriteString(",")
+		}
+		k, err := json.Marshal(key)
+		if err != nil {
+			return err
+		}
+		v, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		buf.Write(k)
+		buf.WriteString(":")
+		buf.Write(v)
+		return nil
+	}
+	if err := write("attributes", &treeAttributes{Type: r.Type, ReferenceId: r.ReferenceId}); err != nil {
+		return nil, err
+	}
+	for _, f := range r.Fields {
+		if err := write(f, r.Values[f]); err != nil {
+			return nil, err
+		}
+	}
+	for _, children := range r.Children {
+		if err := write(children.Relationship, &treeDocument{Records: children.Records}); err != nil {
+			return nil, err
+		}
+	}
+	buf.WriteString("}")
+	return buf.Bytes(), nil
+}
+
+func (r *treeRecord) UnmarshalJSON(b []byte) error {
+	raw := map[string]json.RawMessage{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	attributes := &treeAttributes{}
+	if a, ok := raw["attributes"]; ok {
+		if err := json.Unmarshal(a, attributes); err != nil {
+			return fmt.Errorf("attributes: %s", err)
+		}
+	}
+	if attributes.Type == "" || attributes.ReferenceId == "" {
+		return fmt.Errorf("attributes.type and attributes.referenceId are required")
+	}
+	r.Type = attributes.Type
+	r.ReferenceId = attributes.ReferenceId
+	r.Values = map[string]string{}
+	keys := []string{}
+	for k := range raw {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if k == "attributes" {
+			continue
+		}
+		var v interface{}
+		d := json.NewDecoder(bytes.NewReader(raw[k]))
+		d.UseNumber()
+		if err := d.Decode(&v); err != nil {
+			return err
+		}
+		switch value := v.(type) {
+		case nil:
+		case map[string]interface{}:
+			children := &treeDocument{}
+			if err := json.Unmarshal(raw[k], children); err != nil {
+				return fmt.Errorf("%s: %s", k, err)
+			}
+			r.Children = append(r.Children, &treeChildren{Relationship: k, Records: children.Records})
+		default:
+			r.Fields = append(r.Fields, k)
+			r.Values[k] = stringifyValue(value)
+		}
+	}
+	return nil
+}
+
+// TreeWriter writes the records of the queries as a tree document. The
+// document is written on Close, when the Ids of every exported record are
+// known and can be replaced with the reference Ids.
+type TreeWriter struct {
+	w       io.Writer
+	fp      *os.File
+	soql    *soqlQuery
+	records []*treeRecord
+	// describe returns the fields of the objects, to find the lookup fields
+	// whose Ids are not in the document.
+	describe func(object string) (*soapforce.DescribeSObjectResult, error)
+	// warnings has the lookup fields which are not written.
+	warnings io.Writer
+}
+
+func newTreeWriter(w io.Writer, describe func(object string) (*soapforce.DescribeSObjectResult, error)) *TreeWriter {
+	return &TreeWriter{w: w, describe: describe, warnings: os.Stderr}
+}
+
+// Query sets the query of the records written next.
+func (w *TreeWriter) Query(soql *soqlQuery) {
+	w.soql = soql
+}
+
+func (w *TreeWriter) Header(headers []string) error {
+	return nil
+}
+
+func (w *TreeWriter) Write(headers []string, record *soapforce.SObject) error {
+	w.records = append(w.records, newTreeRecord(w.soql, record))
+	return nil
+}
+
+func newTreeRecord(soql *soqlQuery, record *soapforce.SObject) *treeRecord {
+	t := &treeRecord{Type: record.Type, id: record.Id, Values: map[string]string{}}
+	if t.Type == "" {
+		t.Type = soql.From
+	}
+	values := newCaseInsensitiveMap(record.Fields)
+	for _, item := range soql.Fields {
+		if item.Subquery != nil {
+			qr, ok := values.Get(item.Subquery.From).(*soapforce.QueryResult)
+			if !ok || qr == nil || len(qr.Records) == 0 {
+				continue
+			}
+			children := &treeChildren{Relationship: item.Subquery.From}
+			for _, child := range qr.Records {
+				children.Records = append(children.Records, newTreeRecord(item.Subquery, child))
+			}
+			t.Children = append(t.Children, children)
+			continue
+		}
+		if item.Expr == nil || item.Alias != "" {
+			continue
+		}
+		f := soql.trimAlias(item.Expr.fieldName())
+		if f == "" || strings.Contains(f, ".") || strings.EqualFold(f, "Id") {
+			continue
+		}
+		if v := getField(values, f); v != "" {
+			t.Fields = append(t.Fields, f)
+			t.Values[f] = v
+		}
+	}
+	return t
+}
+
+func (w *TreeWriter) Close() error {
+	refs := map[string]string{}
+	counts := map[string]int{}
+	walkTree(w.records, func(r *treeRecord) {
+		counts[r.Type]++
+		r.ReferenceId = fmt.Sprintf("%sRef%d", r.Type, counts[r.Type])
+		if r.id != "" {
+			refs[r.id] = r.ReferenceId
+		}
+	})
+	walkTree(w.records, func(r *treeRecord) {
+		for _, f := range r.Fields {
+			if ref, ok := refs[r.Values[f]]; ok {
+				r.Values[f] = treeReferencePrefix + ref
+			}
+		}
+	})
+	if err := w.dropUnresolved(); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(&treeDocument{Records: w.records}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if _, err := w.w.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	if w.fp != nil {
+		return w.fp.Close()
+	}
+	return nil
+}
+
+// dropUnresolved removes the lookup fields whose Ids are not records of the
+// document, such as OwnerId and RecordTypeId, since the Ids are not valid in
+// the org the document is imported to. The dropped fields are warned.
+func (w *TreeWriter) dropUnresolved() error {
+	lookups := map[string]map[string]bool{}
+	walkTree(w.records, func(r *treeRecord) {
+		lookups[r.Type] = map[string]bool{}
+	})
+	for t := range lookups {
+		describe, err := w.describe(t)
+		if err != nil {
+			return err
+		}
+		for _, f := range describe.Fields {
+			if fieldType(f) == "reference" {
+				lookups[t][strings.ToLower(f.Name)] = true
+			}
+		}
+	}
+	dropped := map[string]int{}
+	walkTree(w.records, func(r *treeRecord) {
+		fields := []string{}
+		for _, f := range r.Fields {
+			if lookups[r.Type][strings.ToLower(f)] && !strings.HasPrefix(r.Values[f], treeReferencePrefix) {
+				dropped[r.Type+"."+f]++
+				continue
+			}
+			fields = append(fields, f)
+		}
+		r.Fields = fields
+	})
+	names := []string{}
+	for name := range dropped {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w.warnings, "%s is not exported for %d records, since the records it refers to are not exported\n", name, dropped[name])
+	}
+	return nil
+}
+
+func walkTree(records []*treeRecord, fn func(r *treeRecord)) {
+	for _, r := range records {
+		fn(r)
+		for _, children := range r.Children {
+			walkTree(children.Records, fn)
+		}
+	}
+}
+
+func exportTree(c *cli.Context) error {
+	if err := validateExportTreeCommand(c); err != nil {
+		return err
+	}
+	client := newClient(c)
+	if err := login(client, c); err != nil {
+		return err
+	}
+	retry := newRetrier(c, client)
+	fp, err := os.Create(c.String("file"))
+	if err != nil {
+		return err
+	}
+	w := newTreeWriter(fp, func(object string) (*soapforce.DescribeSObjectResult, error) {
+		return describeSObject(client, object)
+	})
+	w.fp = fp
+	for _, original := range c.StringSlice("query") {
+		q, err := buildQuery(client, original)
+		if err != nil {
+			fp.Close()
+			return err
+		}
+		soql, err := parseSoql(q)
+		if err != nil {
+			fp.Close()
+			return err
+		}
+		w.Query(soql)
+		err = queryPages(client, retry, q, func(records []*soapforce.SObject) error {
+			for _, record := range records {
+				if err := fetchChildRecords(client, retry, record); err != nil {
+					return err
+				}
+				if err := w.Write(soql.Columns(), record); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			fp.Close()
+			return err
+		}
+	}
+	return w.Close()
+}
+
+func validateExportTreeCommand(c *cli.Context) error {
+	if err := validateLoginFlag(c, "export-tree"); err != nil {
+		return err
+	}
+	queries := c.StringSlice("query")
+	if len(queries) == 0 {
+		_ = cli.ShowCommandHelp(c, "export-tree")
+		return cli.NewExitError("query is required", 1)
+	}
+	if c.String("file") == "" {
+		_ = cli.ShowCommandHelp(c, "export-tree")
+		return cli.NewExitError("file is required", 1)
+	}
+	for _, q := range queries {
+		soql, err := parseSoql(q)
+		if err != nil {
+			_ = cli.ShowCommandHelp(c, "export-tree")
+			return cli.NewExitError(fmt.Sprintf("Malformed Query: %s", err), 1)
+		}
+		if err := validateTreeQuery(soql); err != nil {
+			return cli.NewExitError(err.Error(), 1)
+		}
+	}
+	return nil
+}
+
+// validateTreeQuery checks that the records of the query and its subqueries
+// have Ids, which are replaced with the reference Ids.
+func validateTreeQuery(soql *soqlQuery) error {
+	err := soql.validateRecords()
+	if err == errSoqlAggregate {
+		return fmt.Errorf("%s: aggregate queries cannot be exported as a tree", soql.From)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %s", soql.From, err)
+	}
+	for _, item := range soql.Fields {
+		if item.Subquery != nil {
+			if err := validateTreeQuery(item.Subquery); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// treeNode is a record of the document with the link to its parent.
+type treeNode struct {
+	record *treeRecord
+	// deferred are the references set by update after every record is created.
+	deferred []string
+}
+
+// treeImporter creates the records of a tree document, parents first.
+type treeImporter struct {
+	// childField returns the lookup field of the children of a relationship.
+	childField func(parentType string, relationship string) (string, error)
+	newSObject func(object string, headers []string, values []string) *soapforce.SObject
+	create     func(sobjects []*soapforce.SObject) ([]*soapforce.SaveResult, error)
+	update     func(sobjects []*soapforce.SObject) ([]*soapforce.SaveResult, error)
+	success    *csv.Writer
+	errors     *csv.Writer
+	out        io.Writer
+
+	refs   map[string]bool
+	ids    map[string]string
+	failed map[string]bool
+	counts map[string]*migrationStats
+	types  []string
+}
+
+func readTreeDocument(path string) (*treeDocument, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	doc := &treeDocument{}
+	if err := json.Unmarshal(b, doc); err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return doc, nil
+}
+
+// nodes flattens the document, setting the lookup fields of the children to their parents.
+func (im *treeImporter) nodes(doc *treeDocument) ([]*treeNode, error) {
+	nodes := []*treeNode{}
+	refs := map[string]bool{}
+	var add func(records []*treeRecord) error
+	add = func(records []*treeRecord) error {
+		for _, r := range records {
+			if refs[r.ReferenceId] {
+				return fmt.Errorf("%s: reference Id is duplicated", r.ReferenceId)
+			}
+			refs[r.ReferenceId] = true
+			nodes = append(nodes, &treeNode{record: r})
+			for _, children := range r.Children {
+				field, err := im.childField(r.Type, children.Relationship)
+				if err != nil {
+					return err
+				}
+				for _, child := range children.Records {
+					if _, ok := child.Values[field]; !ok {
+						child.Fields = append(child.Fields, field)
+					}
+					child.Values[field] = treeReferencePrefix + r.ReferenceId
+				}
+				if err := add(children.Records); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	if err := add(doc.Records); err != nil {
+		return nil, err
+	}
+	im.refs = refs
+	return nodes, nil
+}
+
+// reference returns the reference Id of the value, or "" for a literal value.
+// Values starting with @ which are not reference Ids of the document are literal.
+func (im *treeImporter) reference(value string) string {
+	if !strings.HasPrefix(value, treeReferencePrefix) || !im.refs[value[len(treeReferencePrefix):]] {
+		return ""
+	}
+	return value[len(treeReferencePrefix):]
+}
+
+func (im *treeImporter) Import(doc *treeDocument) error {
+	nodes, err := im.nodes(doc)
+	if err != nil {
+		return err
+	}
+	im.ids = map[string]string{}
+	im.failed = map[string]bool{}
+	im.counts = map[string]*migrationStats{}
+	created := []*treeNode{}
+	pending := nodes
+	for len(pending) > 0 {
+		ready := []*treeNode{}
+		waiting := []*treeNode{}
+		for _, n := range pending {
+			if parent := im.failedParent(n); parent != "" {
+				if err := im.fail(n, fmt.Sprintf("%s is not created", parent)); err != nil {
+					return err
+				}
+				continue
+			}
+			if im.resolved(n) {
+				ready = append(ready, n)
+			} else {
+				waiting = append(waiting, n)
+			}
+		}
+		if len(ready) == 0 && len(waiting) > 0 {
+			// circular references are set after the records are created
+			ready = waiting
+			waiting = nil
+			for _, n := range ready {
+				for _, f := range n.record.Fields {
+					if ref := im.reference(n.record.Values[f]); ref != "" {
+						if _, ok := im.ids[ref]; !ok {
+							n.deferred = append(n.deferred, f)
+						}
+					}
+				}
+			}
+		}
+		if err := im.createNodes(ready); err != nil {
+			return err
+		}
+		for _, n := range ready {
+			if len(n.deferred) > 0 && !im.failed[n.record.ReferenceId] {
+				created = append(created, n)
+			}
+		}
+		pending = waiting
+	}
+	if err := im.updateDeferred(created); err != nil {
+		return err
+	}
+	failed := 0
+	for _, t := range im.types {
+		stats := im.counts[t]
+		fmt.Fprintf(im.out, "%s: %d created, %d failed\n", t, stats.created, stats.failed)
+		failed += stats.failed
+	}
+	if failed > 0 {
+		return cli.NewExitError(fmt.Sprintf("%d records failed to import", failed), 1)
+	}
+	return nil
+}
+
+func (im *treeImporter) failedParent(n *treeNode) string {
+	for _, f := range n.record.Fields {
+		if ref := im.reference(n.record.Values[f]); ref != "" && im.failed[ref] {
+			return ref
+		}
+	}
+	return ""
+}
+
+func (im *treeImporter) resolved(n *treeNode) bool {
+	for _, f := range n.record.Fields {
+		if ref := im.reference(n.record.Values[f]); ref != "" {
+			if _, ok := im.ids[ref]; !ok {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// sobject builds the record, replacing the references with the created Ids.
+func (im *treeImporter) sobject(n *treeNode, fields []string, skip []string) *soapforce.SObject {
+	headers := []string{}
+	values := []string{}
+	for _, f := range fields {
+		if containsString(skip, f) {
+			continue
+		}
+		v := n.record.Values[f]
+		if ref := im.reference(v); ref != "" {
+			v = im.ids[ref]
+		}
+		headers = append(headers, f)
+		values = append(values, v)
+	}
+	return im.newSObject(n.record.Type, headers, values)
+}
+
+// createNodes creates the records in batches of the same type.
+func (im *treeImporter) createNodes(nodes []*treeNode) error {
+	byType := map[string][]*treeNode{}
+	types := []string{}
+	for _, n := range nodes {
+		if _, ok := byType[n.record.Type]; !ok {
+			types = append(types, n.record.Type)
+		}
+		byType[n.record.Type] = append(byType[n.record.Type], n)
+	}
+	for _, t := range types {
+		nodes := byType[t]
+		for start := 0; start < len(nodes); start += dmlBatchSize {
+			end := start + dmlBatchSize
+			if end > len(nodes) {
+				end = len(nodes)
+			}
+			batch := nodes[start:end]
+			sobjects := make([]*soapforce.SObject, len(batch))
+			for i, n := range batch {
+				sobjects[i] = im.sobject(n, n.record.Fields, n.deferred)
+			}
+			results, err := im.create(sobjects)
+			if err != nil {
+				return err
+			}
+			for i, result := range results {
+				n := batch[i]
+				if result == nil || !result.Success {
+					if err := im.fail(n, saveErrorMessage(result)); err != nil {
+						return err
+					}
+					continue
+				}
+				im.ids[n.record.ReferenceId] = result.Id
+				im.stats(t).created++
+				if im.success != nil {
+					im.success.Write([]string{n.record.ReferenceId, t, result.Id})
+					im.success.Flush()
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (im *treeImporter) updateDeferred(nodes []*treeNode) error {
+	for start := 0; start < len(nodes); start += dmlBatchSize {
+		end := start + dmlBatchSize
+		if end > len(nodes) {
+			end = len(nodes)
+		}
+		batch := nodes[start:end]
+		sobjects := make([]*soapforce.SObject, len(batch))
+		for i, n := range batch {
+			sobjects[i] = im.sobject(n, n.deferred, nil)
+			sobjects[i].Id = im.ids[n.record.ReferenceId]
+		}
+		results, err := im.update(sobjects)
+		if err != nil {
+			return err
+		}
+		for i, result := range results {
+			if result == nil || !result.Success {
+				n := batch[i]
+				im.stats(n.record.Type).created--
+				if err := im.fail(n, saveErrorMessage(result)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (im *treeImporter) stats(t string) *migrationStats {
+	stats, ok := im.counts[t]
+	if !ok {
+		stats = &migrationStats{}
+		im.counts[t] = stats
+		im.types = append(im.types, t)
+	}
+	return stats
+}
+
+func (im *treeImporter) fail(n *treeNode, message string) error {
+	im.failed[n.record.ReferenceId] = true
+	im.stats(n.record.Type).failed++
+	if im.errors == nil {
+		return nil
+	}
+	im.errors.Write([]string{n.record.ReferenceId, n.record.Type, message})
+	im.errors.Flush()
+	return im.errors.Error()
+}
+
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func importTree(c *cli.Context) error {
+	if err := validateLoginFlag(c, "import-tree"); err != nil {
+		return err
+	}
+	if c.String("file") == "" {
+		_ = cli.ShowCommandHelp(c, "import-tree")
+		return cli.NewExitError("file is required", 1)
+	}
+	doc, err := readTreeDocument(c.String("file"))
+	if err != nil {
+		return err
+	}
+	client := newClient(c)
+	if err := login(client, c); err != nil {
+		return err
+	}
+	retry := newRetrier(c, client)
+
+	successFile, success, err := createCsvWriter(c.String("success-file"), c.String("encoding"), 0)
+	if err != nil {
+		return err
+	}
+	if successFile != nil {
+		defer successFile.Close()
+	}
+	success.Write([]string{"ReferenceId", "Type", "Id"})
+	errorFile, errors, err := createCsvWriter(c.String("error-file"), c.String("encoding"), 0)
+	if err != nil {
+		return err
+	}
+	if errorFile != nil {
+		defer errorFile.Close()
+	}
+	errors.Write([]string{"ReferenceId", "Type", "Error"})
+
+	describes := map[string]*soapforce.DescribeSObjectResult{}
+	describe := func(t string) (*soapforce.DescribeSObjectResult, error) {
+		if d, ok := describes[strings.ToLower(t)]; ok {
+			return d, nil
+		}
+		var d *soapforce.DescribeSObjectResult
+		err := retry.Do(func() error {
+			var err error
+			d, err = describeSObject(client, t)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		describes[strings.ToLower(t)] = d
+		return d, nil
+	}
+	im := &treeImporter{
+		childField: func(parentType string, relationship string) (string, error) {
+			d, err := describe(parentType)
+			if err != nil {
+				return "", err
+			}
+			r, err := findChildRelationship(d, relationship)
+			if err != nil {
+				return "", err
+			}
+			if _, err := describe(r.ChildSObject); err != nil {
+				return "", err
+			}
+			return r.Field, nil
+		},
+		newSObject: func(object string, headers []string, values []string) *soapforce.SObject {
+			return createInsertSObject(client, object, headers, values, false)
+		},
+		create: saveSObjects(retry, func(sobjects []*soapforce.SObject) ([]*soapforce.SaveResult, error) {
+			res, err := client.Create(sobjects)
+			return res, createOnce(err)
+		}),
+		update:  saveSObjects(retry, client.Update),
+		success: success,
+		errors:  errors,
+		out:     os.Stdout,
+	}
+	return im.Import(doc)
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/tzmfreedom/go-soapforce"
+)
+
+func TestTreeWriter(t *testing.T) {
+	soql, err := parseSoql("SELECT Id, Name, ParentId, OwnerId, Owner.Name, (SELECT Id, LastName, AccountId FROM Contacts) FROM Account")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	buf := &bytes.Buffer{}
+	warnings := &bytes.Buffer{}
+	w := newTreeWriter(buf, func(object string) (*soapforce.DescribeSObjectResult, error) {
+		describe := &soapforce.DescribeSObjectResult{Name: object}
+		for _, name := range []string{"Name", "LastName"} {
+			describe.Fields = append(describe.Fields, newDescribeField(name, "string", soapforce.Field{}))
+		}
+		for _, name := range []string{"ParentId", "OwnerId", "AccountId"} {
+			describe.Fields = append(describe.Fields, newDescribeField(name, "reference", soapforce.Field{}))
+		}
+		return describe, nil
+	})
+	w.warnings = warnings
+	w.Query(soql)
+	records := []*soapforce.SObject{
+		{
+			Type: "Account",
+			Id:   "001000000000001",
+			Fields: map[string]interface{}{
+				"Name":    "Parent",
+				"OwnerId": "005000000000001",
+				"Owner":   &soapforce.SObject{Fields: map[string]interface{}{"Name": "Admin"}},
+				"Contacts": &soapforce.QueryResult{Records: []*soapforce.SObject{
+					{Type: "Contact", Id: "003000000000001", Fields: map[string]interface{}{"LastName": "Smith", "AccountId": "001000000000001"}},
+				}},
+			},
+		},
+		{
+			Type:   "Account",
+			Id:     "001000000000002",
+			Fields: map[string]interface{}{"Name": "Child", "ParentId": "001000000000001"},
+		},
+		{
+			Type:   "Account",
+			Id:     "001000000000003",
+			Fields: map[string]interface{}{"Name": "Other", "ParentId": "001000000000009", "OwnerId": "005000000000001"},
+		},
+	}
+	for _, record := range records {
+		if err := w.Write(soql.Columns(), record); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := `{
+  "records": [
+    {
+      "attributes": {
+        "type": "Account",
+        "referenceId": "AccountRef1"
+      },
+      "Name": "Parent",
+      "Contacts": {
+        "records": [
+          {
+            "attributes": {
+              "type": "Contact",
+              "referenceId": "ContactRef1"
+            },
+            "LastName": "Smith",
+            "AccountId": "@AccountRef1"
+          }
+        ]
+      }
+    },
+    {
+      "attributes": {
+        "type": "Account",
+        "referenceId": "AccountRef2"
+      },
+      "Name": "Child",
+      "ParentId": "@AccountRef1"
+    },
+    {
+      "attributes": {
+        "type": "Account",
+        "referenceId": "AccountRef3"
+      },
+      "Name": "Other"
+    }
+  ]
+}
+`
+	if actual := buf.String(); actual != expected {
+		t.Fatalf("expected: '%s', but '%s'", expected, actual)
+	}
+	expected = "Account.OwnerId is not exported for 2 records, since the records it refers to are not exported\n" +
+		"Account.ParentId is not exported for 1 records, since the records it refers to are not exported\n"
+	if actual := warnings.String(); actual != expected {
+		t.Fatalf("expected: '%s', but '%s'", expected, actual)
+	}
+}
+
+func TestUnmarshalTreeDocument(t *testing.T) {
+	src := `{"records": [{"attributes": {"type": "Account", "referenceId": "A1"}, "Name": "Acme",
+		"NumberOfEmployees": 10, "Description": null,
+		"Contacts": {"records": [{"attributes": {"type": "Contact", "referenceId": "C1"}, "LastName": "Smith"}]}}]}`
+	doc := &treeDocument{}
+	if err := json.Unmarshal([]byte(src), doc); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(doc.Records) != 1 {
+		t.Fatalf("unexpected records: %v", doc.Records)
+	}
+	r := doc.Records[0]
+	if r.Type != "Account" || r.ReferenceId != "A1" {
+		t.Fatalf("unexpected attributes: %s %s", r.Type, r.ReferenceId)
+	}
+	if !reflect.DeepEqual(r.Fields, []string{"Name", "NumberOfEmployees"}) || r.Values["NumberOfEmployees"] != "10" {
+		t.Fatalf("unexpected fields: %v %v", r.Fields, r.Values)
+	}
+	if len(r.Children) != 1 || r.Children[0].Relationship != "Contacts" || r.Children[0].Records[0].Values["LastName"] != "Smith" {
+		t.Fatalf("unexpected children: %v", r.Children)
+	}
+
+	err := json.Unmarshal([]byte(`{"records": [{"Name": "Acme"}]}`), doc)
+	expected := "attributes.type and attributes.referenceId are required"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("expected: '%s', but '%v'", expected, err)
+	}
+}
+
+type fakeTreeOrg struct {
+	created  []string
+	updated  []string
+	failures map[string]bool
+	count    int
+}
+
+func (o *fakeTreeOrg) save(sobjects []*soapforce.SObject, update bool) ([]*soapforce.SaveResult, error) {
+	results := make([]*soapforce.SaveResult, len(sobjects))
+	for i, s := range sobjects {
+		name := s.Fields["Name"]
+		if o.failures[fmt.Sprint(name)] {
+			results[i] = &soapforce.SaveResult{Errors: []*soapforce.Error{{Message: "failed"}}}
+			continue
+		}
+		keys := []string{}
+		for k, v := range s.Fields {
+			keys = append(keys, fmt.Sprintf("%s=%v", k, v))
+		}
+		sort.Strings(keys)
+		if update {
+			o.updated = append(o.updated, s.Id+" "+strings.Join(keys, " "))
+			results[i] = &soapforce.SaveResult{Success: true, Id: s.Id}
+			continue
+		}
+		o.count++
+		id := fmt.Sprintf("%s%d", s.Type[:3], o.count)
+		o.created = append(o.created, id+" "+strings.Join(keys, " "))
+		results[i] = &soapforce.SaveResult{Success: true, Id: id}
+	}
+	return results, nil
+}
+
+func newFakeTreeImporter(org *fakeTreeOrg, out *bytes.Buffer, errors *bytes.Buffer) *treeImporter {
+	return &treeImporter{
+		childField: func(parentType string, relationship string) (string, error) {
+			if parentType == "Account" && relationship == "Contacts" {
+				return "AccountId", nil
+			}
+			return "", fmt.Errorf("%s has no child relationship %s", parentType, relationship)
+		},
+		newSObject: func(object string, headers []string, values []string) *soapforce.SObject {
+			fields := map[string]interface{}{}
+			for i, h := range headers {
+				fields[h] = values[i]
+			}
+			return &soapforce.SObject{Type: object, Fields: fields}
+		},
+		create: func(sobjects []*soapforce.SObject) ([]*soapforce.SaveResult, error) {
+			return org.save(sobjects, false)
+		},
+		update: func(sobjects []*soapforce.SObject) ([]*soapforce.SaveResult, error) {
+			return org.save(sobjects, true)
+		},
+		errors: csv.NewWriter(errors),
+		out:    out,
+	}
+}
+
+func TestImportTree(t *testing.T) {
+	src := `{"records": [
+		{"attributes": {"type": "Contact", "referenceId": "C0"}, "Name": "Assistant", "AccountId": "@A2"},
+		{"attributes": {"type": "Account", "referenceId": "A1"}, "Name": "Acme", "Description": "@home",
+			"Contacts": {"records": [{"attributes": {"type": "Contact", "referenceId": "C1"}, "Name": "Smith"}]}},
+		{"attributes": {"type": "Account", "referenceId": "A2"}, "Name": "Sub", "ParentId": "@A1"}
+	]}`
+	doc := &treeDocument{}
+	if err := json.Unmarshal([]byte(src), doc); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	org := &fakeTreeOrg{}
+	out := &bytes.Buffer{}
+	im := newFakeTreeImporter(org, out, &bytes.Buffer{})
+	if err := im.Import(doc); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := []string{
+		"Acc1 Description=@home Name=Acme",
+		"Con2 AccountId=Acc1 Name=Smith",
+		"Acc3 Name=Sub ParentId=Acc1",
+		"Con4 AccountId=Acc3 Name=Assistant",
+	}
+	if !reflect.DeepEqual(org.created, expected) {
+		t.Fatalf("expected: '%v', but '%v'", expected, org.created)
+	}
+	if actual := out.String(); actual != "Account: 2 created, 0 failed\nContact: 2 created, 0 failed\n" {
+		t.Fatalf("unexpected output: '%s'", actual)
+	}
+}
+
+func TestImportTreeCycle(t *testing.T) {
+	src := `{"records": [
+		{"attributes": {"type": "Account", "referenceId": "A1"}, "Name": "First", "ParentId": "@A2"},
+		{"attributes": {"type": "Account", "referenceId": "A2"}, "Name": "Second", "ParentId": "@A1"}
+	]}`
+	doc := &treeDocument{}
+	if err := json.Unmarshal([]byte(src), doc); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	org := &fakeTreeOrg{}
+	im := newFakeTreeImporter(org, &bytes.Buffer{}, &bytes.Buffer{})
+	if err := im.Import(doc); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := []string{"Acc1 Name=First", "Acc2 Name=Second"}
+	if !reflect.DeepEqual(org.created, expected) {
+		t.Fatalf("expected: '%v', but '%v'", expected, org.created)
+	}
+	expected = []string{"Acc1 ParentId=Acc2", "Acc2 ParentId=Acc1"}
+	if !reflect.DeepEqual(org.updated, expected) {
+		t.Fatalf("expected: '%v', but '%v'", expected, org.updated)
+	}
+}
+
+func TestImportTreeFailure(t *testing.T) {
+	src := `{"records": [
+		{"attributes": {"type": "Account", "referenceId": "A1"}, "Name": "Broken",
+			"Contacts": {"records": [{"attributes": {"type": "Contact", "referenceId": "C1"}, "Name": "Smith"}]}},
+		{"attributes": {"type": "Account", "referenceId": "A2"}, "Name": "Fine"}
+	]}`
+	doc := &treeDocument{}
+	if err := json.Unmarshal([]byte(src), doc); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	org := &fakeTreeOrg{failures: map[string]bool{"Broken": true}}
+	errors := &bytes.Buffer{}
+	im := newFakeTreeImporter(org, &bytes.Buffer{}, errors)
+	err := im.Import(doc)
+	if err == nil || err.Error() != "2 records failed to import" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(org.created, []string{"Acc1 Name=Fine"}) {
+		t.Fatalf("unexpected records: %v", org.created)
+	}
+	expected := "A1,Account,: failed\nC1,Contact,A1 is not created\n"
+	if actual := errors.String(); actual != expected {
+		t.Fatalf("expected: '%s', but '%s'", expected, actual)
+	}
+
+	doc = &treeDocument{}
+	if err := json.Unmarshal([]byte(`{"records": [{"attributes": {"type": "Account", "referenceId": "A1"}, "Opportunities": {"records": []}}]}`), doc); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	err = newFakeTreeImporter(org, &bytes.Buffer{}, &bytes.Buffer{}).Import(doc)
+	if err == nil || err.Error() != "Account has no child relationship Opportunities" {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
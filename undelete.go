@@ -1,8 +1,6 @@
 package main
 
 import (
-	"io"
-
 	"github.com/tzmfreedom/go-soapforce"
 	"github.com/urfave/cli"
 )
@@ -11,59 +9,66 @@ func undelete(c *cli.Context) error {
 	if err := validateUndeleteCommand(c); err != nil {
 		return err
 	}
+	if c.Bool("dry-run") {
+		return dryRun(c, "undelete")
+	}
 	client := newClient(c)
 	if err := login(client, c); err != nil {
 		return err
 	}
 
-	reader, err := getReader(c)
+	in, err := openDmlInput(c, "undelete")
 	if err != nil {
 		return err
 	}
-	defer reader.Close()
+	defer in.Close()
+	headers := in.headers
 
-	sobjects := make([]*soapforce.SObject, 0)
-	headers, err := reader.Read()
-	if err != nil {
-		return err
-	}
-	headers, err = mapping(headers, c.String("mapping"))
-	if err != nil {
-		return err
-	}
-	var ids []string
-	handler, err := getResponseHandler(c)
+	retry := newRetrier(c, client)
+	in.resolver = newReferenceResolver(in.mapping, newSoapQuerier(client, retry))
+	jnl, err := openJournal(c.String("journal"), c.Bool("resume"), newSoapQuerier(client, retry))
 	if err != nil {
 		return err
 	}
-	for {
-		fields, err := reader.Read()
-		if err == io.EOF {
-			break
+	defer jnl.Close()
+	t := c.String("type")
+	runner := newDmlRunner(c, in, retry, func(batch *dmlBatch) (dmlResult, error) {
+		ids := make([]string, len(batch.records))
+		for i, fields := range batch.records {
+			ids[i] = getId(headers, fields)
 		}
+		jb, err := jnl.Batch()
 		if err != nil {
-			return err
+			return nil, err
 		}
-		id := getId(headers, fields)
-		ids = append(ids, id)
-		if len(sobjects) == 200 {
-			res, err := client.Undelete(ids)
-			if err != nil {
-				return nil
-			}
-			err = handler.HandleUndelete(res)
-			if err != nil {
-				return nil
+		if err := jb.Begin("undelete", t, ids, nil); err != nil {
+			return nil, err
+		}
+		res, err := undeleteIds(client, retry, ids)
+		if err != nil {
+			return nil, err
+		}
+		undeleted := []string{}
+		for _, result := range res {
+			if result != nil && result.Success {
+				undeleted = append(undeleted, result.Id)
 			}
-			ids = ids[:0]
 		}
-	}
-	res, err := client.Undelete(ids)
-	if err != nil {
-		return err
-	}
-	err = handler.HandleUndelete(res)
-	return err
+		if err := jb.Record("undelete", t, undeleted); err != nil {
+			return nil, err
+		}
+		if err := jb.Commit(); err != nil {
+			return nil, err
+		}
+		return func(h responseHandler) error { return h.HandleUndelete(batch, res) }, nil
+	})
+	return runner.Run(in.reader)
+}
+
+func undeleteIds(client *soapforce.Client, retry *retrier, ids []string) ([]*soapforce.UndeleteResult, error) {
+	return undeleteRecords(retry, len(ids), func(indexes []int) ([]*soapforce.UndeleteResult, error) {
+		return client.Undelete(selectIds(ids, indexes))
+	})
 }
 
 func validateUndeleteCommand(c *cli.Context) error {
@@ -80,5 +85,8 @@ func validateUndeleteCommand(c *cli.Context) error {
 		_ = cli.ShowCommandHelp(c, "insert")
 		return cli.NewExitError("file is required", 1)
 	}
+	if err := validateDmlFlags(c, "undelete"); err != nil {
+		return err
+	}
 	return nil
 }
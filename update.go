@@ -1,8 +1,6 @@
 package main
 
 import (
-	"io"
-
 	"github.com/tzmfreedom/go-soapforce"
 	"github.com/urfave/cli"
 )
@@ -11,66 +9,96 @@ func update(c *cli.Context) error {
 	if err := validateUpdateCommand(c); err != nil {
 		return err
 	}
+	if c.Bool("dry-run") {
+		return dryRun(c, "update")
+	}
+	if c.String("api") == "bulk2" {
+		return bulk2Dml(c, "update")
+	}
 	client := newClient(c)
 	if err := login(client, c); err != nil {
 		return err
 	}
 
-	reader, err := getReader(c)
+	retry := newRetrier(c, client)
+	var in *dmlInput
+	var err error
+	if c.String("query") != "" {
+		in, err = openQueryDmlInput(c, "update", client, retry)
+	} else {
+		in, err = openDmlInput(c, "update")
+	}
 	if err != nil {
 		return err
 	}
-	defer reader.Close()
-
-	sobjects := []*soapforce.SObject{}
-	headers, err := reader.Read()
+	defer in.Close()
+	headers := in.headers
+	t := c.String("type")
+	insertNulls := c.Bool("insert-nulls")
+	describe, err := describeSObject(client, t)
 	if err != nil {
 		return err
 	}
-	headers, err = mapping(headers, c.String("mapping"))
+	in.converter, err = newValueConverterFromFlags(c, describe, in)
 	if err != nil {
 		return err
 	}
-	handler, err := getResponseHandler(c)
+	if err := validateRelationshipColumns(describe, in.headers); err != nil {
+		return err
+	}
+
+	in.resolver = newReferenceResolver(in.mapping, newSoapQuerier(client, retry))
+	jnl, err := openJournal(c.String("journal"), c.Bool("resume"), newSoapQuerier(client, retry))
 	if err != nil {
 		return err
 	}
-	t := c.String("type")
-	insertNulls := c.Bool("insert-nulls")
-	for {
-		fields, err := reader.Read()
-		if err == io.EOF {
-			break
+	defer jnl.Close()
+	journaled := journalFields(describe, headers)
+	runner := newDmlRunner(c, in, retry, func(batch *dmlBatch) (dmlResult, error) {
+		sobjects := make([]*soapforce.SObject, len(batch.records))
+		for i, fields := range batch.records {
+			sobjects[i] = createSObject(client, t, headers, fields, insertNulls)
+		}
+		ids := make([]string, len(sobjects))
+		for i, sobject := range sobjects {
+			ids[i] = sobject.Id
 		}
+		before, err := jnl.Before(t, "Id", journaled, ids)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		if fields == nil {
-			continue
+		jb, err := jnl.Batch()
+		if err != nil {
+			return nil, err
 		}
-		sobject := createSObject(client, t, headers, fields, insertNulls)
-		sobjects = append(sobjects, sobject)
-		if len(sobjects) == 200 {
-			res, err := client.Update(sobjects)
-			if err != nil {
-				return err
-			}
-			err = handler.Handle(res)
-			if err != nil {
-				return err
-			}
-			sobjects = sobjects[:0]
+		if err := jb.Begin("update", t, ids, before); err != nil {
+			return nil, err
 		}
-	}
-	res, err := client.Update(sobjects)
-	if err != nil {
-		return err
-	}
-	err = handler.Handle(res)
-	return err
+		res, err := updateSObjects(client, retry, sobjects)
+		if err != nil {
+			return nil, err
+		}
+		if err := jb.Record("update", t, savedIds(res)); err != nil {
+			return nil, err
+		}
+		if err := jb.Commit(); err != nil {
+			return nil, err
+		}
+		return func(h responseHandler) error { return h.Handle(batch, res) }, nil
+	})
+	return runner.Run(in.reader)
+}
+
+func updateSObjects(client *soapforce.Client, retry *retrier, sobjects []*soapforce.SObject) ([]*soapforce.SaveResult, error) {
+	return saveRecords(retry, len(sobjects), func(indexes []int) ([]*soapforce.SaveResult, error) {
+		return client.Update(selectSObjects(sobjects, indexes))
+	})
 }
 
 func validateUpdateCommand(c *cli.Context) error {
+	if c.String("query") != "" {
+		return validateQueryDmlFlags(c, "update")
+	}
 	if err := validateLoginFlag(c, "insert"); err != nil {
 		return err
 	}
@@ -84,5 +112,8 @@ func validateUpdateCommand(c *cli.Context) error {
 		_ = cli.ShowCommandHelp(c, "insert")
 		return cli.NewExitError("file is required", 1)
 	}
+	if err := validateDmlFlags(c, "update"); err != nil {
+		return err
+	}
 	return nil
 }
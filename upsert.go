@@ -1,7 +1,8 @@
 package main
 
 import (
-	"io"
+	"sort"
+	"strings"
 
 	"github.com/tzmfreedom/go-soapforce"
 	"github.com/urfave/cli"
@@ -11,61 +12,118 @@ func upsert(c *cli.Context) error {
 	if err := validateUpsertCommand(c); err != nil {
 		return err
 	}
+	if c.Bool("dry-run") {
+		return dryRun(c, "upsert")
+	}
+	if c.String("api") == "bulk2" {
+		return bulk2Dml(c, "upsert")
+	}
 	client := newClient(c)
 	if err := login(client, c); err != nil {
 		return err
 	}
 
-	reader, err := getReader(c)
+	in, err := openDmlInput(c, "upsert")
 	if err != nil {
 		return err
 	}
-	defer reader.Close()
-
-	sobjects := []*soapforce.SObject{}
-	headers, err := reader.Read()
+	defer in.Close()
+	headers := in.headers
+	t := c.String("type")
+	insertNulls := c.Bool("insert-nulls")
+	upsertKey := c.String("upsert-key")
+	describe, err := describeSObject(client, t)
 	if err != nil {
 		return err
 	}
-	headers, err = mapping(headers, c.String("mapping"))
+	in.converter, err = newValueConverterFromFlags(c, describe, in)
 	if err != nil {
 		return err
 	}
-	handler, err := getResponseHandler(c)
+	if err := validateRelationshipColumns(describe, in.headers); err != nil {
+		return err
+	}
+
+	retry := newRetrier(c, client)
+	in.resolver = newReferenceResolver(in.mapping, newSoapQuerier(client, retry))
+	jnl, err := openJournal(c.String("journal"), c.Bool("resume"), newSoapQuerier(client, retry))
 	if err != nil {
 		return err
 	}
-	t := c.String("type")
-	insertNulls := c.Bool("insert-nulls")
-	upsertKey := c.String("upsert-key")
-	for {
-		fields, err := reader.Read()
-		if err == io.EOF {
-			break
+	defer jnl.Close()
+	journaled := journalFields(describe, headers)
+	key := -1
+	for i, h := range headers {
+		if strings.EqualFold(h, upsertKey) {
+			key = i
+		}
+	}
+	runner := newDmlRunner(c, in, retry, func(batch *dmlBatch) (dmlResult, error) {
+		sobjects := make([]*soapforce.SObject, len(batch.records))
+		for i, fields := range batch.records {
+			sobjects[i] = createSObject(client, t, headers, fields, insertNulls)
+		}
+		keys := make([]string, 0, len(batch.records))
+		for _, fields := range batch.records {
+			if key >= 0 {
+				keys = append(keys, fields[key])
+			}
 		}
+		before, err := jnl.Before(t, upsertKey, journaled, keys)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		sobject := createSObject(client, t, headers, fields, insertNulls)
-		sobjects = append(sobjects, sobject)
-		if len(sobjects) == 200 {
-			res, err := client.Upsert(sobjects, upsertKey)
-			if err != nil {
-				return err
+		jb, err := jnl.Batch()
+		if err != nil {
+			return nil, err
+		}
+		// the records which are not found by the key are inserted
+		existing := []string{}
+		for id := range before {
+			existing = append(existing, id)
+		}
+		sort.Strings(existing)
+		if err := jb.Begin("update", t, existing, before); err != nil {
+			return nil, err
+		}
+		if err := jb.BeginInsert(t, len(sobjects)-len(existing)); err != nil {
+			return nil, err
+		}
+		res, err := upsertRecords(retry, len(sobjects), func(indexes []int) ([]*soapforce.UpsertResult, error) {
+			results, err := client.Upsert(selectSObjects(sobjects, indexes), upsertKey)
+			// a record created by a lost call is updated when it is sent again
+			// with its external Id, but created again without an Id
+			if strings.EqualFold(upsertKey, "Id") {
+				return results, createOnce(err)
 			}
-			err = handler.HandleUpsert(res)
-			if err != nil {
-				return err
+			return results, err
+		})
+		if err != nil {
+			return nil, err
+		}
+		created := []string{}
+		updated := []string{}
+		for _, result := range res {
+			switch {
+			case result == nil || !result.Success:
+			case result.Created:
+				created = append(created, result.Id)
+			default:
+				updated = append(updated, result.Id)
 			}
-			sobjects = sobjects[:0]
 		}
-	}
-	res, err := client.Upsert(sobjects, upsertKey)
-	if err != nil {
-		return err
-	}
-	err = handler.HandleUpsert(res)
-	return err
+		if err := jb.Record("insert", t, created); err != nil {
+			return nil, err
+		}
+		if err := jb.Record("update", t, updated); err != nil {
+			return nil, err
+		}
+		if err := jb.Commit(); err != nil {
+			return nil, err
+		}
+		return func(h responseHandler) error { return h.HandleUpsert(batch, res) }, nil
+	})
+	return runner.Run(in.reader)
 }
 
 func validateUpsertCommand(c *cli.Context) error {
@@ -82,5 +140,8 @@ func validateUpsertCommand(c *cli.Context) error {
 		_ = cli.ShowCommandHelp(c, "insert")
 		return cli.NewExitError("file is required", 1)
 	}
+	if err := validateDmlFlags(c, "upsert"); err != nil {
+		return err
+	}
 	return nil
 }
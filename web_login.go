@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"runtime"
+	"time"
+)
+
+const (
+	defaultCallbackPort = 1717
+	callbackPath        = "/OAuth/Callback"
+	// webLoginTimeout is the time to wait for the user to log in on the browser.
+	webLoginTimeout = 5 * time.Minute
+)
+
+// webLogin is the OAuth authorization code flow with PKCE, whose code is
+// received by a listener on the loopback address.
+type webLogin struct {
+	endpoint     string
+	clientId     string
+	clientSecret string
+	redirectUri  string
+	verifier     string
+	state        string
+}
+
+func newWebLogin(endpoint string, clientId string, clientSecret string, listener net.Listener) (*webLogin, error) {
+	verifier, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+	state, err := randomString(16)
+	if err != nil {
+		return nil, err
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	return &webLogin{
+		endpoint:     endpoint,
+		clientId:     clientId,
+		clientSecret: clientSecret,
+		redirectUri:  fmt.Sprintf("http://127.0.0.1:%d%s", port, callbackPath),
+		verifier:     verifier,
+		state:        state,
+	}, nil
+}
+
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// codeChallenge returns the S256 challenge of the verifier.
+func codeChallenge(verifier string) string {
+	digest := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(digest[:])
+}
+
+func (w *webLogin) AuthorizeUrl() string {
+	params := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {w.clientId},
+		"redirect_uri":          {w.redirectUri},
+		"code_challenge":        {codeChallenge(w.verifier)},
+		"code_challenge_method": {"S256"},
+		"state":                 {w.state},
+	}
+	return oauthBaseUrl(w.endpoint) + "/services/oauth2/authorize?" + params.Encode()
+}
+
+// Wait serves the callback on the listener and returns the authorization code.
+func (w *webLogin) Wait(listener net.Listener, timeout time.Duration) (string, error) {
+	type callback struct {
+		code string
+		err  error
+	}
+	done := make(chan callback, 1)
+	mux := http.NewServeMux()
+	mux.HandleFunc(callbackPath, func(rw http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		var result callback
+		switch {
+		case q.Get("state") != w.state:
+			// a request which is not the redirect of this login is ignored
+			http.Error(rw, "state does not match", http.StatusBadRequest)
+			return
+		case q.Get("error") != "":
+			result.err = fmt.Errorf("%s: %s", q.Get("error"), q.Get("error_description"))
+		case q.Get("code") == "":
+			result.err = errors.New("code is not returned by the authorization endpoint")
+		default:
+			result.code = q.Get("code")
+		}
+		if result.err != nil {
+			fmt.Fprintf(rw, "Login failed: %s\n", result.err)
+		} else {
+			fmt.Fprintln(rw, "Logged in. You can close this window.")
+		}
+		select {
+		case done <- result:
+		default:
+		}
+	})
+	server := &http.Server{Handler: mux}
+	go server.Serve(listener)
+	defer server.Shutdown(context.Background())
+
+	select {
+	case result := <-done:
+		return result.code, result.err
+	case <-time.After(timeout):
+		return "", fmt.Errorf("login is not completed in %s", timeout)
+	}
+}
+
+// Exchange exchanges the authorization code for the tokens.
+func (w *webLogin) Exchange(code string) (*oauthToken, error) {
+	params := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"client_id":     {w.clientId},
+		"redirect_uri":  {w.redirectUri},
+		"code_verifier": {w.verifier},
+	}
+	if w.clientSecret != "" {
+		params.Set("client_secret", w.clientSecret)
+	}
+	return requestOAuthToken(oauthTokenUrl(w.endpoint), params)
+}
+
+// refreshOAuthToken requests a new access token with the refresh token.
+func refreshOAuthToken(endpoint string, clientId string, clientSecret string, refreshToken string) (*oauthToken, error) {
+	params := url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {refreshToken},
+		"client_id":     {clientId},
+	}
+	if clientSecret != "" {
+		params.Set("client_secret", clientSecret)
+	}
+	return requestOAuthToken(oauthTokenUrl(endpoint), params)
+}
+
+// openBrowser opens the URL with the default browser, which may fail on
+// headless environments, so the URL is printed as well.
+func openBrowser(u string) error {
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.Command("open", u).Start()
+	case "windows":
+		return exec.Command("rundll32", "url.dll,FileProtocolHandler", u).Start()
+	default:
+		return exec.Command("xdg-open", u).Start()
+	}
+}
+
+// loginWeb logs in on the browser and returns the tokens.
+func loginWeb(endpoint string, clientId string, clientSecret string, port int) (*oauthToken, error) {
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return nil, err
+	}
+	defer listener.Close()
+	w, err := newWebLogin(endpoint, clientId, clientSecret, listener)
+	if err != nil {
+		return nil, err
+	}
+	authorizeUrl := w.AuthorizeUrl()
+	fmt.Printf("Open the following URL to log in:\n%s\n", authorizeUrl)
+	_ = openBrowser(authorizeUrl)
+	code, err := w.Wait(listener, webLoginTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return w.Exchange(code)
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWebLogin(t *testing.T) {
+	// challenge is sent to the authorization endpoint, which is checked with the verifier
+	challenge := ""
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.FormValue("grant_type") {
+		case "authorization_code":
+			if r.FormValue("code") != "auth-code" || r.FormValue("client_id") != "client-id" {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error":"invalid_grant","error_description":"invalid authorization code"}`))
+				return
+			}
+			if codeChallenge(r.FormValue("code_verifier")) != challenge {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error":"invalid_grant","error_description":"invalid code verifier"}`))
+				return
+			}
+			w.Write([]byte(`{"access_token":"00Dxx!access","refresh_token":"refresh","instance_url":"https://example.my.salesforce.com"}`))
+		case "refresh_token":
+			if r.FormValue("refresh_token") != "refresh" {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte(`{"error":"invalid_grant","error_description":"expired access/refresh token"}`))
+				return
+			}
+			w.Write([]byte(`{"access_token":"00Dxx!refreshed","instance_url":"https://example.my.salesforce.com"}`))
+		}
+	}))
+	defer server.Close()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer listener.Close()
+	login, err := newWebLogin(server.URL, "client-id", "", listener)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	u, err := url.Parse(login.AuthorizeUrl())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	q := u.Query()
+	challenge = q.Get("code_challenge")
+	if u.Path != "/services/oauth2/authorize" || q.Get("code_challenge_method") != "S256" || challenge == "" {
+		t.Fatalf("unexpected authorize url: %s", u)
+	}
+	if !strings.HasPrefix(q.Get("redirect_uri"), "http://127.0.0.1:") {
+		t.Fatalf("unexpected redirect_uri: %s", q.Get("redirect_uri"))
+	}
+
+	callback := q.Get("redirect_uri")
+	go func() {
+		// the redirect of another login is rejected
+		res, err := http.Get(callback + "?code=other&state=other")
+		if err == nil {
+			res.Body.Close()
+		}
+		res, err = http.Get(callback + "?code=auth-code&state=" + url.QueryEscape(q.Get("state")))
+		if err != nil {
+			t.Errorf("unexpected error: %s", err)
+			return
+		}
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+		if !strings.Contains(string(b), "Logged in") {
+			t.Errorf("unexpected response: %s", b)
+		}
+	}()
+	code, err := login.Wait(listener, 10*time.Second)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if code != "auth-code" {
+		t.Fatalf("expected: '%s', but '%s'", "auth-code", code)
+	}
+	token, err := login.Exchange(code)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if token.AccessToken != "00Dxx!access" || token.RefreshToken != "refresh" {
+		t.Fatalf("unexpected token: %v", token)
+	}
+
+	token, err = refreshOAuthToken(server.URL, "client-id", "", "refresh")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if token.AccessToken != "00Dxx!refreshed" {
+		t.Fatalf("expected: '%s', but '%s'", "00Dxx!refreshed", token.AccessToken)
+	}
+	_, err = refreshOAuthToken(server.URL, "client-id", "", "revoked")
+	expected := "invalid_grant: expired access/refresh token"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("expected: '%s', but '%v'", expected, err)
+	}
+}
+
+func TestWebLoginDenied(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer listener.Close()
+	login, err := newWebLogin("login.salesforce.com", "client-id", "", listener)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	callback := login.redirectUri
+	go func() {
+		res, err := http.Get(callback + "?error=access_denied&error_description=end-user+denied+authorization&state=" + login.state)
+		if err == nil {
+			res.Body.Close()
+		}
+	}()
+	_, err = login.Wait(listener, 10*time.Second)
+	expected := "access_denied: end-user denied authorization"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("expected: '%s', but '%v'", expected, err)
+	}
+}
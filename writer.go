@@ -2,8 +2,10 @@ package main
 
 import (
 	"encoding/csv"
+	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strings"
 
@@ -60,8 +62,8 @@ func (w *CsvWriter) Write(headers []string, record *soapforce.SObject) error {
 			if strings.Contains(h, ".") {
 				values[i] = getField(m, h)
 			} else {
-				if val := m.Get(h); val != nil {
-					values[i] = m.Get(h).(string)
+				if val, ok := m.Get(h).(string); ok {
+					values[i] = val
 				} else {
 					values[i] = ""
 				}
@@ -73,28 +75,22 @@ func (w *CsvWriter) Write(headers []string, record *soapforce.SObject) error {
 
 func getField(m *caseInsensitiveMap, h string) string {
 	keys := strings.Split(h, ".")
-	fields := keys[:len(keys)-2]
 	v := m.Get(keys[0])
-	if v == nil {
-		return ""
-	}
-	sobj := v.(*soapforce.SObject)
-
-	for _, field := range fields {
-		m = newCaseInsensitiveMap(sobj.Fields)
-		v = m.Get(field)
-		if v == nil {
+	for _, key := range keys[1:] {
+		sobj, ok := v.(*soapforce.SObject)
+		if !ok || sobj == nil {
 			return ""
 		}
-		sobj = v.(*soapforce.SObject)
+		if strings.ToLower(key) == "id" && sobj.Id != "" {
+			v = sobj.Id
+			continue
+		}
+		v = newCaseInsensitiveMap(sobj.Fields).Get(key)
 	}
-
-	m = newCaseInsensitiveMap(sobj.Fields)
-	v = m.Get(keys[len(keys)-1])
-	if v == nil {
-		return ""
+	if s, ok := v.(string); ok {
+		return s
 	}
-	return v.(string)
+	return ""
 }
 
 func (w *CsvWriter) Close() error {
@@ -236,8 +232,8 @@ func (w *XlsxWriter) Write(headers []string, record *soapforce.SObject) error {
 			if strings.Contains(h, ".") {
 				cell.Value = getField(m, h)
 			} else {
-				if val := m.Get(h); val != nil {
-					cell.Value = val.(string)
+				if val, ok := m.Get(h).(string); ok {
+					cell.Value = val
 				} else {
 					cell.Value = ""
 				}
@@ -278,6 +274,12 @@ func getWriteFields(r *soapforce.SObject) map[string]interface{} {
 			f[k] = sv
 		} else if sobj, ok := v.(*soapforce.SObject); ok {
 			f[k] = getWriteFields(sobj)
+		} else if qr, ok := v.(*soapforce.QueryResult); ok {
+			records := make([]map[string]interface{}, len(qr.Records))
+			for i, record := range qr.Records {
+				records[i] = getWriteFields(record)
+			}
+			f[k] = records
 		} else {
 			f[k] = v
 		}
@@ -285,6 +287,249 @@ func getWriteFields(r *soapforce.SObject) map[string]interface{} {
 	return f
 }
 
+// ChildRowsWriter writes one row per child record of the parent-to-child
+// subqueries, repeating the parent columns. Each row has the columns of one
+// relationship, and the columns of the other relationships are blank, since
+// the children of different relationships are not related to each other.
+type ChildRowsWriter struct {
+	w          writer
+	subqueries []*soqlQuery
+}
+
+func (w *ChildRowsWriter) Header(headers []string) error {
+	return w.w.Header(w.expandHeaders(headers))
+}
+
+func (w *ChildRowsWriter) Write(headers []string, record *soapforce.SObject) error {
+	headers = w.expandHeaders(headers)
+	m := newCaseInsensitiveMap(record.Fields)
+	newRow := func() *soapforce.SObject {
+		fields := map[string]interface{}{}
+		for k, v := range record.Fields {
+			if findSubquery(w.subqueries, k) == nil {
+				fields[k] = v
+			}
+		}
+		return &soapforce.SObject{Type: record.Type, Id: record.Id, Fields: fields}
+	}
+	written := false
+	for _, subquery := range w.subqueries {
+		qr, ok := m.Get(subquery.From).(*soapforce.QueryResult)
+		if !ok || qr == nil {
+			continue
+		}
+		for _, child := range qr.Records {
+			row := newRow()
+			row.Fields[subquery.From] = child
+			if err := w.w.Write(headers, row); err != nil {
+				return err
+			}
+			written = true
+		}
+	}
+	// the parent without children is written once
+	if !written {
+		return w.w.Write(headers, newRow())
+	}
+	return nil
+}
+
+func (w *ChildRowsWriter) expandHeaders(headers []string) []string {
+	expanded := []string{}
+	for _, h := range headers {
+		if subquery := findSubquery(w.subqueries, h); subquery != nil {
+			for _, column := range subquery.Columns() {
+				expanded = append(expanded, subquery.From+"."+column)
+			}
+		} else {
+			expanded = append(expanded, h)
+		}
+	}
+	return expanded
+}
+
+func (w *ChildRowsWriter) Close() error {
+	return w.w.Close()
+}
+
+// ChildFileWriter writes the records of each parent-to-child subquery into a
+// separate file whose first column is the parent Id, named after the lookup
+// field of the child relationship (e.g. AccountId of Contacts).
+type ChildFileWriter struct {
+	w          writer
+	subqueries []*soqlQuery
+	children   map[string]writer
+	parentKeys map[string]string
+}
+
+func (w *ChildFileWriter) Header(headers []string) error {
+	if err := w.w.Header(w.parentHeaders(headers)); err != nil {
+		return err
+	}
+	for _, subquery := range w.subqueries {
+		if err := w.children[subquery.From].Header(w.childHeaders(subquery)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (w *ChildFileWriter) Write(headers []string, record *soapforce.SObject) error {
+	if err := w.w.Write(w.parentHeaders(headers), record); err != nil {
+		return err
+	}
+	m := newCaseInsensitiveMap(record.Fields)
+	for _, subquery := range w.subqueries {
+		qr, ok := m.Get(subquery.From).(*soapforce.QueryResult)
+		if !ok || qr == nil {
+			continue
+		}
+		childHeaders := w.childHeaders(subquery)
+		for _, child := range qr.Records {
+			fields := map[string]interface{}{w.parentKeys[subquery.From]: record.Id}
+			for k, v := range child.Fields {
+				fields[k] = v
+			}
+			row := &soapforce.SObject{Type: child.Type, Id: child.Id, Fields: fields}
+			if err := w.children[subquery.From].Write(childHeaders, row); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (w *ChildFileWriter) parentHeaders(headers []string) []string {
+	parentHeaders := []string{}
+	for _, h := range headers {
+		if findSubquery(w.subqueries, h) == nil {
+			parentHeaders = append(parentHeaders, h)
+		}
+	}
+	return parentHeaders
+}
+
+func (w *ChildFileWriter) childHeaders(subquery *soqlQuery) []string {
+	parentKey := w.parentKeys[subquery.From]
+	headers := []string{parentKey}
+	for _, column := range subquery.Columns() {
+		if !strings.EqualFold(column, parentKey) {
+			headers = append(headers, column)
+		}
+	}
+	return headers
+}
+
+func (w *ChildFileWriter) Close() error {
+	for _, child := range w.children {
+		if err := child.Close(); err != nil {
+			return err
+		}
+	}
+	return w.w.Close()
+}
+
+func findSubquery(subqueries []*soqlQuery, relationship string) *soqlQuery {
+	for _, subquery := range subqueries {
+		if strings.EqualFold(subquery.From, relationship) {
+			return subquery
+		}
+	}
+	return nil
+}
+
+// getSubqueryWriter wraps tabular writers so that parent-to-child subquery
+// results are written as child rows or child files, according to --child-output.
+// JSON and YAML writers keep the children as nested arrays. The object is
+// described only for child files, to name their parent Id column.
+func getSubqueryWriter(c *cli.Context, w writer, soql *soqlQuery, describe func(object string) (*soapforce.DescribeSObjectResult, error)) (writer, error) {
+	subqueries := []*soqlQuery{}
+	for _, item := range soql.Fields {
+		if item.Subquery != nil {
+			subqueries = append(subqueries, item.Subquery)
+		}
+	}
+	if len(subqueries) == 0 {
+		return w, nil
+	}
+	switch w.(type) {
+	case *CsvWriter, *XlsxWriter:
+	default:
+		return w, nil
+	}
+
+	switch c.String("child-output") {
+	case "", "rows":
+		return &ChildRowsWriter{w: w, subqueries: subqueries}, nil
+	case "file":
+		d, err := describe(soql.From)
+		if err != nil {
+			return nil, err
+		}
+		children := map[string]writer{}
+		parentKeys := map[string]string{}
+		for _, subquery := range subqueries {
+			r, err := findChildRelationship(d, subquery.From)
+			if err != nil {
+				return nil, err
+			}
+			parentKeys[subquery.From] = r.Field
+		}
+		for _, subquery := range subqueries {
+			child, err := newChildFileWriter(c, subquery.From)
+			if err != nil {
+				return nil, err
+			}
+			children[subquery.From] = child
+		}
+		return &ChildFileWriter{
+			w:          w,
+			subqueries: subqueries,
+			children:   children,
+			parentKeys: parentKeys,
+		}, nil
+	default:
+		return nil, fmt.Errorf("child-output should be rows or file: %s", c.String("child-output"))
+	}
+}
+
+// newChildFileWriter creates the writer for a child relationship. The file is
+// named after --file with the relationship name appended (e.g. account_Contacts.csv),
+// or after the relationship alone when writing to stdout.
+func newChildFileWriter(c *cli.Context, relationship string) (writer, error) {
+	format := c.String("format")
+	file := c.String("file")
+	ext := ".csv"
+	switch format {
+	case "tsv", "t":
+		ext = ".tsv"
+	case "xlsx":
+		ext = ".xlsx"
+	}
+	path := relationship + ext
+	if file != "" {
+		path = strings.TrimSuffix(file, filepath.Ext(file)) + "_" + relationship + ext
+	}
+	if format == "xlsx" {
+		return newXlsxWriter(path, c.String("sheet"))
+	}
+	fp, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	comma := ','
+	if ext == ".tsv" {
+		comma = '\t'
+	}
+	w, err := newCsvWriter(c.String("encoding"), comma, fp)
+	if err != nil {
+		fp.Close()
+		return nil, err
+	}
+	w.fp = fp
+	return w, nil
+}
+
 func newWriterWithEncoding(w io.Writer, e string) io.Writer {
 	switch strings.ToUpper(e) {
 	case "SHIFT-JIS", "SHIFT_JIS", "SJIS":
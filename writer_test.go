@@ -168,3 +168,148 @@ func TestCaseInsentivieSet(t *testing.T) {
 		t.Fatalf("expected: '%s', but '%s'", expected, actual)
 	}
 }
+
+func newAccountWithContacts() *soapforce.SObject {
+	return &soapforce.SObject{
+		Id: "001",
+		Fields: map[string]interface{}{
+			"Name": "acme",
+			"Contacts": &soapforce.QueryResult{
+				Done: true,
+				Records: []*soapforce.SObject{
+					{Id: "003a", Fields: map[string]interface{}{"Email": "a@example.com"}},
+					{Id: "003b", Fields: map[string]interface{}{"Email": "b@example.com"}},
+				},
+			},
+		},
+	}
+}
+
+func TestChildRowsWrite(t *testing.T) {
+	soql, err := parseSoql("SELECT Id, Name, (SELECT Id, Email FROM Contacts) FROM Account")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	buf := new(bytes.Buffer)
+	csvWriter, err := newCsvWriter("utf8", ',', buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	writer := &ChildRowsWriter{w: csvWriter, subqueries: []*soqlQuery{soql.Fields[2].Subquery}}
+	headers := soql.Columns()
+	if err := writer.Header(headers); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := writer.Write(headers, newAccountWithContacts()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := writer.Write(headers, &soapforce.SObject{Id: "002", Fields: map[string]interface{}{"Name": "empty"}}); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	writer.Close()
+	expected := "Id,Name,Contacts.Id,Contacts.Email\n001,acme,003a,a@example.com\n001,acme,003b,b@example.com\n002,empty,,\n"
+	if buf.String() != expected {
+		t.Fatalf("expected: '%s', but '%s'", expected, buf.String())
+	}
+}
+
+func TestChildRowsWriteRelationships(t *testing.T) {
+	soql, err := parseSoql("SELECT Id, (SELECT Id, Email FROM Contacts), (SELECT Id FROM Opportunities) FROM Account")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	buf := new(bytes.Buffer)
+	csvWriter, err := newCsvWriter("utf8", ',', buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	writer := &ChildRowsWriter{w: csvWriter, subqueries: []*soqlQuery{soql.Fields[1].Subquery, soql.Fields[2].Subquery}}
+	headers := soql.Columns()
+	if err := writer.Header(headers); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	record := newAccountWithContacts()
+	record.Fields["Opportunities"] = &soapforce.QueryResult{Done: true, Records: []*soapforce.SObject{{Id: "006a"}}}
+	if err := writer.Write(headers, record); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	writer.Close()
+	expected := "Id,Contacts.Id,Contacts.Email,Opportunities.Id\n001,003a,a@example.com,\n001,003b,b@example.com,\n001,,,006a\n"
+	if buf.String() != expected {
+		t.Fatalf("expected: '%s', but '%s'", expected, buf.String())
+	}
+}
+
+func TestChildFileWrite(t *testing.T) {
+	soql, err := parseSoql("SELECT Id, Name, (SELECT Id, Email FROM Contacts) FROM Account")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	parentBuf := new(bytes.Buffer)
+	parentWriter, _ := newCsvWriter("utf8", ',', parentBuf)
+	childBuf := new(bytes.Buffer)
+	childWriter, _ := newCsvWriter("utf8", ',', childBuf)
+	writer := &ChildFileWriter{
+		w:          parentWriter,
+		subqueries: []*soqlQuery{soql.Fields[2].Subquery},
+		children:   map[string]writer{"Contacts": childWriter},
+		parentKeys: map[string]string{"Contacts": "AccountId"},
+	}
+	headers := soql.Columns()
+	if err := writer.Header(headers); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := writer.Write(headers, newAccountWithContacts()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	writer.Close()
+	expected := "Id,Name\n001,acme\n"
+	if parentBuf.String() != expected {
+		t.Fatalf("expected: '%s', but '%s'", expected, parentBuf.String())
+	}
+	expected = "AccountId,Id,Email\n001,003a,a@example.com\n001,003b,b@example.com\n"
+	if childBuf.String() != expected {
+		t.Fatalf("expected: '%s', but '%s'", expected, childBuf.String())
+	}
+}
+
+func TestJsonWriteWithChildren(t *testing.T) {
+	buf := new(bytes.Buffer)
+	writer, err := newJsonWriter(buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := writer.Write(nil, newAccountWithContacts()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	writer.Close()
+	expected := "[{\"Contacts\":[{\"Email\":\"a@example.com\",\"Id\":\"003a\"},{\"Email\":\"b@example.com\",\"Id\":\"003b\"}],\"Id\":\"001\",\"Name\":\"acme\"}]\n"
+	if buf.String() != expected {
+		t.Fatalf("expected: '%s', but '%s'", expected, buf.String())
+	}
+}
+
+func TestGetField(t *testing.T) {
+	m := newCaseInsensitiveMap(map[string]interface{}{
+		"Account": &soapforce.SObject{
+			Id: "001",
+			Fields: map[string]interface{}{
+				"Owner": &soapforce.SObject{
+					Fields: map[string]interface{}{"Name": "owner"},
+				},
+			},
+		},
+	})
+	testCases := map[string]string{
+		"Account.Id":         "001",
+		"Account.Owner.Name": "owner",
+		"Account.Name":       "",
+		"Parent.Name":        "",
+	}
+	for h, expected := range testCases {
+		actual := getField(m, h)
+		if actual != expected {
+			t.Fatalf("expected: '%s', but '%s'", expected, actual)
+		}
+	}
+}